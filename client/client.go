@@ -0,0 +1,521 @@
+// Package client is a Go client for the TimerMQ TCP protocol.
+//
+// A Client is safe for concurrent use. Commands are sent over a small pool of
+// connections which are re-dialed transparently when they break; commands
+// that are safe to repeat are retried on a fresh connection.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPoolSize     = 4
+	defaultDialTimeout  = 5 * time.Second
+	defaultTimeout      = 5 * time.Second
+	defaultMaxRetries   = 2
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+type Options struct {
+	// Addr is the host:port of the TimerMQ TCP listener.
+	Addr string
+	// PoolSize caps the number of connections used for commands.
+	PoolSize int
+	// DialTimeout bounds how long establishing a connection may take.
+	DialTimeout time.Duration
+	// Timeout is applied to commands whose context has no deadline.
+	Timeout time.Duration
+	// MaxRetries is how many times an idempotent command is retried after
+	// a connection failure. Negative values disable retries.
+	MaxRetries int
+	// RetryBackoff is the pause between retries and subscription reconnects.
+	RetryBackoff time.Duration
+	// TLS enables TLS with this config. ServerName defaults to the host of
+	// Addr, and a client certificate in Certificates identifies the client
+	// to servers verifying them.
+	TLS *tls.Config
+	// User and Secret are sent with AUTH on every connection the client
+	// opens, for servers that require authentication.
+	User   string
+	Secret string
+	// Dial overrides how connections are established.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (o Options) withDefaults() Options {
+	if o.PoolSize <= 0 {
+		o.PoolSize = defaultPoolSize
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = defaultDialTimeout
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = defaultRetryBackoff
+	}
+	if o.Dial == nil {
+		d := &net.Dialer{}
+		o.Dial = d.DialContext
+	}
+	if o.TLS != nil && o.TLS.ServerName == "" {
+		o.TLS = o.TLS.Clone()
+		o.TLS.ServerName, _, _ = net.SplitHostPort(o.Addr)
+	}
+	return o
+}
+
+type Client struct {
+	opts     Options
+	protocol adapters.Protocol
+	pool     *pool
+}
+
+// PushOpts are the optional args sent along with PUSH.
+type PushOpts struct {
+	// Queue defaults to the server's default queue.
+	Queue string
+	Delay time.Duration
+	// Jitter delays the message by a random amount below it, so that
+	// messages pushed with the same delay do not all fire at once. It
+	// defaults to the spread window of the queue.
+	Jitter time.Duration
+	// Priority orders the message among those due at the same time, from
+	// 0, the default, to 9.
+	Priority int
+	Durable  bool
+	// Loggable messages have their state changes written to the server's
+	// audit log.
+	Loggable bool
+	// Dedup is a deduplication key. Pushing the same key to the same queue
+	// within its deduplication window returns the id of the first message
+	// and schedules nothing, which also lets the push be retried.
+	Dedup string
+	// Group delivers the message only once the messages pushed to the same
+	// group of the queue and due before it are acked.
+	Group string
+	// NotBefore and NotAfter bound the delivery window of the message. It
+	// fires no earlier than NotBefore, and is moved to the DLQ with reason
+	// "window missed" if it cannot be delivered by NotAfter. Either may be
+	// left zero.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Traceparent and Tracestate are W3C trace context headers. The trace
+	// is carried through to the subscriber the message is delivered to.
+	Traceparent string
+	Tracestate  string
+}
+
+// Message is the server's view of a published message.
+type Message struct {
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Value string    `json:"value"`
+	State string    `json:"state"`
+	// Due is set while the message is pending.
+	Due      time.Time `json:"due,omitzero"`
+	Priority int       `json:"priority,omitempty"`
+	Group    string    `json:"group,omitempty"`
+	// Deadline is the end of the delivery window of the message, if any.
+	Deadline time.Time `json:"deadline,omitzero"`
+	// Reason is set when State is "dead-lettered": "cancelled", "overflow"
+	// or "window missed".
+	Reason string `json:"reason,omitempty"`
+}
+
+// QueueInfo is a queue listed by QUEUES. Cancelled counts every cancel since
+// the server started, including messages since replayed from the DLQ.
+type QueueInfo struct {
+	Name      string `json:"name"`
+	Pending   int    `json:"pending"`
+	Fired     int    `json:"fired"`
+	Cancelled int    `json:"cancelled"`
+}
+
+// Info describes the server.
+type Info struct {
+	Version   string        `json:"version"`
+	StartedAt time.Time     `json:"startedAt"`
+	Uptime    time.Duration `json:"uptime"`
+	Listeners []string      `json:"listeners"`
+	Queues    int           `json:"queues"`
+}
+
+// Health is the readiness reported by the server. Ready is false if any of
+// its checks failed.
+type Health struct {
+	Ready  bool          `json:"ready"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// QueueStats are the figures reported by STATS for a queue. Cancelled counts
+// every cancel, DeadLettered the messages still in the DLQ. PendingBytes is
+// the payload size of the pending messages. Lag is how late the queue's last
+// timer fired.
+type QueueStats struct {
+	Name         string        `json:"name"`
+	Pending      int           `json:"pending"`
+	Fired        int           `json:"fired"`
+	Cancelled    int           `json:"cancelled"`
+	DeadLettered int           `json:"deadLettered"`
+	StoreBytes   int           `json:"storeBytes"`
+	PendingBytes int           `json:"pendingBytes"`
+	Capacity     Capacity      `json:"capacity"`
+	Lag          time.Duration `json:"lag"`
+}
+
+// Capacity bounds how many Messages may be pending in a queue and how many
+// Bytes of payload they may add up to. Zero means no bound.
+type Capacity struct {
+	Messages int `json:"messages"`
+	Bytes    int `json:"bytes"`
+}
+
+// Timer is a message waiting to fire.
+type Timer struct {
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Due   time.Time `json:"due"`
+}
+
+// DeadLetter is a message held in a queue's DLQ. Reason is why it was moved
+// there: "cancelled", "overflow" or "window missed".
+type DeadLetter struct {
+	Id     uuid.UUID `json:"id"`
+	Queue  string    `json:"queue"`
+	Value  string    `json:"value"`
+	Reason string    `json:"reason"`
+}
+
+func New(opts Options) (*Client, error) {
+	if opts.Addr == "" {
+		return nil, ErrNoAddr
+	}
+	if opts.User != "" && (!validValue(opts.User) || !validValue(opts.Secret)) {
+		return nil, ErrInvalidValue
+	}
+
+	c := &Client{
+		opts:     opts.withDefaults(),
+		protocol: adapters.TCPProtocol(),
+	}
+	c.pool = newPool(c.opts.PoolSize, c.dial)
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.DialTimeout)
+	defer cancel()
+
+	nc, err := c.opts.Dial(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.TLS != nil {
+		tc := tls.Client(nc, c.opts.TLS)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if err := c.authenticate(ctx, cn); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// authenticate sends AUTH on a fresh connection if the client has
+// credentials.
+func (c *Client) authenticate(ctx context.Context, cn *conn) error {
+	if c.opts.User == "" {
+		return nil
+	}
+	replies, err := cn.roundTrip(ctx, &c.protocol, c.opts.Timeout, []string{"AUTH " + c.opts.User + " " + c.opts.Secret})
+	if err != nil {
+		return err
+	}
+	if replies[0].Status == adapters.StatusError {
+		return replyError(replies[0])
+	}
+	return nil
+}
+
+// Close closes idle connections and fails any later command with ErrClosed.
+// Open subscriptions must be closed separately.
+func (c *Client) Close() error {
+	c.pool.close()
+	return nil
+}
+
+// exec sends lines on one connection and hands each reply to its command.
+func (c *Client) exec(ctx context.Context, idempotent bool, lines []string, cmds []pending) error {
+	var err error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.opts.RetryBackoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		var cn *conn
+		cn, err = c.pool.get(ctx)
+		if err != nil {
+			var rejected *Error
+			if errors.Is(err, ErrClosed) || errors.As(err, &rejected) || ctx.Err() != nil {
+				return err
+			}
+			// Nothing was sent, so even a PUSH is safe to try again.
+			continue
+		}
+
+		var replies []adapters.Reply
+		replies, err = cn.roundTrip(ctx, &c.protocol, c.opts.Timeout, lines)
+		c.pool.put(cn, err == nil)
+		if err == nil {
+			for i, cmd := range cmds {
+				cmd.set(replies[i])
+			}
+			return nil
+		}
+		if !idempotent || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) run(ctx context.Context, idempotent bool, line string, cmd pending) {
+	if err := c.exec(ctx, idempotent, []string{line}, []pending{cmd}); err != nil {
+		cmd.fail(err)
+	}
+}
+
+func validValue(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// formatWindow formats a bound of a delivery window, empty if unset.
+func formatWindow(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func pushLine(value string, opts PushOpts) (string, error) {
+	if !validValue(value) {
+		return "", ErrInvalidValue
+	}
+	parts := []string{"PUSH", value}
+	if opts.Queue != "" {
+		parts = append(parts, "queue="+opts.Queue)
+	}
+	if opts.Delay > 0 {
+		parts = append(parts, fmt.Sprintf("delay=%d", opts.Delay.Milliseconds()))
+	}
+	if opts.Jitter > 0 {
+		parts = append(parts, fmt.Sprintf("jitter=%d", opts.Jitter.Milliseconds()))
+	}
+	if opts.Priority != 0 {
+		parts = append(parts, fmt.Sprintf("priority=%d", opts.Priority))
+	}
+	if opts.Durable {
+		parts = append(parts, "durable=true")
+	}
+	if opts.Loggable {
+		parts = append(parts, "loggable=true")
+	}
+	if opts.Dedup != "" {
+		if !validValue(opts.Dedup) {
+			return "", ErrInvalidValue
+		}
+		parts = append(parts, "dedup="+opts.Dedup)
+	}
+	if opts.Group != "" {
+		if !validValue(opts.Group) {
+			return "", ErrInvalidValue
+		}
+		parts = append(parts, "group="+opts.Group)
+	}
+	if !opts.NotBefore.IsZero() || !opts.NotAfter.IsZero() {
+		parts = append(parts, "window="+formatWindow(opts.NotBefore)+"/"+formatWindow(opts.NotAfter))
+	}
+	if opts.Traceparent != "" {
+		if !validValue(opts.Traceparent) {
+			return "", ErrInvalidValue
+		}
+		parts = append(parts, "traceparent="+opts.Traceparent)
+	}
+	if opts.Tracestate != "" {
+		// Optional whitespace around members is not allowed on the wire.
+		state := strings.ReplaceAll(opts.Tracestate, " ", "")
+		if !validValue(state) {
+			return "", ErrInvalidValue
+		}
+		parts = append(parts, "tracestate="+state)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// Push publishes value and returns the id assigned by the server. Pushes
+// with a Dedup key are retried like other idempotent commands.
+func (c *Client) Push(ctx context.Context, value string, opts PushOpts) (uuid.UUID, error) {
+	line, err := pushLine(value, opts)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	cmd := newPushCmd()
+	c.run(ctx, opts.Dedup != "", line, cmd)
+	return cmd.Result()
+}
+
+func (c *Client) Get(ctx context.Context, id uuid.UUID) (Message, error) {
+	cmd := newGetCmd()
+	c.run(ctx, true, "GET "+id.String(), cmd)
+	return cmd.Result()
+}
+
+// Cancel stops a pending message from firing. It fails with ErrNotPending if
+// the message already fired or was cancelled. It is not retried after a
+// connection failure, since a retry of a cancel whose reply was lost would
+// fail with ErrNotPending; Get tells whether it went through.
+func (c *Client) Cancel(ctx context.Context, id uuid.UUID) error {
+	cmd := newStatusCmd()
+	c.run(ctx, false, "CANCEL "+id.String(), cmd)
+	_, err := cmd.Result()
+	return err
+}
+
+// Reschedule makes a pending message fire delay from now. Like Cancel, it is
+// not retried after a connection failure.
+func (c *Client) Reschedule(ctx context.Context, id uuid.UUID, delay time.Duration) error {
+	cmd := newStatusCmd()
+	c.run(ctx, false, fmt.Sprintf("DELAY %s %d", id, delay.Milliseconds()), cmd)
+	_, err := cmd.Result()
+	return err
+}
+
+// Replay moves a message out of the DLQ and schedules it to fire immediately.
+func (c *Client) Replay(ctx context.Context, id uuid.UUID) error {
+	cmd := newStatusCmd()
+	c.run(ctx, false, "REPLAY "+id.String(), cmd)
+	_, err := cmd.Result()
+	return err
+}
+
+// Resize sets the capacity of queue. Pushes that would exceed it fail with
+// ErrQueueFull; messages already pending are kept.
+func (c *Client) Resize(ctx context.Context, queue string, capacity Capacity) error {
+	cmd := newStatusCmd()
+	c.run(ctx, true, fmt.Sprintf("RESIZE %s %d %d", queue, capacity.Messages, capacity.Bytes), cmd)
+	_, err := cmd.Result()
+	return err
+}
+
+// Ack tells the server that a delivered message was processed. It fails with
+// ErrNotDelivered if the message was not delivered or was already acked.
+func (c *Client) Ack(ctx context.Context, id uuid.UUID) error {
+	cmd := newStatusCmd()
+	c.run(ctx, false, "ACK "+id.String(), cmd)
+	_, err := cmd.Result()
+	return err
+}
+
+func (c *Client) Queues(ctx context.Context) ([]QueueInfo, error) {
+	cmd := newQueuesCmd()
+	c.run(ctx, true, "QUEUES", cmd)
+	return cmd.Result()
+}
+
+func (c *Client) Info(ctx context.Context) (Info, error) {
+	cmd := newInfoCmd()
+	c.run(ctx, true, "INFO", cmd)
+	return cmd.Result()
+}
+
+// Health reports whether the server is ready to take traffic. A server that
+// is not ready still answers without an error.
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	cmd := newHealthCmd()
+	c.run(ctx, true, "HEALTH", cmd)
+	return cmd.Result()
+}
+
+// Stats reports the figures of queue, or of every queue if queue is empty.
+func (c *Client) Stats(ctx context.Context, queue string) ([]QueueStats, error) {
+	cmd := newStatsCmd()
+	c.run(ctx, true, withQueue("STATS", queue), cmd)
+	return cmd.Result()
+}
+
+// Timers lists pending messages, soonest first within each queue. An empty
+// queue lists every queue.
+func (c *Client) Timers(ctx context.Context, queue string) ([]Timer, error) {
+	cmd := newTimersCmd()
+	c.run(ctx, true, withQueue("TIMERS", queue), cmd)
+	return cmd.Result()
+}
+
+// DeadLetters lists the DLQ of queue, or of every queue if queue is empty.
+func (c *Client) DeadLetters(ctx context.Context, queue string) ([]DeadLetter, error) {
+	cmd := newDeadLettersCmd()
+	c.run(ctx, true, withQueue("DLQ", queue), cmd)
+	return cmd.Result()
+}
+
+func withQueue(cmd, queue string) string {
+	if queue == "" {
+		return cmd
+	}
+	return cmd + " " + queue
+}
+
+// Do sends a raw protocol line and returns the reply payload, which is empty
+// for bare `OK` replies. Subscriptions must be opened with Subscribe.
+func (c *Client) Do(ctx context.Context, line string) (json.RawMessage, error) {
+	if strings.ContainsAny(line, "\r\n") {
+		return nil, ErrInvalidValue
+	}
+	if cmd, _, _ := strings.Cut(strings.TrimSpace(line), " "); strings.EqualFold(cmd, "SUBSCRIBE") {
+		return nil, ErrRawSubscribe
+	}
+	cmd := newRawCmd()
+	c.run(ctx, false, line, cmd)
+	return cmd.Result()
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	cmd := newStatusCmd()
+	c.run(ctx, true, "PING", cmd)
+	_, err := cmd.Result()
+	return err
+}
@@ -0,0 +1,917 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters/servers"
+	"github.com/BarunKGP/timermq/internal/audit"
+	"github.com/BarunKGP/timermq/internal/auth"
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/limits"
+	"github.com/BarunKGP/timermq/internal/tlsconfig"
+	"github.com/BarunKGP/timermq/internal/tlsconfig/tlstest"
+	"github.com/google/uuid"
+)
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, nil)
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return listener.Addr().String()
+}
+
+func newClient(t *testing.T) *Client {
+	t.Helper()
+	c, err := New(Options{Addr: startServer(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestPushGetCancel(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	id, err := c.Push(ctx, "hello", PushOpts{Delay: time.Hour})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	msg, err := c.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if msg.Value != "hello" || msg.State != "pending" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	if err := c.Reschedule(ctx, id, 2*time.Hour); err != nil {
+		t.Errorf("Reschedule failed: %v", err)
+	}
+	if err := c.Cancel(ctx, id); err != nil {
+		t.Errorf("Cancel failed: %v", err)
+	}
+	if msg, err := c.Get(ctx, id); err != nil || msg.State != "dead-lettered" || msg.Reason != "cancelled" {
+		t.Errorf("Expected a dead-lettered message cancelled, found %+v (%v)", msg, err)
+	}
+	if err := c.Cancel(ctx, id); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Expected ErrNotPending cancelling twice, received %v", err)
+	}
+	if _, err := c.Get(ctx, uuid.New()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown id, received %v", err)
+	}
+	if _, err := c.Push(ctx, "two words", PushOpts{}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue, received %v", err)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	p := c.Pipeline()
+	first := p.Push("first", PushOpts{Delay: time.Hour})
+	second := p.Push("second", PushOpts{Delay: time.Hour})
+	missing := p.Get(uuid.New())
+	if err := p.Exec(ctx); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+
+	id1, err1 := first.Result()
+	id2, err2 := second.Result()
+	if err1 != nil || err2 != nil || id1 == id2 {
+		t.Errorf("Unexpected push results: %v %v, %v %v", id1, err1, id2, err2)
+	}
+	if _, err := missing.Result(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, received %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	c := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := c.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	id, err := c.Push(ctx, "fired", PushOpts{})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	select {
+	case d := <-sub.Messages():
+		if d.Id != id || d.Value != "fired" {
+			t.Errorf("Unexpected delivery %+v", d)
+		}
+		if err := sub.Ack(ctx, d); err != nil {
+			t.Errorf("Ack failed: %v", err)
+		}
+		if err := sub.Ack(ctx, d); !errors.Is(err, ErrNotDelivered) {
+			t.Errorf("Expected ErrNotDelivered acking twice, received %v", err)
+		}
+		if msg, err := c.Get(ctx, id); err != nil || msg.State != "acked" {
+			t.Errorf("Expected the message to be acked, found %+v, %v", msg, err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for delivery")
+	}
+}
+
+func TestObserve(t *testing.T) {
+	c := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	obs, err := c.Observe(ctx, "")
+	if err != nil {
+		t.Fatalf("Observe failed: %v", err)
+	}
+	defer obs.Close()
+
+	id, err := c.Push(ctx, "observed", PushOpts{})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	for _, want := range []string{"published", "fired"} {
+		select {
+		case e := <-obs.Events():
+			if e.Type != want || e.Id != id || e.Value != "observed" {
+				t.Errorf("Expected a %s event of %s, received %+v", want, id, e)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for the %s event", want)
+		}
+	}
+
+	// The observer took nothing, so the message is left to a subscriber.
+	sub, err := c.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+	select {
+	case d := <-sub.Messages():
+		if d.Id != id {
+			t.Errorf("Expected %s to be delivered, received %+v", id, d)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for delivery")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	// Break the pooled connection; the next command must dial again.
+	cn := <-c.pool.idle
+	cn.Close()
+	c.pool.idle <- cn
+
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping after connection loss failed: %v", err)
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Accept the connection but never reply.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	c, err := New(Options{Addr: listener.Addr().String(), MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, received %v", err)
+	}
+	(<-accepted).Close()
+}
+
+func TestRetry(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Read each command, then drop the connection as if the reply was lost.
+	received := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if line, err := bufio.NewReader(conn).ReadString('\n'); err == nil {
+				received <- strings.Fields(line)[0]
+			}
+			conn.Close()
+		}
+	}()
+
+	c, err := New(Options{Addr: listener.Addr().String(), MaxRetries: 2, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		cmd      string
+		run      func() error
+		attempts int
+	}{
+		{"PING", func() error { return c.Ping(ctx) }, 3},
+		{"CANCEL", func() error { return c.Cancel(ctx, uuid.New()) }, 1},
+		{"DELAY", func() error { return c.Reschedule(ctx, uuid.New(), time.Second) }, 1},
+	} {
+		if err := tc.run(); err == nil {
+			t.Errorf("Expected %s to fail without a reply", tc.cmd)
+		}
+		for range tc.attempts {
+			if cmd := <-received; cmd != tc.cmd {
+				t.Errorf("Expected %s to be sent, received %s", tc.cmd, cmd)
+			}
+		}
+		select {
+		case cmd := <-received:
+			t.Errorf("Expected %s to be sent %d times, received another %s", tc.cmd, tc.attempts, cmd)
+		default:
+		}
+	}
+}
+
+func TestInspectAndReplay(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	id, err := c.Push(ctx, "report", PushOpts{Queue: "billing", Delay: time.Hour})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	timers, err := c.Timers(ctx, "billing")
+	if err != nil || len(timers) != 1 || timers[0].Id != id {
+		t.Fatalf("Unexpected timers %+v: %v", timers, err)
+	}
+
+	if err := c.Cancel(ctx, id); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	letters, err := c.DeadLetters(ctx, "")
+	if err != nil || len(letters) != 1 || letters[0].Value != "report" {
+		t.Fatalf("Unexpected dead letters %+v: %v", letters, err)
+	}
+
+	sub, err := c.Subscribe(ctx, "billing")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	if err := c.Replay(ctx, id); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if err := c.Replay(ctx, id); !errors.Is(err, ErrNotArchived) {
+		t.Errorf("Expected ErrNotArchived replaying twice, received %v", err)
+	}
+
+	select {
+	case d := <-sub.Messages():
+		if d.Id != id || d.Queue != "billing" {
+			t.Errorf("Unexpected delivery %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for replayed message")
+	}
+
+	queues, err := c.Queues(ctx)
+	if err != nil || len(queues) != 1 {
+		t.Fatalf("Unexpected queues %+v: %v", queues, err)
+	}
+	if q := queues[0]; q.Name != "billing" || q.Fired != 1 || q.Cancelled != 1 {
+		t.Errorf("Unexpected queue counts %+v", q)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, nil)
+	served := make(chan struct{})
+	go func() {
+		srv.Serve(listener)
+		close(served)
+	}()
+
+	// A long backoff keeps the goodbye as the last error seen.
+	c, err := New(Options{Addr: listener.Addr().String(), MaxRetries: -1, RetryBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := c.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	<-served
+	if err := c.Ping(ctx); err == nil {
+		t.Error("Expected commands to fail once the server is shut down")
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for !errors.Is(sub.Err(), ErrServerShutdown) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(sub.Err(), ErrServerShutdown) {
+		t.Errorf("Expected subscription to see ErrServerShutdown, found %v", sub.Err())
+	}
+}
+
+func TestInfoAndStats(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	id, err := c.Push(ctx, "payload", PushOpts{Queue: "billing", Delay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Replay(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.Version == "" || info.Queues != 1 || len(info.Listeners) != 1 || info.StartedAt.After(time.Now()) {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	stats, err := c.Stats(ctx, "billing")
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Expected stats for 1 queue, found %+v", stats)
+	}
+	if s := stats[0]; s.Cancelled != 1 || s.DeadLettered != 0 || s.StoreBytes != len("payload") {
+		t.Errorf("Unexpected stats %+v", s)
+	}
+	if _, err := c.Stats(ctx, "bad/name"); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("Expected ErrInvalidArgs for an invalid queue, received %v", err)
+	}
+
+	health, err := c.Health(ctx)
+	if err != nil {
+		t.Fatalf("Health failed: %v", err)
+	}
+	if !health.Ready || len(health.Checks) == 0 {
+		t.Errorf("Expected a ready server, found %+v", health)
+	}
+}
+
+type auditSink struct {
+	records chan audit.Record
+}
+
+func (s auditSink) Write(r audit.Record) error {
+	s.records <- r
+	return nil
+}
+
+func (auditSink) Close() error { return nil }
+
+func TestAudit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := auditSink{records: make(chan audit.Record, 16)}
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, nil, servers.WithAudit(audit.NewLogger(sink)))
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	c, err := New(Options{Addr: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if _, err := c.Push(ctx, "quiet", PushOpts{Delay: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.Push(ctx, "audited", PushOpts{Delay: time.Hour, Loggable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reschedule(ctx, id, 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Cancel(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []core.EventType{core.EventPublished, core.EventRescheduled, core.EventCancelled} {
+		select {
+		case r := <-sink.records:
+			if r.Event != want || r.Id != id || r.Value != "audited" || r.Addr == "" || r.Time.IsZero() {
+				t.Errorf("Expected a %s record for %s, found %+v", want, id, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the %s record", want)
+		}
+	}
+	select {
+	case r := <-sink.records:
+		t.Errorf("Unexpected record %+v", r)
+	default:
+	}
+}
+
+func TestAuth(t *testing.T) {
+	store, err := auth.NewStore(auth.File{Users: []auth.User{
+		// hunter2, hashed cheaply to keep the test fast.
+		{Name: "orders", Secret: "pbkdf2-sha256$1000$TbEYpCUh+3qC+8Y6PWj1qg$xCahiLx6vyAYCH+uMSeyNkiARDO/4uP79mJqW6/ikJs"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := auditSink{records: make(chan audit.Record, 16)}
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, nil,
+		servers.WithAuth(store), servers.WithAudit(audit.NewLogger(sink)))
+	go srv.Serve(listener)
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, opts := range []Options{
+		{Addr: listener.Addr().String()},
+		{Addr: listener.Addr().String(), User: "orders", Secret: "hunter3"},
+	} {
+		c, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Ping(ctx); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Expected ErrUnauthenticated as %q, received %v", opts.User, err)
+		}
+		if _, err := c.Subscribe(ctx, ""); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("Expected subscribing as %q to fail, received %v", opts.User, err)
+		}
+		c.Close()
+	}
+
+	c, err := New(Options{Addr: listener.Addr().String(), User: "orders", Secret: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Push(ctx, "authenticated", PushOpts{Loggable: true}); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	select {
+	case r := <-sink.records:
+		if r.Client != "orders" {
+			t.Errorf("Expected the record to name the authenticated user, found %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the published record")
+	}
+	sub, err := c.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	sub.Close()
+}
+
+func TestACL(t *testing.T) {
+	const secret = "pbkdf2-sha256$1000$TbEYpCUh+3qC+8Y6PWj1qg$xCahiLx6vyAYCH+uMSeyNkiARDO/4uP79mJqW6/ikJs"
+	store, err := auth.NewStore(auth.File{
+		Users: []auth.User{{Name: "orders", Secret: secret, Roles: []string{"producer"}}},
+		ACL: []auth.Rule{
+			{Role: "producer", Queues: "orders.*", Permissions: []auth.Permission{auth.PermPush, auth.PermInspect}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := auditSink{records: make(chan audit.Record, 16)}
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, nil,
+		servers.WithAuth(store), servers.WithAudit(audit.NewLogger(sink)))
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	c, err := New(Options{Addr: listener.Addr().String(), User: "orders", Secret: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	id, err := c.Push(ctx, "allowed", PushOpts{Queue: "orders.eu", Delay: time.Hour})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if _, err := c.Get(ctx, id); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+	if err := c.Cancel(ctx, id); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected cancelling to be forbidden, received %v", err)
+	}
+	if _, err := c.Push(ctx, "denied", PushOpts{Queue: "billing"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected pushing to billing to be forbidden, received %v", err)
+	}
+	if _, err := c.Subscribe(ctx, "orders.eu"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected subscribing to be forbidden, received %v", err)
+	}
+
+	for _, want := range []audit.Record{
+		{Id: id, Queue: "orders.eu", Command: "CANCEL", Permission: "cancel"},
+		{Queue: "billing", Command: "PUSH", Permission: "push"},
+		{Queue: "orders.eu", Command: "SUBSCRIBE", Permission: "consume"},
+	} {
+		select {
+		case r := <-sink.records:
+			if r.Event != audit.EventDenied || r.Id != want.Id || r.Queue != want.Queue || r.Command != want.Command ||
+				r.Permission != want.Permission || r.Client != "orders" {
+				t.Errorf("Expected a denial of %s, found %+v", want.Command, r)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the denial of %s", want.Command)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := limits.NewLimiter(limits.Limits{ClientPush: limits.Rate{PerSecond: 1, Burst: 3}})
+	broker := core.NewBroker(8).WithCapacity(core.Capacity{Messages: 2})
+	defer broker.Close()
+	srv := servers.NewTCPServer(servers.InitOpts{}, broker, servers.WithLimits(limiter))
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	c, err := New(Options{Addr: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	for _, delay := range []time.Duration{time.Hour, 30 * time.Minute} {
+		if _, err := c.Push(ctx, "fits", PushOpts{Delay: delay}); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+	if _, err := c.Push(ctx, "full", PushOpts{}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected the queue to be full, received %v", err)
+	}
+
+	var throttled *Error
+	_, err = c.Push(ctx, "fast", PushOpts{Queue: "other"})
+	if !errors.As(err, &throttled) || throttled.Code != CodeThrottled {
+		t.Fatalf("Expected the push rate to be exceeded, received %v", err)
+	}
+	if throttled.RetryAfter <= 0 || throttled.RetryAfter > time.Second {
+		t.Errorf("Expected to retry within a second, found %s", throttled.RetryAfter)
+	}
+}
+
+func TestPushDedup(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := core.NewBroker(8).WithDedupWindow(time.Minute)
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, broker)
+	go srv.Serve(listener)
+	c, err := New(Options{Addr: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	opts := PushOpts{Queue: "orders", Delay: time.Hour, Dedup: "order-42"}
+	first, err := c.Push(ctx, "charge", opts)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	p := c.Pipeline()
+	retried := p.Push("charge", opts)
+	if err := p.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := retried.Result(); err != nil || id != first {
+		t.Errorf("Expected the retried push to return %s, found %s, %v", first, id, err)
+	}
+	if timers, err := c.Timers(ctx, "orders"); err != nil || len(timers) != 1 {
+		t.Errorf("Expected a single scheduled message, found %v, %v", timers, err)
+	}
+	if _, err := c.Push(ctx, "charge", PushOpts{Dedup: "order 42"}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected a key with a space to be rejected, received %v", err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	id, err := c.Push(ctx, "urgent", PushOpts{Delay: time.Hour, Priority: 7})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if msg, err := c.Get(ctx, id); err != nil || msg.Priority != 7 {
+		t.Errorf("Expected priority 7, found %+v, %v", msg, err)
+	}
+	if _, err := c.Push(ctx, "urgent", PushOpts{Priority: 10}); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("Expected an out of range priority to be rejected, received %v", err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	sub, err := c.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+	for _, value := range []string{"created", "paid"} {
+		if _, err := c.Push(ctx, value, PushOpts{Group: "order-42"}); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+
+	var first Delivery
+	select {
+	case first = <-sub.Messages():
+		if first.Value != "created" || first.Group != "order-42" {
+			t.Fatalf("Expected the first message of the group, received %+v", first)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	select {
+	case d := <-sub.Messages():
+		t.Fatalf("Expected the group to wait for an ack, received %+v", d)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := sub.Ack(ctx, first); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	select {
+	case d := <-sub.Messages():
+		if d.Value != "paid" {
+			t.Errorf("Expected the next message of the group, received %+v", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the next message of the group")
+	}
+}
+
+func TestWindow(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	id, err := c.Push(ctx, "report", PushOpts{Queue: "reports", NotBefore: start, NotAfter: start.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if msg, err := c.Get(ctx, id); err != nil || msg.Due.Before(start) || msg.Due.After(start.Add(time.Second)) || !msg.Deadline.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the message due at the start of its window, found %+v, %v", msg, err)
+	}
+
+	stale, err := c.Push(ctx, "stale", PushOpts{Queue: "reports", NotAfter: time.Now().Add(-time.Second)})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	letters, err := c.DeadLetters(ctx, "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Id != stale || letters[0].Reason != "window missed" {
+		t.Errorf("Expected the stale message in the DLQ, found %+v", letters)
+	}
+
+	if _, err := c.Push(ctx, "open", PushOpts{NotBefore: start}); err != nil {
+		t.Errorf("Expected a window without an end to be accepted, received %v", err)
+	}
+	if _, err := c.Push(ctx, "backwards", PushOpts{NotBefore: start, NotAfter: start.Add(-time.Minute)}); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("Expected a window ending before it starts to be rejected, received %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	pushed := time.Now()
+	id, err := c.Push(ctx, "jittered", PushOpts{Delay: time.Hour, Jitter: time.Minute})
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	msg, err := c.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Due.Before(pushed.Add(time.Hour)) || msg.Due.After(time.Now().Add(time.Hour+time.Minute)) {
+		t.Errorf("Expected the message due within a minute after its delay, found %v", msg.Due.Sub(pushed))
+	}
+}
+
+func TestResize(t *testing.T) {
+	c := newClient(t)
+	ctx := context.Background()
+
+	if err := c.Resize(ctx, "orders", Capacity{Messages: 1}); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	if _, err := c.Push(ctx, "first", PushOpts{Queue: "orders", Delay: time.Hour}); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if _, err := c.Push(ctx, "second", PushOpts{Queue: "orders", Delay: time.Hour}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected the queue to be full, received %v", err)
+	}
+
+	if err := c.Resize(ctx, "orders", Capacity{Messages: 2, Bytes: 64}); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	if _, err := c.Push(ctx, "second", PushOpts{Queue: "orders", Delay: time.Hour}); err != nil {
+		t.Fatalf("Expected the resized queue to admit another message, received %v", err)
+	}
+	stats, err := c.Stats(ctx, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Capacity{Messages: 2, Bytes: 64}); len(stats) != 1 || stats[0].Capacity != want || stats[0].PendingBytes != 11 {
+		t.Errorf("Expected the capacity and pending bytes in the stats, found %+v", stats)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ca := tlstest.NewCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.Issue(t, "timermq").Write(t, dir, "server")
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, ca.PEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := tlsconfig.Load(tlsconfig.Options{Cert: certFile, Key: keyFile, ClientCA: caFile, ClientAuth: tlsconfig.ClientAuthRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.NewStore(auth.File{
+		Users: []auth.User{{Name: "orders"}},
+		ACL:   []auth.Rule{{User: "orders", Queues: "orders.*", Permissions: []auth.Permission{auth.PermPush}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := servers.NewTCPServer(servers.InitOpts{Capacity: 8}, nil, servers.WithTLS(certs.Config()), servers.WithAuth(store))
+	go srv.Serve(listener)
+	defer srv.Close()
+	ctx := context.Background()
+
+	anonymous, err := New(Options{Addr: listener.Addr().String(), TLS: &tls.Config{RootCAs: ca.Pool()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer anonymous.Close()
+	if err := anonymous.Ping(ctx); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("Expected a client without a certificate to be unauthenticated, received %v", err)
+	}
+
+	c, err := New(Options{Addr: listener.Addr().String(), TLS: &tls.Config{
+		RootCAs:      ca.Pool(),
+		Certificates: []tls.Certificate{ca.Issue(t, "orders").TLS(t)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Push(ctx, "mutual", PushOpts{Queue: "orders.eu"}); err != nil {
+		t.Errorf("Expected the certificate to identify the client, received %v", err)
+	}
+	if _, err := c.Push(ctx, "mutual", PushOpts{Queue: "billing"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected the ACL of the certificate's user to apply, received %v", err)
+	}
+
+	plain, err := New(Options{Addr: listener.Addr().String(), MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if err := plain.Ping(ctx); err == nil {
+		t.Error("Expected a plaintext client to be refused")
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	c := newClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := c.Subscribe(ctx, "traced")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	opts := PushOpts{Queue: "traced", Traceparent: traceparent, Tracestate: "rojo=1, congo=2"}
+	if _, err := c.Push(ctx, "traced", opts); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if _, err := c.Push(ctx, "bad", PushOpts{Traceparent: "00-invalid"}); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("Expected ErrInvalidArgs for an invalid traceparent, received %v", err)
+	}
+
+	select {
+	case d := <-sub.Messages():
+		if d.Traceparent != traceparent || d.Tracestate != "rojo=1,congo=2" {
+			t.Errorf("Expected the trace context to reach the subscriber, found %+v", d)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for delivery")
+	}
+}
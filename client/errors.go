@@ -0,0 +1,97 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters"
+)
+
+// Code mirrors the error code sent by the server in an `ERR` reply.
+type Code string
+
+const (
+	CodeParse           = Code(adapters.CodeParse)
+	CodeTooShort        = Code(adapters.CodeTooShort)
+	CodeInvalidCommand  = Code(adapters.CodeInvalidCommand)
+	CodeInvalidArgs     = Code(adapters.CodeInvalidArgs)
+	CodeNotFound        = Code(adapters.CodeNotFound)
+	CodeNotPending      = Code(adapters.CodeNotPending)
+	CodeNotArchived     = Code(adapters.CodeNotArchived)
+	CodeNotDelivered    = Code(adapters.CodeNotDelivered)
+	CodeUnauthenticated = Code(adapters.CodeUnauthenticated)
+	CodeForbidden       = Code(adapters.CodeForbidden)
+	CodeThrottled       = Code(adapters.CodeThrottled)
+	CodeQueueFull       = Code(adapters.CodeQueueFull)
+	CodeInternal        = Code(adapters.CodeInternal)
+)
+
+// Error is returned when the server rejects a command. Use errors.Is with
+// one of the Err* values below to check the code.
+type Error struct {
+	Code    Code
+	Message string
+	// RetryAfter is how long the server asked to wait before sending a
+	// throttled command again.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("timermq: %s", e.Code)
+	}
+	return fmt.Sprintf("timermq: %s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrParse          = &Error{Code: CodeParse}
+	ErrTooShort       = &Error{Code: CodeTooShort}
+	ErrInvalidCommand = &Error{Code: CodeInvalidCommand}
+	ErrInvalidArgs    = &Error{Code: CodeInvalidArgs}
+	ErrNotFound       = &Error{Code: CodeNotFound}
+	ErrNotPending     = &Error{Code: CodeNotPending}
+	ErrNotArchived    = &Error{Code: CodeNotArchived}
+	ErrNotDelivered   = &Error{Code: CodeNotDelivered}
+	// ErrUnauthenticated is returned when the server requires AUTH and the
+	// client has no valid credentials.
+	ErrUnauthenticated = &Error{Code: CodeUnauthenticated}
+	// ErrForbidden is returned when the server's access control denies the
+	// authenticated user the command.
+	ErrForbidden = &Error{Code: CodeForbidden}
+	// ErrThrottled is returned when a rate limit of the server
+	// refused a push, which had no effect and may be sent again after
+	// RetryAfter.
+	ErrThrottled = &Error{Code: CodeThrottled}
+	// ErrQueueFull is returned when a push would take a queue over its
+	// capacity, which Resize changes.
+	ErrQueueFull = &Error{Code: CodeQueueFull}
+	ErrInternal  = &Error{Code: CodeInternal}
+)
+
+var (
+	ErrClosed       = errors.New("Client is closed")
+	ErrInvalidValue = errors.New("Value must be non-empty and must not contain whitespace")
+	ErrNoAddr       = errors.New("Server address is required")
+	ErrRawSubscribe = errors.New("Use Subscribe to open a subscription")
+	// ErrServerShutdown is recorded by a subscription when the server says
+	// goodbye before closing the connection.
+	ErrServerShutdown = errors.New("Server shut down")
+)
+
+// retryAfterHint marks the hint ending the message of a throttled reply.
+const retryAfterHint = "retry after "
+
+func replyError(r adapters.Reply) error {
+	e := &Error{Code: Code(r.Code), Message: r.Message}
+	if i := strings.LastIndex(r.Message, retryAfterHint); e.Code == CodeThrottled && i >= 0 {
+		e.RetryAfter, _ = time.ParseDuration(r.Message[i+len(retryAfterHint):])
+	}
+	return e
+}
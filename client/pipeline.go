@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters"
+	"github.com/google/uuid"
+)
+
+type pending interface {
+	set(r adapters.Reply)
+	fail(err error)
+}
+
+// Cmd holds the result of a command queued on a Pipeline. Result is only
+// meaningful after Exec has returned.
+type Cmd[T any] struct {
+	val    T
+	err    error
+	decode func(r adapters.Reply) (T, error)
+}
+
+func (c *Cmd[T]) Result() (T, error) {
+	return c.val, c.err
+}
+
+func (c *Cmd[T]) set(r adapters.Reply) {
+	if r.Status == adapters.StatusError {
+		c.err = replyError(r)
+		return
+	}
+	c.val, c.err = c.decode(r)
+}
+
+func (c *Cmd[T]) fail(err error) {
+	c.err = err
+}
+
+func newPushCmd() *Cmd[uuid.UUID] {
+	return &Cmd[uuid.UUID]{decode: func(r adapters.Reply) (uuid.UUID, error) {
+		var res adapters.PushReply
+		err := r.Decode(&res)
+		return res.Id, err
+	}}
+}
+
+func newGetCmd() *Cmd[Message] {
+	return &Cmd[Message]{decode: func(r adapters.Reply) (Message, error) {
+		var res adapters.GetReply
+		if err := r.Decode(&res); err != nil {
+			return Message{}, err
+		}
+		return Message{Id: res.Id, Queue: res.Queue, Value: res.Value, State: res.State, Due: res.Due, Priority: res.Priority, Group: res.Group, Deadline: res.Deadline, Reason: res.Reason}, nil
+	}}
+}
+
+func newRawCmd() *Cmd[json.RawMessage] {
+	return &Cmd[json.RawMessage]{decode: func(r adapters.Reply) (json.RawMessage, error) {
+		return r.Payload, nil
+	}}
+}
+
+func newQueuesCmd() *Cmd[[]QueueInfo] {
+	return &Cmd[[]QueueInfo]{decode: func(r adapters.Reply) ([]QueueInfo, error) {
+		var res []adapters.QueueInfo
+		if err := r.Decode(&res); err != nil {
+			return nil, err
+		}
+		queues := make([]QueueInfo, 0, len(res))
+		for _, q := range res {
+			queues = append(queues, QueueInfo{Name: q.Name, Pending: q.Pending, Fired: q.Fired, Cancelled: q.Cancelled})
+		}
+		return queues, nil
+	}}
+}
+
+func newInfoCmd() *Cmd[Info] {
+	return &Cmd[Info]{decode: func(r adapters.Reply) (Info, error) {
+		var res adapters.InfoReply
+		if err := r.Decode(&res); err != nil {
+			return Info{}, err
+		}
+		uptime, err := time.ParseDuration(res.Uptime)
+		if err != nil {
+			return Info{}, fmt.Errorf("%w: uptime %q", adapters.ErrMalformedReply, res.Uptime)
+		}
+		return Info{Version: res.Version, StartedAt: res.StartedAt, Uptime: uptime, Listeners: res.Listeners, Queues: res.Queues}, nil
+	}}
+}
+
+func newHealthCmd() *Cmd[Health] {
+	return &Cmd[Health]{decode: func(r adapters.Reply) (Health, error) {
+		var res adapters.HealthReply
+		if err := r.Decode(&res); err != nil {
+			return Health{}, err
+		}
+		h := Health{Ready: res.Ready, Checks: make([]HealthCheck, 0, len(res.Checks))}
+		for _, c := range res.Checks {
+			h.Checks = append(h.Checks, HealthCheck{Name: c.Name, OK: c.OK, Detail: c.Detail})
+		}
+		return h, nil
+	}}
+}
+
+func newStatsCmd() *Cmd[[]QueueStats] {
+	return &Cmd[[]QueueStats]{decode: func(r adapters.Reply) ([]QueueStats, error) {
+		var res []adapters.QueueStats
+		if err := r.Decode(&res); err != nil {
+			return nil, err
+		}
+		stats := make([]QueueStats, 0, len(res))
+		for _, q := range res {
+			stats = append(stats, QueueStats{
+				Name:         q.Name,
+				Pending:      q.Pending,
+				Fired:        q.Fired,
+				Cancelled:    q.Cancelled,
+				DeadLettered: q.DeadLettered,
+				StoreBytes:   q.StoreBytes,
+				PendingBytes: q.PendingBytes,
+				Capacity:     Capacity(q.Capacity),
+				Lag:          time.Duration(q.LagMs * float64(time.Millisecond)),
+			})
+		}
+		return stats, nil
+	}}
+}
+
+func newTimersCmd() *Cmd[[]Timer] {
+	return &Cmd[[]Timer]{decode: func(r adapters.Reply) ([]Timer, error) {
+		var res []adapters.TimerInfo
+		if err := r.Decode(&res); err != nil {
+			return nil, err
+		}
+		timers := make([]Timer, 0, len(res))
+		for _, t := range res {
+			timers = append(timers, Timer{Id: t.Id, Queue: t.Queue, Due: t.Due})
+		}
+		return timers, nil
+	}}
+}
+
+func newDeadLettersCmd() *Cmd[[]DeadLetter] {
+	return &Cmd[[]DeadLetter]{decode: func(r adapters.Reply) ([]DeadLetter, error) {
+		var res []adapters.DeadLetter
+		if err := r.Decode(&res); err != nil {
+			return nil, err
+		}
+		letters := make([]DeadLetter, 0, len(res))
+		for _, d := range res {
+			letters = append(letters, DeadLetter{Id: d.Id, Queue: d.Queue, Value: d.Value, Reason: d.Reason})
+		}
+		return letters, nil
+	}}
+}
+
+func newStatusCmd() *Cmd[struct{}] {
+	return &Cmd[struct{}]{decode: func(r adapters.Reply) (struct{}, error) {
+		return struct{}{}, nil
+	}}
+}
+
+// Pipeline batches commands so that they are written to the server together
+// and their replies read back in order, saving a round trip per command.
+type Pipeline struct {
+	c          *Client
+	lines      []string
+	cmds       []pending
+	idempotent bool
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c, idempotent: true}
+}
+
+func (p *Pipeline) queue(line string, idempotent bool, cmd pending) {
+	p.lines = append(p.lines, line)
+	p.cmds = append(p.cmds, cmd)
+	p.idempotent = p.idempotent && idempotent
+}
+
+func (p *Pipeline) Push(value string, opts PushOpts) *Cmd[uuid.UUID] {
+	cmd := newPushCmd()
+	line, err := pushLine(value, opts)
+	if err != nil {
+		cmd.fail(err)
+		return cmd
+	}
+	p.queue(line, opts.Dedup != "", cmd)
+	return cmd
+}
+
+func (p *Pipeline) Get(id uuid.UUID) *Cmd[Message] {
+	cmd := newGetCmd()
+	p.queue("GET "+id.String(), true, cmd)
+	return cmd
+}
+
+func (p *Pipeline) Cancel(id uuid.UUID) *Cmd[struct{}] {
+	cmd := newStatusCmd()
+	p.queue("CANCEL "+id.String(), false, cmd)
+	return cmd
+}
+
+func (p *Pipeline) Reschedule(id uuid.UUID, delay time.Duration) *Cmd[struct{}] {
+	cmd := newStatusCmd()
+	p.queue(fmt.Sprintf("DELAY %s %d", id, delay.Milliseconds()), false, cmd)
+	return cmd
+}
+
+// Exec sends every queued command. The returned error only reports transport
+// failures; server-side errors are available on each command's Result.
+func (p *Pipeline) Exec(ctx context.Context) error {
+	if len(p.lines) == 0 {
+		return nil
+	}
+	err := p.c.exec(ctx, p.idempotent, p.lines, p.cmds)
+	if err != nil {
+		for _, cmd := range p.cmds {
+			cmd.fail(err)
+		}
+	}
+	p.lines, p.cmds, p.idempotent = nil, nil, true
+	return err
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters"
+)
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// roundTrip writes every line in one batch and then reads one reply per line.
+// Any error returned leaves the connection in an unknown state.
+func (c *conn) roundTrip(ctx context.Context, proto *adapters.Protocol, timeout time.Duration, lines []string) ([]adapters.Reply, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte(proto.Delim)
+	}
+	if _, err := c.Write([]byte(b.String())); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+
+	replies := make([]adapters.Reply, 0, len(lines))
+	for range lines {
+		line, err := c.r.ReadString(proto.Delim)
+		if err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+		reply, err := proto.ParseReply(line)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// ctxErr prefers the context's error over the i/o timeout it caused.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The connection deadline can expire a moment before the context notices.
+	var ne net.Error
+	if deadline, ok := ctx.Deadline(); ok && errors.As(err, &ne) && ne.Timeout() && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// pool hands out at most size connections at a time and keeps returned,
+// healthy connections around for reuse.
+type pool struct {
+	dial  func(ctx context.Context) (*conn, error)
+	idle  chan *conn
+	slots chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newPool(size int, dial func(ctx context.Context) (*conn, error)) *pool {
+	return &pool{
+		dial:  dial,
+		idle:  make(chan *conn, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+func (p *pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	if p.isClosed() {
+		return nil, ErrClosed
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns c to the pool. Broken connections are closed so that the next
+// get dials a fresh one.
+func (p *pool) put(c *conn, healthy bool) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !healthy || p.closed {
+		c.Close()
+		return
+	}
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+
+	for {
+		select {
+		case c := <-p.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters"
+	"github.com/google/uuid"
+)
+
+// Delivery is a message that fired and was handed to this subscriber.
+type Delivery struct {
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Value string    `json:"value"`
+	// Priority is the priority the message was pushed with.
+	Priority int `json:"priority,omitempty"`
+	// Group is the group the message was pushed to.
+	Group string `json:"group,omitempty"`
+	// Traceparent and Tracestate continue the trace the message was
+	// pushed with, if any.
+	Traceparent string `json:"traceparent,omitempty"`
+	Tracestate  string `json:"tracestate,omitempty"`
+}
+
+// Event is a lifecycle event of a message, received by an Observer.
+type Event struct {
+	// Type is the transition, such as "published", "fired" or "acked".
+	Type  string    `json:"type"`
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Value string    `json:"value"`
+	// Due is when the message is, or was, due to fire.
+	Due time.Time `json:"due"`
+	// At is when the transition happened.
+	At time.Time `json:"at"`
+}
+
+// Subscription receives fired messages on a dedicated connection. If the
+// connection drops it is re-established until Close is called.
+type Subscription struct {
+	*stream
+	ch chan Delivery
+}
+
+// Subscribe opens a subscription to queue, or to the default queue if queue
+// is empty. ctx only bounds the initial handshake.
+func (c *Client) Subscribe(ctx context.Context, queue string) (*Subscription, error) {
+	st, err := c.openStream(ctx, withQueue("SUBSCRIBE", queue), adapters.StatusMsg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscription{stream: st, ch: make(chan Delivery)}
+	st.forward = func(r adapters.Reply) bool {
+		var frame adapters.DeliveryFrame
+		if err := r.Decode(&frame); err != nil {
+			return true
+		}
+		select {
+		case s.ch <- Delivery{Id: frame.Id, Queue: frame.Queue, Value: frame.Value, Priority: frame.Priority, Group: frame.Group, Traceparent: frame.Traceparent, Tracestate: frame.Tracestate}:
+			return true
+		case <-st.done:
+			return false
+		}
+	}
+	go st.run(func() { close(s.ch) })
+	return s, nil
+}
+
+// Messages returns the channel deliveries are sent on. It is closed once the
+// subscription is closed.
+func (s *Subscription) Messages() <-chan Delivery {
+	return s.ch
+}
+
+// Ack acknowledges a delivery received on the subscription. Acks are sent
+// over the client's command connections. Deliveries not acked by the time the
+// subscription closes are handed to another subscriber.
+func (s *Subscription) Ack(ctx context.Context, d Delivery) error {
+	return s.c.Ack(ctx, d.Id)
+}
+
+// Observer receives the lifecycle events of the messages of a queue on a
+// dedicated connection, without taking any message from it. If the
+// connection drops it is re-established until Close is called; events in
+// between are missed.
+type Observer struct {
+	*stream
+	ch chan Event
+}
+
+// Observe opens an Observer of queue, or of the default queue if queue is
+// empty. ctx only bounds the initial handshake.
+func (c *Client) Observe(ctx context.Context, queue string) (*Observer, error) {
+	st, err := c.openStream(ctx, withQueue("OBSERVE", queue), adapters.StatusEvent)
+	if err != nil {
+		return nil, err
+	}
+	o := &Observer{stream: st, ch: make(chan Event)}
+	st.forward = func(r adapters.Reply) bool {
+		var frame adapters.EventFrame
+		if err := r.Decode(&frame); err != nil {
+			return true
+		}
+		select {
+		case o.ch <- Event(frame):
+			return true
+		case <-st.done:
+			return false
+		}
+	}
+	go st.run(func() { close(o.ch) })
+	return o, nil
+}
+
+// Events returns the channel events are sent on. It is closed once the
+// observer is closed.
+func (o *Observer) Events() <-chan Event {
+	return o.ch
+}
+
+// stream reads the frames sent to a dedicated connection once line, such as
+// SUBSCRIBE, is accepted. If the connection drops it is re-established until
+// Close is called.
+type stream struct {
+	c    *Client
+	line string
+	// status is the status of the frames handed to forward, which reports
+	// false once the stream is closed.
+	status  string
+	forward func(adapters.Reply) bool
+
+	mu     sync.Mutex
+	conn   *conn
+	err    error
+	closed bool
+	done   chan struct{}
+}
+
+func (c *Client) openStream(ctx context.Context, line, status string) (*stream, error) {
+	if c.pool.isClosed() {
+		return nil, ErrClosed
+	}
+	s := &stream{c: c, line: line, status: status, done: make(chan struct{})}
+	cn, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = cn
+	return s, nil
+}
+
+func (s *stream) dial(ctx context.Context) (*conn, error) {
+	cn, err := s.c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := cn.roundTrip(ctx, &s.c.protocol, s.c.opts.Timeout, []string{s.line})
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	if replies[0].Status == adapters.StatusError {
+		cn.Close()
+		return nil, replyError(replies[0])
+	}
+	return cn, nil
+}
+
+// Err returns the last connection error seen by the stream, if any.
+func (s *stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	return s.conn.Close()
+}
+
+// run reads frames until the stream is closed, then calls finish.
+func (s *stream) run(finish func()) {
+	defer finish()
+
+	for {
+		err := s.read(s.currentConn())
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		s.err = err
+		s.mu.Unlock()
+
+		if !s.reconnect() {
+			return
+		}
+	}
+}
+
+func (s *stream) currentConn() *conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+// read forwards frames from cn until the connection fails or the server
+// shuts down.
+func (s *stream) read(cn *conn) error {
+	for {
+		line, err := cn.r.ReadString(s.c.protocol.Delim)
+		if err != nil {
+			return err
+		}
+		reply, err := s.c.protocol.ParseReply(line)
+		if err == nil && reply.Status == adapters.StatusBye {
+			cn.Close()
+			return fmt.Errorf("%w: %s", ErrServerShutdown, reply.Message)
+		}
+		if err != nil || reply.Status != s.status {
+			continue
+		}
+		if !s.forward(reply) {
+			return nil
+		}
+	}
+}
+
+// reconnect dials until the stream is established again or closed,
+// reporting whether it succeeded.
+func (s *stream) reconnect() bool {
+	for {
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(s.c.opts.RetryBackoff):
+		}
+
+		cn, err := s.dial(context.Background())
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			if cn != nil {
+				cn.Close()
+			}
+			return false
+		}
+		if err != nil {
+			s.err = err
+			s.mu.Unlock()
+			continue
+		}
+		s.conn = cn
+		s.mu.Unlock()
+		return true
+	}
+}
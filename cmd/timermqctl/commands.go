@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BarunKGP/timermq/client"
+	"github.com/BarunKGP/timermq/internal/adapters"
+	"github.com/google/uuid"
+)
+
+// usageError reports that a command was invoked with the wrong arguments.
+type usageError struct{}
+
+func (usageError) Error() string { return "invalid arguments" }
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseArgs parses flags in args and checks that exactly n positional
+// arguments remain.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := fs.Parse(args); err != nil || fs.NArg() != n {
+		return nil, usageError{}
+	}
+	return fs.Args(), nil
+}
+
+func parseId(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return id, fmt.Errorf("invalid message id %q", s)
+	}
+	return id, nil
+}
+
+func runPush(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("push")
+	queue := fs.String("queue", "", "queue to publish to")
+	delay := fs.Duration("delay", 0, "delay before the message fires")
+	jitter := fs.Duration("jitter", 0, "bound of a random delay added to the message")
+	priority := fs.Int("priority", 0, "priority among messages due at the same time, 0 to 9")
+	durable := fs.Bool("durable", false, "store the message durably")
+	loggable := fs.Bool("loggable", false, "record the message's state changes in the audit log")
+	dedup := fs.String("dedup", "", "deduplication key: repeating it returns the first message's id")
+	window := fs.String("window", "", "delivery window <start>/<end> in RFC 3339, either may be left out")
+	group := fs.String("group", "", "group delivered one message at a time, in due order")
+	traceparent := fs.String("traceparent", "", "W3C traceparent of the trace the message belongs to")
+	tracestate := fs.String("tracestate", "", "W3C tracestate sent along with -traceparent")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	var notBefore, notAfter time.Time
+	if *window != "" {
+		if notBefore, notAfter, err = adapters.ParseWindow(*window); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	id, err := e.client.Push(ctx, pos[0], client.PushOpts{
+		Queue:       *queue,
+		Delay:       *delay,
+		Jitter:      *jitter,
+		Priority:    *priority,
+		Durable:     *durable,
+		Loggable:    *loggable,
+		Dedup:       *dedup,
+		Group:       *group,
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		Traceparent: *traceparent,
+		Tracestate:  *tracestate,
+	})
+	if err != nil {
+		return err
+	}
+	return e.out.print(map[string]uuid.UUID{"id": id}, []string{"ID"}, [][]string{{id.String()}})
+}
+
+func runGet(ctx context.Context, e *env, args []string) error {
+	pos, err := parseArgs(newFlagSet("get"), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := parseId(pos[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	msg, err := e.client.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return e.out.print(msg,
+		[]string{"ID", "QUEUE", "STATE", "REASON", "DUE", "DEADLINE", "PRIORITY", "GROUP", "VALUE"},
+		[][]string{{msg.Id.String(), msg.Queue, msg.State, msg.Reason, formatTime(msg.Due), formatTime(msg.Deadline), strconv.Itoa(msg.Priority), msg.Group, msg.Value}},
+	)
+}
+
+// runIdCommand runs a command that takes a single message id and only
+// reports success.
+func runIdCommand(ctx context.Context, e *env, name string, args []string, do func(context.Context, uuid.UUID) error) error {
+	pos, err := parseArgs(newFlagSet(name), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := parseId(pos[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	if err := do(ctx, id); err != nil {
+		return err
+	}
+	return e.out.print(map[string]string{"id": id.String(), "result": "ok"}, []string{"ID", "RESULT"}, [][]string{{id.String(), "ok"}})
+}
+
+func runCancel(ctx context.Context, e *env, args []string) error {
+	return runIdCommand(ctx, e, "cancel", args, e.client.Cancel)
+}
+
+func runReplay(ctx context.Context, e *env, args []string) error {
+	return runIdCommand(ctx, e, "replay", args, e.client.Replay)
+}
+
+func runAck(ctx context.Context, e *env, args []string) error {
+	return runIdCommand(ctx, e, "ack", args, e.client.Ack)
+}
+
+func runReschedule(ctx context.Context, e *env, args []string) error {
+	if len(args) != 2 {
+		return usageError{}
+	}
+	delay, err := time.ParseDuration(args[1])
+	if err != nil || delay < 0 {
+		return fmt.Errorf("invalid delay %q", args[1])
+	}
+	return runIdCommand(ctx, e, "reschedule", args[:1], func(ctx context.Context, id uuid.UUID) error {
+		return e.client.Reschedule(ctx, id, delay)
+	})
+}
+
+func runQueues(ctx context.Context, e *env, args []string) error {
+	if _, err := parseArgs(newFlagSet("queues"), args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	queues, err := e.client.Queues(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(queues))
+	for _, q := range queues {
+		rows = append(rows, []string{q.Name, strconv.Itoa(q.Pending), strconv.Itoa(q.Fired), strconv.Itoa(q.Cancelled)})
+	}
+	return e.out.print(queues, []string{"QUEUE", "PENDING", "FIRED", "CANCELLED"}, rows)
+}
+
+func runTimers(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("timers")
+	queue := fs.String("queue", "", "only list timers of this queue")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	timers, err := e.client.Timers(ctx, *queue)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(timers))
+	for _, t := range timers {
+		rows = append(rows, []string{t.Id.String(), t.Queue, formatTime(t.Due), formatUntil(t.Due)})
+	}
+	return e.out.print(timers, []string{"ID", "QUEUE", "DUE", "IN"}, rows)
+}
+
+func runDLQ(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("dlq")
+	queue := fs.String("queue", "", "only list the DLQ of this queue")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	letters, err := e.client.DeadLetters(ctx, *queue)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(letters))
+	for _, d := range letters {
+		rows = append(rows, []string{d.Id.String(), d.Queue, d.Reason, d.Value})
+	}
+	return e.out.print(letters, []string{"ID", "QUEUE", "REASON", "VALUE"}, rows)
+}
+
+func runStats(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("stats")
+	queue := fs.String("queue", "", "only show this queue")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	stats, err := e.client.Stats(ctx, *queue)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, q := range stats {
+		rows = append(rows, []string{
+			q.Name, strconv.Itoa(q.Pending), strconv.Itoa(q.Fired), strconv.Itoa(q.Cancelled),
+			strconv.Itoa(q.DeadLettered), strconv.Itoa(q.StoreBytes), q.Lag.String(),
+		})
+	}
+	return e.out.print(stats, []string{"QUEUE", "PENDING", "FIRED", "CANCELLED", "DLQ", "STORE BYTES", "LAG"}, rows)
+}
+
+func runResize(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("resize")
+	messages := fs.Int("messages", 0, "pending messages the queue admits, 0 for no bound")
+	bytes := fs.Int("bytes", 0, "payload bytes the queue admits, 0 for no bound")
+	pos, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if *messages < 0 || *bytes < 0 {
+		return fmt.Errorf("capacity must not be negative")
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	capacity := client.Capacity{Messages: *messages, Bytes: *bytes}
+	if err := e.client.Resize(ctx, pos[0], capacity); err != nil {
+		return err
+	}
+	return e.out.print(map[string]any{"queue": pos[0], "capacity": capacity}, []string{"QUEUE", "MESSAGES", "BYTES"},
+		[][]string{{pos[0], strconv.Itoa(capacity.Messages), strconv.Itoa(capacity.Bytes)}})
+}
+
+func runInfo(ctx context.Context, e *env, args []string) error {
+	if _, err := parseArgs(newFlagSet("info"), args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	info, err := e.client.Info(ctx)
+	if err != nil {
+		return err
+	}
+	return e.out.print(info,
+		[]string{"VERSION", "STARTED", "UPTIME", "LISTENERS", "QUEUES"},
+		[][]string{{info.Version, formatTime(info.StartedAt), info.Uptime.String(), strings.Join(info.Listeners, ","), strconv.Itoa(info.Queues)}},
+	)
+}
+
+// runHealth prints the server's readiness checks and fails if it is not
+// ready, so that it can be used in scripts.
+func runHealth(ctx context.Context, e *env, args []string) error {
+	if _, err := parseArgs(newFlagSet("health"), args, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.ctx(ctx)
+	defer cancel()
+	h, err := e.client.Health(ctx)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, 0, len(h.Checks))
+	for _, c := range h.Checks {
+		status := "ok"
+		if !c.OK {
+			status = "failing"
+		}
+		rows = append(rows, []string{c.Name, status, c.Detail})
+	}
+	if err := e.out.print(h, []string{"CHECK", "STATUS", "DETAIL"}, rows); err != nil {
+		return err
+	}
+	if !h.Ready {
+		return errors.New("server is not ready")
+	}
+	return nil
+}
+
+// runTail prints messages of a queue as they fire until interrupted. It
+// observes the queue without taking its messages, unless -consume is set, in
+// which case it subscribes and acks every message it prints, so that they are
+// not delivered to other consumers.
+func runTail(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("tail")
+	queue := fs.String("queue", "", "queue to tail (default queue if empty)")
+	all := fs.Bool("all", false, "print every lifecycle event, not only fired messages")
+	consume := fs.Bool("consume", false, "subscribe and ack the messages, taking them from other consumers")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if *consume {
+		return consumeTail(ctx, e, *queue)
+	}
+
+	obsCtx, cancel := e.ctx(ctx)
+	obs, err := e.client.Observe(obsCtx, *queue)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer obs.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-obs.Events():
+			if !ok {
+				return obs.Err()
+			}
+			if !*all && ev.Type != "fired" {
+				continue
+			}
+			err := e.out.stream(
+				map[string]any{"time": ev.At, "event": ev.Type, "id": ev.Id, "queue": ev.Queue, "value": ev.Value},
+				[]string{formatTime(ev.At), ev.Type, ev.Id.String(), ev.Queue, ev.Value},
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// consumeTail subscribes to queue and prints and acks every delivery until
+// interrupted.
+func consumeTail(ctx context.Context, e *env, queue string) error {
+	subCtx, cancel := e.ctx(ctx)
+	sub, err := e.client.Subscribe(subCtx, queue)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-sub.Messages():
+			if !ok {
+				return sub.Err()
+			}
+			now := time.Now()
+			err := e.out.stream(
+				map[string]any{"time": now, "event": "delivered", "id": d.Id, "queue": d.Queue, "value": d.Value},
+				[]string{formatTime(now), "delivered", d.Id.String(), d.Queue, d.Value},
+			)
+			if err != nil {
+				return err
+			}
+			ackCtx, cancel := e.ctx(ctx)
+			err = sub.Ack(ackCtx, d)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// completer returns the candidates for the last word of line.
+type completer func(line string) []string
+
+// lineEditor reads lines from a terminal with cursor movement, history and
+// tab completion. When stdin is not a terminal it reads plain lines instead.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	terminal bool
+	complete completer
+
+	history []string
+}
+
+func newLineEditor(in *os.File, out io.Writer, complete completer) *lineEditor {
+	return &lineEditor{
+		in:       bufio.NewReader(in),
+		out:      out,
+		fd:       int(in.Fd()),
+		terminal: isTerminal(int(in.Fd())),
+		complete: complete,
+	}
+}
+
+func (l *lineEditor) addHistory(line string) {
+	if line == "" || (len(l.history) > 0 && l.history[len(l.history)-1] == line) {
+		return
+	}
+	l.history = append(l.history, line)
+}
+
+// readLine prompts for and returns the next line. It returns io.EOF on
+// ctrl-d at an empty prompt and errInterrupted on ctrl-c.
+func (l *lineEditor) readLine(prompt string) (string, error) {
+	if !l.terminal {
+		line, err := l.in.ReadString('\n')
+		if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	restore, err := makeRaw(l.fd)
+	if err != nil {
+		l.terminal = false
+		return l.readLine(prompt)
+	}
+	defer restore()
+
+	e := &editState{prompt: prompt, histIdx: len(l.history)}
+	l.redraw(e)
+	for {
+		r, _, err := l.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(l.out, "\r\n")
+			return string(e.buf), nil
+		case 3: // ctrl-c
+			fmt.Fprint(l.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // ctrl-d
+			if len(e.buf) == 0 {
+				fmt.Fprint(l.out, "\r\n")
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case 127, 8: // backspace
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case 1: // ctrl-a
+			e.pos = 0
+		case 5: // ctrl-e
+			e.pos = len(e.buf)
+		case 21: // ctrl-u
+			e.buf, e.pos = e.buf[e.pos:], 0
+		case 23: // ctrl-w
+			start := wordStart(e.buf, e.pos)
+			e.buf = append(e.buf[:start:start], e.buf[e.pos:]...)
+			e.pos = start
+		case '\t':
+			l.tab(e)
+		case 27:
+			l.escape(e)
+		default:
+			if unicode.IsPrint(r) {
+				e.insert(r)
+			}
+		}
+		l.redraw(e)
+	}
+}
+
+type editState struct {
+	prompt  string
+	buf     []rune
+	pos     int
+	histIdx int
+	// draft keeps the line being typed while browsing history.
+	draft []rune
+}
+
+func (e *editState) insert(rs ...rune) {
+	tail := append([]rune{}, e.buf[e.pos:]...)
+	e.buf = append(append(e.buf[:e.pos], rs...), tail...)
+	e.pos += len(rs)
+}
+
+func (e *editState) deleteAt(i int) {
+	if i < len(e.buf) {
+		e.buf = append(e.buf[:i], e.buf[i+1:]...)
+	}
+}
+
+func wordStart(buf []rune, pos int) int {
+	i := pos
+	for i > 0 && buf[i-1] == ' ' {
+		i--
+	}
+	for i > 0 && buf[i-1] != ' ' {
+		i--
+	}
+	return i
+}
+
+// escape handles the arrow, home, end and delete key sequences.
+func (l *lineEditor) escape(e *editState) {
+	if b, _ := l.in.ReadByte(); b != '[' && b != 'O' {
+		return
+	}
+	b, _ := l.in.ReadByte()
+	switch b {
+	case 'A':
+		l.browse(e, -1)
+	case 'B':
+		l.browse(e, 1)
+	case 'C':
+		if e.pos < len(e.buf) {
+			e.pos++
+		}
+	case 'D':
+		if e.pos > 0 {
+			e.pos--
+		}
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.buf)
+	case '3':
+		if t, _ := l.in.ReadByte(); t == '~' {
+			e.deleteAt(e.pos)
+		}
+	}
+}
+
+// browse moves through history by step entries.
+func (l *lineEditor) browse(e *editState, step int) {
+	next := e.histIdx + step
+	if next < 0 || next > len(l.history) {
+		return
+	}
+	if e.histIdx == len(l.history) {
+		e.draft = e.buf
+	}
+	e.histIdx = next
+	if next == len(l.history) {
+		e.buf = e.draft
+	} else {
+		e.buf = []rune(l.history[next])
+	}
+	e.pos = len(e.buf)
+}
+
+// tab completes the word before the cursor. A single candidate is inserted;
+// several are narrowed to their common prefix, or listed if there is none.
+func (l *lineEditor) tab(e *editState) {
+	if l.complete == nil {
+		return
+	}
+	line := string(e.buf[:e.pos])
+	word := line[strings.LastIndex(line, " ")+1:]
+	candidates := l.complete(line)
+
+	switch len(candidates) {
+	case 0:
+		return
+	case 1:
+		rest := candidates[0][len(word):]
+		if !strings.HasSuffix(candidates[0], "=") {
+			rest += " "
+		}
+		e.insert([]rune(rest)...)
+	default:
+		prefix := commonPrefix(candidates)
+		if len(prefix) > len(word) {
+			e.insert([]rune(prefix[len(word):])...)
+			return
+		}
+		fmt.Fprint(l.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+func (l *lineEditor) redraw(e *editState) {
+	fmt.Fprintf(l.out, "\r\x1b[K%s%s", e.prompt, string(e.buf))
+	if back := len(e.buf) - e.pos; back > 0 {
+		fmt.Fprintf(l.out, "\x1b[%dD", back)
+	}
+}
@@ -0,0 +1,170 @@
+// timermqctl operates a running TimerMQ broker over its TCP protocol.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/BarunKGP/timermq/client"
+)
+
+type env struct {
+	client *client.Client
+	// opts are the options client was made with.
+	opts    client.Options
+	out     *printer
+	timeout time.Duration
+}
+
+// ctx bounds a single request/reply command.
+func (e *env) ctx(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, e.timeout)
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, e *env, args []string) error
+	// interactive commands handle ctrl-c themselves.
+	interactive bool
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"push", "[-queue q] [-delay d] [-jitter d] [-priority n] [-durable] [-loggable] [-dedup key] [-group g] [-window start/end] [-traceparent tp] <value>", "publish a message", runPush, false},
+		{"get", "<id>", "show a message", runGet, false},
+		{"cancel", "<id>", "cancel a pending message", runCancel, false},
+		{"reschedule", "<id> <delay>", "make a pending message fire after delay", runReschedule, false},
+		{"queues", "", "list queues with message counts", runQueues, false},
+		{"timers", "[-queue q]", "list pending timers, soonest first", runTimers, false},
+		{"dlq", "[-queue q]", "list dead-lettered messages and why they were moved to the DLQ", runDLQ, false},
+		{"replay", "<id>", "move a message out of the DLQ and fire it now", runReplay, false},
+		{"ack", "<id>", "acknowledge a delivered message", runAck, false},
+		{"stats", "[-queue q]", "show per-queue counts, store size and scheduler lag", runStats, false},
+		{"resize", "[-messages n] [-bytes n] <queue>", "set how many messages and bytes a queue admits", runResize, false},
+		{"info", "", "show server version, uptime and listeners", runInfo, false},
+		{"health", "", "show readiness checks; fails if the server is not ready", runHealth, false},
+		{"tail", "[-queue q] [-all] [-consume]", "print messages as they fire, or every event with -all", runTail, false},
+		{"shell", "", "start an interactive shell", runShell, true},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: timermqctl [flags] <command> [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-11s %-40s %s\n", c.name, c.args, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func defaultAddr() string {
+	if addr, ok := os.LookupEnv("TIMERMQ_ADDR"); ok {
+		return addr
+	}
+	return "localhost:8080"
+}
+
+// tlsConfig builds the client TLS config from the -tls flags, or returns nil
+// if TLS is off.
+func tlsConfig(enabled bool, caFile, certFile, keyFile string) (*tls.Config, error) {
+	if !enabled && caFile == "" && certFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func main() {
+	addr := flag.String("addr", defaultAddr(), "broker address (env TIMERMQ_ADDR)")
+	format := flag.String("o", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each command")
+	useTLS := flag.Bool("tls", false, "connect with TLS, verifying the server against the system roots unless -tls-ca is set")
+	tlsCA := flag.String("tls-ca", "", "PEM bundle the server certificate is verified against; implies -tls")
+	tlsCert := flag.String("tls-cert", "", "PEM client certificate identifying the user; implies -tls")
+	tlsKey := flag.String("tls-key", "", "PEM key of the client certificate")
+	user := flag.String("user", os.Getenv("TIMERMQ_USER"), "user to authenticate as, with the secret taken from TIMERMQ_SECRET (env TIMERMQ_USER)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "timermqctl: unknown output format %q\n", *format)
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "timermqctl: unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	tlsCfg, err := tlsConfig(*useTLS, *tlsCA, *tlsCert, *tlsKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "timermqctl:", err)
+		os.Exit(1)
+	}
+	opts := client.Options{Addr: *addr, Timeout: *timeout, TLS: tlsCfg, User: *user, Secret: os.Getenv("TIMERMQ_SECRET")}
+	c, err := client.New(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "timermqctl:", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	if !cmd.interactive {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+	}
+
+	e := &env{client: c, opts: opts, out: newPrinter(os.Stdout, *format), timeout: *timeout}
+	// The shell may replace the client when it authenticates again.
+	defer func() { e.client.Close() }()
+	if err := cmd.run(ctx, e, flag.Args()[1:]); err != nil && !errors.Is(err, context.Canceled) {
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(os.Stderr, "Usage: timermqctl %s %s\n", cmd.name, cmd.args)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "timermqctl:", err)
+		os.Exit(1)
+	}
+}
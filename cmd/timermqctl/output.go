@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer renders results either as aligned tables or as JSON documents.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	return &printer{w: w, json: format == "json"}
+}
+
+// print writes v as JSON, or header and rows as a table.
+func (p *printer) print(v any, header []string, rows [][]string) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// stream writes a single record of a long running command: one JSON object
+// per line, or one tab separated row.
+func (p *printer) stream(v any, row []string) error {
+	if p.json {
+		return json.NewEncoder(p.w).Encode(v)
+	}
+	_, err := fmt.Fprintln(p.w, strings.Join(row, "\t"))
+	return err
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// formatUntil renders how long until t, rounded for humans.
+func formatUntil(t time.Time) string {
+	d := time.Until(t)
+	if d < 0 {
+		return "due"
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// field is a JSON object member, kept in the order the server sent it.
+type field struct {
+	key string
+	val any
+}
+
+// decodeOrdered decodes JSON like json.Unmarshal into an any, except that
+// objects become []field so that their key order is preserved.
+func decodeOrdered(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := []field{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, field{key: key.(string), val: val})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			val, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		_, err := dec.Token()
+		return arr, err
+	default:
+		return tok, nil
+	}
+}
+
+func scalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	case []field:
+		parts := make([]string, len(v))
+		for i, f := range v {
+			parts[i] = f.key + "=" + scalar(f.val)
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = scalar(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// renderPayload prints a reply payload for humans: objects as key/value
+// lines, lists of objects as tables and anything else as is.
+func renderPayload(w io.Writer, payload json.RawMessage) error {
+	if len(payload) == 0 {
+		_, err := fmt.Fprintln(w, "OK")
+		return err
+	}
+	v, err := decodeOrdered(payload)
+	if err != nil {
+		_, err = fmt.Fprintln(w, string(payload))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	switch v := v.(type) {
+	case []field:
+		for _, f := range v {
+			fmt.Fprintf(tw, "%s:\t%s\n", f.key, scalar(f.val))
+		}
+	case []any:
+		if len(v) == 0 {
+			fmt.Fprintln(tw, "(empty)")
+			break
+		}
+		first, ok := v[0].([]field)
+		if !ok {
+			for i, item := range v {
+				fmt.Fprintf(tw, "%d)\t%s\n", i+1, scalar(item))
+			}
+			break
+		}
+		header := make([]string, len(first))
+		for i, f := range first {
+			header[i] = strings.ToUpper(f.key)
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, item := range v {
+			obj, _ := item.([]field)
+			row := make([]string, len(obj))
+			for i, f := range obj {
+				row[i] = scalar(f.val)
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+	default:
+		fmt.Fprintln(tw, scalar(v))
+	}
+	return tw.Flush()
+}
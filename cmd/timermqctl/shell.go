@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BarunKGP/timermq/client"
+)
+
+const (
+	historyFile  = ".timermqctl_history"
+	historyLimit = 500
+	recentIds    = 20
+)
+
+type shellCommand struct {
+	name  string
+	usage string
+	// arg describes what the words after the command complete to.
+	arg string
+}
+
+// Protocol commands are sent to the server as typed; built-ins are handled
+// by the shell.
+var shellCommands = []shellCommand{
+	{"PUSH", "PUSH <value> [queue=<name>] [delay=<ms>] [jitter=<ms>] [priority=<0-9>] [durable=<bool>] [loggable=<bool>] [dedup=<key>] [group=<key>] [window=<start>/<end>] [traceparent=<tp>] [tracestate=<ts>]", "push"},
+	{"GET", "GET <id>", "id"},
+	{"CANCEL", "CANCEL <id>", "id"},
+	{"DELAY", "DELAY <id> <delayMs>", "id"},
+	{"REPLAY", "REPLAY <id>", "id"},
+	{"ACK", "ACK <id>", "id"},
+	{"QUEUES", "QUEUES", ""},
+	{"TIMERS", "TIMERS [queue]", "queue"},
+	{"DLQ", "DLQ [queue]", "queue"},
+	{"STATS", "STATS [queue]", "queue"},
+	{"INFO", "INFO", ""},
+	{"HEALTH", "HEALTH", ""},
+	{"PING", "PING", ""},
+	{"RESIZE", "RESIZE <queue> <messages> <bytes>", "queue"},
+	{"AUTH", "AUTH <user> <secret>: authenticate every connection of the shell as user", ""},
+	{"OBSERVE", "OBSERVE [queue]: print lifecycle events of messages until ctrl-c, without taking them", "queue"},
+	{"SUBSCRIBE", "SUBSCRIBE [queue]: consume and ack messages as they fire until ctrl-c", "queue"},
+	{"WATCH", "WATCH [queue]: live view of pending timers counting down until ctrl-c", "queue"},
+	{"HELP", "HELP", ""},
+	{"EXIT", "EXIT", ""},
+}
+
+var pushArgs = []string{"queue=", "delay=", "jitter=", "priority=", "durable=", "loggable=", "dedup=", "group=", "window=", "traceparent=", "tracestate="}
+
+var uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+type shell struct {
+	env    *env
+	editor *lineEditor
+	out    io.Writer
+	ids    []string
+}
+
+func runShell(ctx context.Context, e *env, args []string) error {
+	if _, err := parseArgs(newFlagSet("shell"), args, 0); err != nil {
+		return err
+	}
+
+	sh := &shell{env: e, out: os.Stdout}
+	sh.editor = newLineEditor(os.Stdin, os.Stdout, sh.complete)
+	sh.loadHistory()
+
+	if sh.editor.terminal {
+		fmt.Fprintln(sh.out, "Connected to TimerMQ. Type HELP for commands, TAB to complete, ctrl-d to exit.")
+	}
+	for {
+		line, err := sh.editor.readLine("timermq> ")
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// AUTH lines carry a secret, which must not end up on disk.
+		if !strings.EqualFold(strings.Fields(line)[0], "AUTH") {
+			sh.editor.addHistory(line)
+			sh.appendHistory(line)
+		}
+
+		if done := sh.exec(ctx, line); done {
+			return nil
+		}
+	}
+}
+
+// exec runs one line, reporting whether the shell should exit.
+func (sh *shell) exec(parent context.Context, line string) bool {
+	words := strings.Fields(line)
+	cmd := strings.ToUpper(words[0])
+	words[0] = cmd
+
+	// ctrl-c interrupts the running command rather than the shell.
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt)
+	defer stop()
+
+	var err error
+	switch cmd {
+	case "EXIT", "QUIT":
+		return true
+	case "HELP":
+		sh.help()
+	case "WATCH":
+		err = sh.watch(ctx, queueArg(words))
+	case "OBSERVE":
+		err = sh.observe(ctx, queueArg(words))
+	case "SUBSCRIBE":
+		err = sh.subscribe(ctx, queueArg(words))
+	case "AUTH":
+		err = sh.auth(ctx, words)
+	default:
+		err = sh.send(ctx, strings.Join(words, " "))
+	}
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		sh.printError(err)
+	}
+	return false
+}
+
+func queueArg(words []string) string {
+	if len(words) > 1 {
+		return words[1]
+	}
+	return ""
+}
+
+func (sh *shell) help() {
+	tw := tabwriter.NewWriter(sh.out, 0, 4, 2, ' ', 0)
+	for _, c := range shellCommands {
+		fmt.Fprintf(tw, "  %s\n", c.usage)
+	}
+	tw.Flush()
+}
+
+func (sh *shell) printError(err error) {
+	var serverErr *client.Error
+	if errors.As(err, &serverErr) {
+		fmt.Fprintf(sh.out, "(error) %s %s\n", serverErr.Code, serverErr.Message)
+		return
+	}
+	fmt.Fprintf(sh.out, "(error) %v\n", err)
+}
+
+func (sh *shell) send(parent context.Context, line string) error {
+	ctx, cancel := sh.env.ctx(parent)
+	defer cancel()
+
+	payload, err := sh.env.client.Do(ctx, line)
+	if err != nil {
+		return err
+	}
+	sh.rememberIds(string(payload))
+	if sh.env.out.json {
+		_, err := fmt.Fprintln(sh.out, string(payload))
+		return err
+	}
+	return renderPayload(sh.out, payload)
+}
+
+// observe prints the lifecycle events of queue, leaving its messages to
+// the consumers.
+func (sh *shell) observe(ctx context.Context, queue string) error {
+	obsCtx, cancel := sh.env.ctx(ctx)
+	obs, err := sh.env.client.Observe(obsCtx, queue)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer obs.Close()
+
+	fmt.Fprintln(sh.out, "Observing, waiting for events (ctrl-c to stop)")
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-obs.Events():
+			if !ok {
+				return obs.Err()
+			}
+			sh.rememberIds(ev.Id.String())
+			fmt.Fprintf(sh.out, "%s  %-13s  %s  %s  %s\n", ev.At.Local().Format(time.TimeOnly), ev.Type, ev.Id, ev.Queue, ev.Value)
+		}
+	}
+}
+
+// auth switches the shell to a client authenticating as another user. The
+// client keeps a pool of connections, each of which must send AUTH, so the
+// line cannot be sent as is.
+func (sh *shell) auth(ctx context.Context, words []string) error {
+	if len(words) != 3 {
+		return errors.New("Usage: AUTH <user> <secret>")
+	}
+	opts := sh.env.opts
+	opts.User, opts.Secret = words[1], words[2]
+	c, err := client.New(opts)
+	if err != nil {
+		return err
+	}
+	pingCtx, cancel := sh.env.ctx(ctx)
+	defer cancel()
+	if err := c.Ping(pingCtx); err != nil {
+		c.Close()
+		return err
+	}
+
+	sh.env.client.Close()
+	sh.env.client, sh.env.opts = c, opts
+	_, err = fmt.Fprintln(sh.out, "OK")
+	return err
+}
+
+// subscribe consumes the messages of queue, acking each one it prints.
+func (sh *shell) subscribe(ctx context.Context, queue string) error {
+	subCtx, cancel := sh.env.ctx(ctx)
+	sub, err := sh.env.client.Subscribe(subCtx, queue)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	fmt.Fprintln(sh.out, "Subscribed, consuming messages (ctrl-c to stop)")
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-sub.Messages():
+			if !ok {
+				return sub.Err()
+			}
+			sh.rememberIds(d.Id.String())
+			fmt.Fprintf(sh.out, "%s  %s  %s  %s\n", time.Now().Format(time.TimeOnly), d.Id, d.Queue, d.Value)
+			ackCtx, cancel := sh.env.ctx(ctx)
+			err := sub.Ack(ackCtx, d)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watch redraws the pending timers of queue, refreshing them from the server
+// every second and updating the countdowns in between.
+func (sh *shell) watch(ctx context.Context, queue string) error {
+	var timers []client.Timer
+	refresh := func() error {
+		rctx, cancel := sh.env.ctx(ctx)
+		defer cancel()
+		var err error
+		timers, err = sh.env.client.Timers(rctx, queue)
+		return err
+	}
+	if err := refresh(); err != nil {
+		return err
+	}
+
+	fetch := time.NewTicker(time.Second)
+	defer fetch.Stop()
+	draw := time.NewTicker(100 * time.Millisecond)
+	defer draw.Stop()
+
+	for {
+		sh.drawTimers(queue, timers)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-fetch.C:
+			if err := refresh(); err != nil {
+				return err
+			}
+		case <-draw.C:
+		}
+	}
+}
+
+func (sh *shell) drawTimers(queue string, timers []client.Timer) {
+	if queue == "" {
+		queue = "all queues"
+	}
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "Pending timers in %s at %s (ctrl-c to stop)\n\n", queue, time.Now().Format(time.TimeOnly))
+
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tQUEUE\tDUE\tFIRES IN")
+	for _, t := range timers {
+		in := "firing"
+		if d := time.Until(t.Due); d > 0 {
+			in = d.Truncate(100 * time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Id, t.Queue, t.Due.Local().Format(time.TimeOnly), in)
+	}
+	tw.Flush()
+	if len(timers) == 0 {
+		b.WriteString("(no pending timers)\n")
+	}
+	fmt.Fprint(sh.out, b.String())
+}
+
+func (sh *shell) rememberIds(text string) {
+	for _, id := range uuidPattern.FindAllString(text, -1) {
+		sh.ids = slices.DeleteFunc(sh.ids, func(s string) bool { return s == id })
+		sh.ids = append(sh.ids, id)
+	}
+	if len(sh.ids) > recentIds {
+		sh.ids = sh.ids[len(sh.ids)-recentIds:]
+	}
+}
+
+// complete returns the candidates for the word being typed at the end of line.
+func (sh *shell) complete(line string) []string {
+	words := strings.Fields(line)
+	if len(words) == 0 || !strings.HasSuffix(line, " ") {
+		words = words[:max(len(words)-1, 0)]
+	}
+	word := line[strings.LastIndex(line, " ")+1:]
+
+	if len(words) == 0 {
+		var res []string
+		for _, c := range shellCommands {
+			if strings.HasPrefix(c.name, strings.ToUpper(word)) {
+				res = append(res, c.name)
+			}
+		}
+		return res
+	}
+
+	var options []string
+	for _, c := range shellCommands {
+		if c.name != strings.ToUpper(words[0]) {
+			continue
+		}
+		switch {
+		case c.arg == "id" && len(words) == 1:
+			options = slices.Clone(sh.ids)
+			slices.Reverse(options)
+		case c.arg == "queue" && len(words) == 1:
+			options = sh.queueNames()
+		case c.arg == "push" && len(words) >= 2:
+			options = pushOptions(word)
+		}
+	}
+
+	var res []string
+	for _, o := range options {
+		if strings.HasPrefix(o, word) {
+			res = append(res, o)
+		}
+	}
+	return res
+}
+
+func pushOptions(word string) []string {
+	switch {
+	case strings.HasPrefix(word, "durable="):
+		return []string{"durable=true", "durable=false"}
+	case strings.HasPrefix(word, "loggable="):
+		return []string{"loggable=true", "loggable=false"}
+	case strings.HasPrefix(word, "queue="), strings.HasPrefix(word, "traceparent="), strings.HasPrefix(word, "tracestate="):
+		return nil
+	default:
+		return pushArgs
+	}
+}
+
+func (sh *shell) queueNames() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	queues, err := sh.env.client.Queues(ctx)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(queues))
+	for i, q := range queues {
+		names[i] = q.Name
+	}
+	return names
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFile)
+}
+
+func (sh *shell) loadHistory() {
+	f, err := os.Open(historyPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sh.editor.addHistory(scanner.Text())
+	}
+	if n := len(sh.editor.history); n > historyLimit {
+		sh.editor.history = sh.editor.history[n-historyLimit:]
+	}
+}
+
+func (sh *shell) appendHistory(line string) {
+	path := historyPath()
+	if path == "" || !sh.editor.terminal {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
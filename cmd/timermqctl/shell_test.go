@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/BarunKGP/timermq/internal/values"
+)
+
+func TestShellCommands(t *testing.T) {
+	builtins := map[string]bool{"WATCH": true, "HELP": true, "EXIT": true}
+
+	listed := make(map[string]bool, len(shellCommands))
+	for _, c := range shellCommands {
+		listed[c.name] = true
+		if _, err := values.CmdFromString(c.name); err != nil && !builtins[c.name] {
+			t.Errorf("Expected %s to be a server command or a built-in", c.name)
+		}
+	}
+	for _, cmd := range values.Commands() {
+		if !listed[string(cmd)] {
+			t.Errorf("Expected the shell to list the %s command", cmd)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package adapters
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/BarunKGP/timermq/internal/core"
 	"github.com/BarunKGP/timermq/internal/entities"
+	"github.com/BarunKGP/timermq/internal/tracing"
 	"github.com/BarunKGP/timermq/internal/values"
+	"github.com/google/uuid"
 )
 
 var (
@@ -25,6 +29,31 @@ func TCPProtocol() Protocol {
 	return Protocol{Delim: byte('\n')}
 }
 
+// ParseWindow parses a delivery window, "<start>/<end>" with RFC 3339 times
+// either of which may be left out. The server and timermqctl both use it, so
+// that they accept the same windows.
+func ParseWindow(value string) (start, end time.Time, err error) {
+	invalid := fmt.Errorf("%w: invalid window %q, expected <start>/<end>", ErrInvalidCommandArgs, value)
+	from, to, ok := strings.Cut(value, "/")
+	if !ok || from == "" && to == "" {
+		return time.Time{}, time.Time{}, invalid
+	}
+	if from != "" {
+		if start, err = time.Parse(time.RFC3339Nano, from); err != nil {
+			return time.Time{}, time.Time{}, invalid
+		}
+	}
+	if to != "" {
+		if end, err = time.Parse(time.RFC3339Nano, to); err != nil {
+			return time.Time{}, time.Time{}, invalid
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: window %q ends before it starts", ErrInvalidCommandArgs, value)
+	}
+	return start, end, nil
+}
+
 func handlePush(tokens []string) (*entities.Message,
 	error) {
 	msg, err := entities.NewMessageFromTokens(tokens).WithPush()
@@ -33,35 +62,86 @@ func handlePush(tokens []string) (*entities.Message,
 	}
 	msg.SetValue(tokens[1])
 
-	if len(tokens) == 2 {
-		return msg, nil
-	}
-
-	args := entities.OptionalArgs{}
+	args := entities.OptionalArgs{Queue: core.DefaultQueue}
 	for _, tok := range tokens[2:] {
-		parts := strings.Split(tok, string("="))
-		if len(parts) != 2 {
+		// Only the first "=" separates the name, as tracestate values
+		// contain more.
+		name, value, ok := strings.Cut(tok, "=")
+		if !ok {
 			return &entities.Message{}, ErrInvalidCommandArgs
 		}
 
-		switch strings.TrimSpace(parts[0]) {
+		switch strings.TrimSpace(name) {
+		case "queue":
+			if !core.ValidQueueName(value) {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Queue = value
 		case "delay":
-			delayMs, err := strconv.Atoi(parts[1])
+			delayMs, err := strconv.Atoi(value)
 			if err != nil {
 				return &entities.Message{}, ErrInvalidCommandArgs
 			}
 			args.Delay = time.Duration(delayMs) * time.Millisecond
+		case "jitter":
+			jitterMs, err := strconv.Atoi(value)
+			if err != nil || jitterMs < 0 {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Jitter = time.Duration(jitterMs) * time.Millisecond
+		case "priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil || priority < 0 || priority > core.MaxPriority {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Priority = priority
+		case "window":
+			start, end, err := ParseWindow(value)
+			if err != nil {
+				return &entities.Message{}, err
+			}
+			args.NotBefore, args.NotAfter = start, end
 		case "durable":
-			durable, err := strconv.ParseBool(parts[1])
+			durable, err := strconv.ParseBool(value)
 			if err != nil {
 				return &entities.Message{}, ErrInvalidCommandArgs
 			}
 			args.Durable = durable
+		case "loggable":
+			loggable, err := strconv.ParseBool(value)
+			if err != nil {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Loggable = loggable
+		case "dedup":
+			if !core.ValidDedupKey(value) {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Dedup = value
+		case "group":
+			if !core.ValidGroup(value) {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Group = value
+		case "traceparent":
+			if _, err := tracing.ParseTraceparent(value); err != nil {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Traceparent = value
+		case "tracestate":
+			if err := tracing.ValidTracestate(value); err != nil {
+				return &entities.Message{}, ErrInvalidCommandArgs
+			}
+			args.Tracestate = value
 		default:
 			return &entities.Message{}, ErrInvalidCommandArgs
 		}
 	}
 
+	if args.Tracestate != "" && args.Traceparent == "" {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+
 	msg.SetArgs(args)
 	return msg, nil
 }
@@ -78,18 +158,205 @@ func handlePing(tokens []string) (*entities.Message, error) {
 	return msg, nil
 }
 
+func handleGet(tokens []string) (*entities.Message, error) {
+	if len(tokens) != 2 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	if _, err := uuid.Parse(tokens[1]); err != nil {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := entities.NewMessageFromTokens(tokens).WithGet()
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetValue(tokens[1])
+
+	return msg, nil
+}
+
+func handleCancel(tokens []string) (*entities.Message, error) {
+	if len(tokens) != 2 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	if _, err := uuid.Parse(tokens[1]); err != nil {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := entities.NewMessageFromTokens(tokens).WithCancel()
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetValue(tokens[1])
+
+	return msg, nil
+}
+
+// handleDelay parses `DELAY <id> <delayMs>`, which reschedules a pending
+// message to fire delayMs from now.
+func handleDelay(tokens []string) (*entities.Message, error) {
+	if len(tokens) != 3 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	if _, err := uuid.Parse(tokens[1]); err != nil {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	delayMs, err := strconv.Atoi(tokens[2])
+	if err != nil || delayMs < 0 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := entities.NewMessageFromTokens(tokens).WithDelay()
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetValue(tokens[1])
+	msg.SetArgs(entities.OptionalArgs{Delay: time.Duration(delayMs) * time.Millisecond})
+
+	return msg, nil
+}
+
+// handleAuth parses `AUTH <user> <secret>`.
+func handleAuth(tokens []string) (*entities.Message, error) {
+	if len(tokens) != 3 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := entities.NewMessageFromTokens(tokens).WithAuth()
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetValue(tokens[1])
+	msg.SetArgs(entities.OptionalArgs{Secret: tokens[2]})
+
+	return msg, nil
+}
+
+// handleResize parses `RESIZE <queue> <messages> <bytes>`, where a zero
+// leaves that part of the capacity unbounded.
+func handleResize(tokens []string) (*entities.Message, error) {
+	if len(tokens) != 4 || !core.ValidQueueName(tokens[1]) {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	messages, err := strconv.Atoi(tokens[2])
+	if err != nil || messages < 0 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	bytes, err := strconv.Atoi(tokens[3])
+	if err != nil || bytes < 0 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := entities.NewMessageFromTokens(tokens).WithResize()
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetArgs(entities.OptionalArgs{Queue: tokens[1], MaxMessages: messages, MaxBytes: bytes})
+
+	return msg, nil
+}
+
+// queueFilter reads the optional queue name following a command. An empty
+// name means every queue, unless fallback is set.
+func queueFilter(tokens []string, fallback string) (string, error) {
+	switch len(tokens) {
+	case 1:
+		return fallback, nil
+	case 2:
+		if !core.ValidQueueName(tokens[1]) {
+			return "", ErrInvalidCommandArgs
+		}
+		return tokens[1], nil
+	default:
+		return "", ErrInvalidCommandArgs
+	}
+}
+
+// handleQueueCommand parses commands of the form `<CMD> [queue]`.
+func handleQueueCommand(tokens []string, fallback string,
+	with func(*entities.Message) (*entities.Message, error)) (*entities.Message, error) {
+	queue, err := queueFilter(tokens, fallback)
+	if err != nil {
+		return &entities.Message{}, err
+	}
+	msg, err := with(entities.NewMessageFromTokens(tokens))
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetArgs(entities.OptionalArgs{Queue: queue})
+
+	return msg, nil
+}
+
+// handleBareCommand parses commands that take no arguments.
+func handleBareCommand(tokens []string,
+	with func(*entities.Message) (*entities.Message, error)) (*entities.Message, error) {
+	if len(tokens) > 1 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := with(entities.NewMessageFromTokens(tokens))
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+
+	return msg, nil
+}
+
+// handleIdCommand parses commands of the form `<CMD> <id>`.
+func handleIdCommand(tokens []string,
+	with func(*entities.Message) (*entities.Message, error)) (*entities.Message, error) {
+	if len(tokens) != 2 {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	if _, err := uuid.Parse(tokens[1]); err != nil {
+		return &entities.Message{}, ErrInvalidCommandArgs
+	}
+	msg, err := with(entities.NewMessageFromTokens(tokens))
+	if err != nil {
+		return &entities.Message{}, ErrInvalidCommand
+	}
+	msg.SetValue(tokens[1])
+
+	return msg, nil
+}
+
 func (p *Protocol) Handle(msg string) (*entities.Message, error) {
-	words := strings.Split(msg, string(' '))
+	words := strings.Fields(strings.TrimRight(msg, string(p.Delim)))
 	cmd, err := values.ParseValidateCommand(words)
 	if err != nil {
 		return &entities.Message{}, err
 	}
 
 	switch cmd {
-	case "PING":
+	case values.Ping:
 		return handlePing(words)
-	case "PUSH":
+	case values.Push:
 		return handlePush(words)
+	case values.Get:
+		return handleGet(words)
+	case values.Cancel:
+		return handleCancel(words)
+	case values.Delay:
+		return handleDelay(words)
+	case values.Subscribe:
+		return handleQueueCommand(words, core.DefaultQueue, (*entities.Message).WithSubscribe)
+	case values.Observe:
+		return handleQueueCommand(words, core.DefaultQueue, (*entities.Message).WithObserve)
+	case values.Queues:
+		return handleBareCommand(words, (*entities.Message).WithQueues)
+	case values.Timers:
+		return handleQueueCommand(words, "", (*entities.Message).WithTimers)
+	case values.DeadLetters:
+		return handleQueueCommand(words, "", (*entities.Message).WithDeadLetters)
+	case values.Replay:
+		return handleIdCommand(words, (*entities.Message).WithReplay)
+	case values.Ack:
+		return handleIdCommand(words, (*entities.Message).WithAck)
+	case values.Info:
+		return handleBareCommand(words, (*entities.Message).WithInfo)
+	case values.Health:
+		return handleBareCommand(words, (*entities.Message).WithHealth)
+	case values.Stats:
+		return handleQueueCommand(words, "", (*entities.Message).WithStats)
+	case values.Auth:
+		return handleAuth(words)
+	case values.Resize:
+		return handleResize(words)
 	default:
 		return &entities.Message{}, ErrInvalidCommand
 	}
@@ -0,0 +1,277 @@
+package adapters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/auth"
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/limits"
+	"github.com/BarunKGP/timermq/internal/values"
+	"github.com/google/uuid"
+)
+
+// ErrorCode is the machine readable reason sent back in an `ERR` reply.
+type ErrorCode string
+
+const (
+	CodeParse           ErrorCode = "PARSE"
+	CodeTooShort        ErrorCode = "TOO_SHORT"
+	CodeInvalidCommand  ErrorCode = "INVALID_COMMAND"
+	CodeInvalidArgs     ErrorCode = "INVALID_ARGS"
+	CodeNotFound        ErrorCode = "NOT_FOUND"
+	CodeNotPending      ErrorCode = "NOT_PENDING"
+	CodeNotArchived     ErrorCode = "NOT_ARCHIVED"
+	CodeNotDelivered    ErrorCode = "NOT_DELIVERED"
+	CodeUnauthenticated ErrorCode = "UNAUTHENTICATED"
+	CodeForbidden       ErrorCode = "FORBIDDEN"
+	CodeThrottled       ErrorCode = "THROTTLED"
+	CodeQueueFull       ErrorCode = "QUEUE_FULL"
+	CodeInternal        ErrorCode = "INTERNAL"
+)
+
+var (
+	ErrMessageNotFound = errors.New("Message not found")
+	ErrMalformedReply  = errors.New("Malformed reply")
+	ErrUnauthenticated = errors.New("Authentication required")
+	ErrAuthDisabled    = errors.New("Authentication is not enabled")
+	ErrForbidden       = errors.New("Permission denied")
+)
+
+// Reply statuses. Every command gets exactly one `OK` or `ERR` line back;
+// subscribed connections additionally receive `MSG` frames, observing
+// connections `EVENT` frames, and both a final `BYE` frame when the server
+// shuts down.
+const (
+	StatusOK    = "OK"
+	StatusError = "ERR"
+	StatusMsg   = "MSG"
+	StatusEvent = "EVENT"
+	StatusBye   = "BYE"
+)
+
+type PushReply struct {
+	Id uuid.UUID `json:"id"`
+	// Duplicate is set when the push repeated the deduplication key of the
+	// message Id, and scheduled nothing.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+type GetReply struct {
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Value string    `json:"value"`
+	State string    `json:"state"`
+	Due   time.Time `json:"due,omitzero"`
+	// Priority is omitted for messages of the lowest priority.
+	Priority int `json:"priority,omitempty"`
+	// Group is omitted for messages published without one.
+	Group string `json:"group,omitempty"`
+	// Deadline is the end of the delivery window of the message, if any.
+	Deadline time.Time `json:"deadline,omitzero"`
+	// Reason is why a dead-lettered message was moved to the DLQ.
+	Reason string `json:"reason,omitempty"`
+}
+
+type DeliveryFrame struct {
+	Id       uuid.UUID `json:"id"`
+	Queue    string    `json:"queue"`
+	Value    string    `json:"value"`
+	Priority int       `json:"priority,omitempty"`
+	Group    string    `json:"group,omitempty"`
+	// Traceparent and Tracestate continue the trace of messages published
+	// with a trace context.
+	Traceparent string `json:"traceparent,omitempty"`
+	Tracestate  string `json:"tracestate,omitempty"`
+}
+
+// EventFrame is a lifecycle event of a message, sent to observing
+// connections.
+type EventFrame struct {
+	Type  string    `json:"type"`
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Value string    `json:"value"`
+	Due   time.Time `json:"due"`
+	At    time.Time `json:"at"`
+}
+
+type QueueInfo struct {
+	Name      string `json:"name"`
+	Pending   int    `json:"pending"`
+	Fired     int    `json:"fired"`
+	Cancelled int    `json:"cancelled"`
+}
+
+type InfoReply struct {
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    string    `json:"uptime"`
+	Listeners []string  `json:"listeners"`
+	Queues    int       `json:"queues"`
+}
+
+// HealthReply is the readiness reported by HEALTH. Ready is false if any
+// check failed.
+type HealthReply struct {
+	Ready  bool          `json:"ready"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// QueueStats are the figures reported by STATS for a queue. StoreBytes is
+// the size of every payload the queue has stored, PendingBytes that of the
+// pending messages, and LagMs how late, in milliseconds, its last timer
+// fired. Capacity is zero where the queue is unbounded.
+type QueueStats struct {
+	Name         string        `json:"name"`
+	Pending      int           `json:"pending"`
+	Fired        int           `json:"fired"`
+	Cancelled    int           `json:"cancelled"`
+	DeadLettered int           `json:"deadLettered"`
+	StoreBytes   int           `json:"storeBytes"`
+	PendingBytes int           `json:"pendingBytes"`
+	Capacity     core.Capacity `json:"capacity"`
+	LagMs        float64       `json:"lagMs"`
+}
+
+// ResizeReply is the capacity a queue admits after RESIZE.
+type ResizeReply struct {
+	Queue    string        `json:"queue"`
+	Capacity core.Capacity `json:"capacity"`
+}
+
+type TimerInfo struct {
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Due   time.Time `json:"due"`
+}
+
+type DeadLetter struct {
+	Id    uuid.UUID `json:"id"`
+	Queue string    `json:"queue"`
+	Value string    `json:"value"`
+	// Reason is why the message was moved to the DLQ: "cancelled",
+	// "overflow" or "window missed".
+	Reason string `json:"reason"`
+}
+
+// Reply is a decoded server line.
+type Reply struct {
+	Status  string
+	Code    ErrorCode
+	Message string
+	Payload json.RawMessage
+}
+
+func (r Reply) Decode(v any) error {
+	if len(r.Payload) == 0 {
+		return ErrMalformedReply
+	}
+	return json.Unmarshal(r.Payload, v)
+}
+
+func CodeOf(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ErrMsgParse):
+		return CodeParse
+	case errors.Is(err, ErrMsgTooShort), errors.Is(err, values.ErrMsgTooShort):
+		return CodeTooShort
+	case errors.Is(err, ErrInvalidCommand), errors.Is(err, values.ErrUnknownCommand), errors.Is(err, ErrAuthDisabled):
+		return CodeInvalidCommand
+	case errors.Is(err, ErrInvalidCommandArgs):
+		return CodeInvalidArgs
+	case errors.Is(err, ErrMessageNotFound):
+		return CodeNotFound
+	case errors.Is(err, core.ErrNotPending):
+		return CodeNotPending
+	case errors.Is(err, core.ErrNotArchived):
+		return CodeNotArchived
+	case errors.Is(err, core.ErrNotDelivered):
+		return CodeNotDelivered
+	case errors.Is(err, ErrUnauthenticated), errors.Is(err, auth.ErrInvalidCredentials):
+		return CodeUnauthenticated
+	case errors.Is(err, ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, limits.ErrThrottled), errors.Is(err, core.ErrOverflow):
+		return CodeThrottled
+	case errors.Is(err, core.ErrQueueFull):
+		return CodeQueueFull
+	default:
+		return CodeInternal
+	}
+}
+
+func (p *Protocol) encode(status string, payload any) ([]byte, error) {
+	if payload == nil {
+		return append([]byte(status), p.Delim), nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, 0, len(status)+len(data)+2)
+	line = append(line, status...)
+	line = append(line, ' ')
+	line = append(line, data...)
+	return append(line, p.Delim), nil
+}
+
+// EncodeOK renders a successful reply. payload may be nil.
+func (p *Protocol) EncodeOK(payload any) ([]byte, error) {
+	return p.encode(StatusOK, payload)
+}
+
+// EncodeMsg renders a frame pushed to a subscribed connection.
+func (p *Protocol) EncodeMsg(payload any) ([]byte, error) {
+	return p.encode(StatusMsg, payload)
+}
+
+// EncodeEvent renders a frame pushed to an observing connection.
+func (p *Protocol) EncodeEvent(payload any) ([]byte, error) {
+	return p.encode(StatusEvent, payload)
+}
+
+// EncodeBye renders the last frame sent to a subscriber before the server
+// closes its connection.
+func (p *Protocol) EncodeBye(reason string) []byte {
+	return []byte(fmt.Sprintf("%s %s%c", StatusBye, strings.ReplaceAll(reason, string(p.Delim), " "), p.Delim))
+}
+
+func (p *Protocol) EncodeError(err error) []byte {
+	text := strings.ReplaceAll(err.Error(), string(p.Delim), " ")
+	return []byte(fmt.Sprintf("%s %s %s%c", StatusError, CodeOf(err), text, p.Delim))
+}
+
+// ParseReply decodes a single line written by the server.
+func (p *Protocol) ParseReply(line string) (Reply, error) {
+	line = strings.TrimRight(line, "\r"+string(p.Delim))
+	status, rest, _ := strings.Cut(line, " ")
+
+	switch status {
+	case StatusOK, StatusMsg, StatusEvent:
+		r := Reply{Status: status}
+		if rest != "" {
+			r.Payload = json.RawMessage(rest)
+		}
+		return r, nil
+	case StatusBye:
+		return Reply{Status: status, Message: rest}, nil
+	case StatusError:
+		code, text, _ := strings.Cut(rest, " ")
+		if code == "" {
+			return Reply{}, ErrMalformedReply
+		}
+		return Reply{Status: status, Code: ErrorCode(code), Message: text}, nil
+	default:
+		return Reply{}, fmt.Errorf("%w: %q", ErrMalformedReply, line)
+	}
+}
@@ -1,23 +1,69 @@
 package servers
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/BarunKGP/timermq/internal/audit"
+	"github.com/BarunKGP/timermq/internal/auth"
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/health"
+	"github.com/BarunKGP/timermq/internal/limits"
+	"github.com/BarunKGP/timermq/internal/tracing"
 )
 
+var ErrShuttingDown = errors.New("Server is shutting down")
+
 type Server interface {
+	// Start serves until the server is shut down.
 	Start()
 
+	// Shutdown stops accepting connections and waits for in-flight
+	// commands until ctx is done.
+	Shutdown(ctx context.Context) error
+
 	Close() error
 }
 
+// ServerType is the protocol a listener speaks. Only TCP is implemented.
 type ServerType int
 
 const (
 	TCP ServerType = iota
-	HTTP
-	AMQP
 )
 
+var serverTypeNames = map[ServerType]string{
+	TCP: "tcp",
+}
+
+func (t ServerType) String() string {
+	if name, ok := serverTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ServerType(%d)", int(t))
+}
+
+func (t ServerType) MarshalText() ([]byte, error) {
+	name, ok := serverTypeNames[t]
+	if !ok {
+		return nil, fmt.Errorf("Invalid server type %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+func (t *ServerType) UnmarshalText(text []byte) error {
+	for key, name := range serverTypeNames {
+		if strings.EqualFold(name, string(text)) {
+			*t = key
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid server type %q", text)
+}
+
 type InitOpts struct {
 	Addr      string     `json:"addr"`
 	Port      uint16     `json:"port"`
@@ -26,10 +72,64 @@ type InitOpts struct {
 	Capacity  int        `json:"capacity"`
 }
 
-func NewServer(key ServerType, opts InitOpts) (Server, error) {
+// Option configures what a server needs besides its InitOpts.
+type Option func(*TCPServer)
+
+// WithAudit makes the server record the state changes its clients make to
+// loggable messages.
+func WithAudit(l *audit.Logger) Option {
+	return func(s *TCPServer) {
+		s.auditor = l
+	}
+}
+
+// WithTracer makes the server record spans for the messages its clients
+// publish with a trace context.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(s *TCPServer) {
+		s.tracer = t
+	}
+}
+
+// WithHealth makes HEALTH report the readiness evaluated by c. Without it,
+// the server checks its broker with the default settings.
+func WithHealth(c *health.Checker) Option {
+	return func(s *TCPServer) {
+		s.health = c
+	}
+}
+
+// WithAuth makes clients authenticate with AUTH against store before any
+// other command is accepted.
+func WithAuth(store *auth.Store) Option {
+	return func(s *TCPServer) {
+		s.auth = store
+	}
+}
+
+// WithTLS makes the server accept TLS connections only. Clients presenting
+// a verified certificate are identified by its common name, provided it
+// names a user of the auth store, if there is one.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *TCPServer) {
+		s.tls = cfg
+	}
+}
+
+// WithLimits makes the server throttle pushes and deliveries with l.
+func WithLimits(l *limits.Limiter) Option {
+	return func(s *TCPServer) {
+		s.limits = l
+	}
+}
+
+// NewServer builds a server of type key. Servers built with the same broker
+// share its queues; a nil broker gives the server its own, with queues sized
+// by opts.Capacity.
+func NewServer(key ServerType, opts InitOpts, broker *core.Broker, options ...Option) (Server, error) {
 	switch key {
 	case TCP:
-		return NewTCPServer(opts), nil
+		return NewTCPServer(opts, broker, options...), nil
 
 	default:
 		return nil, fmt.Errorf("Invalid key %+v", key)
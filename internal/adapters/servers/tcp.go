@@ -2,15 +2,29 @@ package servers
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"log/slog"
 
 	"github.com/BarunKGP/timermq/internal/adapters"
+	"github.com/BarunKGP/timermq/internal/audit"
+	"github.com/BarunKGP/timermq/internal/auth"
 	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/entities"
+	"github.com/BarunKGP/timermq/internal/health"
+	"github.com/BarunKGP/timermq/internal/limits"
+	"github.com/BarunKGP/timermq/internal/metrics"
+	"github.com/BarunKGP/timermq/internal/tlsconfig"
+	"github.com/BarunKGP/timermq/internal/tracing"
 	"github.com/BarunKGP/timermq/internal/values"
 	"github.com/google/uuid"
 )
@@ -20,22 +34,111 @@ type TCPServer struct {
 	Addr      string
 	KeepAlive bool
 
-	closed   bool
-	protocol adapters.Protocol
-	tmq      *core.TimerMQ
-	idMap    map[uuid.UUID]core.MessageIndex
+	protocol   adapters.Protocol
+	broker     *core.Broker
+	ownsBroker bool
+	deliveries *metrics.CounterVec
+	throttled  *metrics.CounterVec
+	auditor    *audit.Logger
+	tracer     *tracing.Tracer
+	health     *health.Checker
+	auth       *auth.Store
+	tls        *tls.Config
+	limits     *limits.Limiter
+
+	// Shutdown state. Command connections are tracked in commands so that
+	// Shutdown can wait for them; subscribers are only released by Close.
+	mu          sync.Mutex
+	listener    net.Listener
+	conns       map[net.Conn]bool
+	commands    sync.WaitGroup
+	subscribers sync.WaitGroup
+	draining    bool
+	closed      bool
+	quit        chan struct{}
+	// ctx is cancelled by Close, releasing the pushes waiting for a queue
+	// to drain.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// byeTimeout bounds how long Close waits for a subscriber to take its final
+// frame.
+const byeTimeout = time.Second
+
+// overflowRetryAfter is the hint sent along with pushes rejected because
+// the delivery channel of their queue is full.
+const overflowRetryAfter = time.Second
+
+// handshakeTimeout bounds how long a client may take to complete the TLS
+// handshake.
+const handshakeTimeout = 10 * time.Second
+
+// session describes the client behind a connection. header.ClientId is the
+// user the client authenticated as, with AUTH or a client certificate.
+type session struct {
+	addr   string
+	header values.CommandHeader
 }
 
-func NewTCPServer(opts InitOpts) *TCPServer {
-	return &TCPServer{
+// client identifies the client of sess for rate limits: the user it
+// authenticated as, or else its remote host.
+func (sess *session) client() string {
+	if sess.header.ClientId != "" {
+		return sess.header.ClientId
+	}
+	host, _, err := net.SplitHostPort(sess.addr)
+	if err != nil {
+		return sess.addr
+	}
+	return host
+}
+
+func NewTCPServer(opts InitOpts, broker *core.Broker, options ...Option) *TCPServer {
+	ownsBroker := broker == nil
+	if ownsBroker {
+		broker = core.NewBroker(opts.Capacity)
+	}
+	s := &TCPServer{
 		Port:      opts.Port,
 		Addr:      opts.Addr,
 		KeepAlive: opts.KeepAlive,
 
-		tmq:      core.NewTimerMQ(opts.Capacity),
-		idMap:    make(map[uuid.UUID]core.MessageIndex),
-		protocol: adapters.TCPProtocol(),
+		broker:     broker,
+		ownsBroker: ownsBroker,
+		protocol:   adapters.TCPProtocol(),
+		conns:      map[net.Conn]bool{},
+		quit:       make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, option := range options {
+		option(s)
+	}
+	if s.health == nil {
+		s.health = health.NewChecker(broker)
+	}
+	if reg := broker.Metrics(); reg != nil {
+		s.deliveries = reg.Counter("timermq_deliveries_total", "Messages written to a subscriber.", "queue")
+		s.throttled = reg.Counter("timermq_throttled_total", "Pushes refused by a rate limit.", "queue", "limit")
+		reg.GaugeFunc("timermq_connections", "Open client connections.", []string{"listener", "kind"}, s.collectConns)
 	}
+	return s
+}
+
+func (t *TCPServer) collectConns(emit func(float64, ...string)) {
+	t.mu.Lock()
+	var commands, subscribers int
+	for _, subscribed := range t.conns {
+		if subscribed {
+			subscribers++
+		} else {
+			commands++
+		}
+	}
+	t.mu.Unlock()
+
+	emit(float64(commands), t.GetFullAddress(), "command")
+	emit(float64(subscribers), t.GetFullAddress(), "subscriber")
 }
 
 func (t *TCPServer) Persistent() *TCPServer {
@@ -43,15 +146,134 @@ func (t *TCPServer) Persistent() *TCPServer {
 	return t
 }
 
+// Shutdown stops accepting connections and waits for every command in flight
+// to be answered. Idle command connections are closed; subscribers stay
+// attached so that they keep receiving messages until Close. If ctx is done
+// first, the remaining command connections are closed and ctx.Err is returned.
+func (t *TCPServer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.draining {
+		t.mu.Unlock()
+		return nil
+	}
+	t.draining = true
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	for conn, subscribed := range t.conns {
+		if !subscribed {
+			// Unblock the read of the next command. A command being
+			// executed is still answered.
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.commands.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.closeConns(false)
+		return ctx.Err()
+	}
+}
+
+// Close shuts the server down without waiting for commands in flight, sends a
+// final BYE frame to every subscriber and closes all connections.
 func (t *TCPServer) Close() error {
+	t.mu.Lock()
 	if t.closed {
+		t.mu.Unlock()
 		return fmt.Errorf("Server is already closed!")
 	}
 	t.closed = true
-	t.tmq.Close()
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	t.Shutdown(ctx)
+
+	close(t.quit)
+	t.cancel()
+	released := make(chan struct{})
+	go func() {
+		t.subscribers.Wait()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(byeTimeout):
+		// A subscriber is stuck writing a delivery.
+		t.closeConns(true)
+		<-released
+	}
+
+	if t.ownsBroker {
+		t.broker.Close()
+	}
 	return nil
 }
 
+// closeConns closes the tracked connections, subscribers included or not.
+func (t *TCPServer) closeConns(subscribers bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn, subscribed := range t.conns {
+		if subscribers || !subscribed {
+			conn.Close()
+		}
+	}
+}
+
+// track records conn as open, or reports false if the server is shutting down.
+func (t *TCPServer) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.conns[conn] = false
+	t.commands.Add(1)
+	return true
+}
+
+// promote moves conn from the command connections to the subscribers, or
+// reports false if the server is shutting down.
+func (t *TCPServer) promote(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[conn] = true
+	t.subscribers.Add(1)
+	t.commands.Done()
+	return true
+}
+
+func (t *TCPServer) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns[conn] {
+		t.subscribers.Done()
+	} else {
+		t.commands.Done()
+	}
+	delete(t.conns, conn)
+}
+
+func (t *TCPServer) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
 func formatAddr(addr string, port uint16) string {
 	return fmt.Sprintf("%s:%d", addr, port)
 }
@@ -60,48 +282,644 @@ func (s *TCPServer) GetFullAddress() string {
 	return formatAddr(s.Addr, s.Port)
 }
 
+func (s *TCPServer) lookup(val string) (core.MessageId, *core.TimerMQ, error) {
+	id, err := uuid.Parse(val)
+	if err != nil {
+		return id, nil, adapters.ErrInvalidCommandArgs
+	}
+
+	tmq, exists := s.broker.Resolve(id)
+	if !exists {
+		return id, nil, adapters.ErrMessageNotFound
+	}
+	return id, tmq, nil
+}
+
+// queues returns the queues a listing command applies to: the one named, or
+// all of them. Queues the client may not inspect are left out of the latter
+// rather than denied.
+func (s *TCPServer) queues(sess *session, msg *entities.Message) ([]string, error) {
+	if name := msg.GetQueue(); name != "" {
+		if err := s.permit(sess, msg, auth.PermInspect, name, uuid.Nil); err != nil {
+			return nil, err
+		}
+		return []string{name}, nil
+	}
+	var names []string
+	for _, name := range s.broker.Queues() {
+		if s.allowed(sess, auth.PermInspect, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (s *TCPServer) allowed(sess *session, perm auth.Permission, queue string) bool {
+	return s.auth == nil || s.auth.Allowed(sess.header.ClientId, perm, queue)
+}
+
+// permit checks that the client of sess holds perm on queue, and records the
+// denial of msg otherwise. id is the message msg applies to, if any.
+func (s *TCPServer) permit(sess *session, msg *entities.Message, perm auth.Permission, queue string, id uuid.UUID) error {
+	if s.allowed(sess, perm, queue) {
+		return nil
+	}
+	slog.Warn("Denied command", "cmd", msg.CommandType(), "user", sess.header.ClientId, "queue", queue, "permission", perm)
+	s.auditor.Log(audit.Record{
+		Time:       time.Now(),
+		Event:      audit.EventDenied,
+		Id:         id,
+		Queue:      queue,
+		Client:     sess.header.ClientId,
+		Addr:       sess.addr,
+		Command:    string(msg.CommandType()),
+		Permission: string(perm),
+	})
+	return adapters.ErrForbidden
+}
+
+// throttle counts and logs a push refused with err by a limit.
+func (s *TCPServer) throttle(sess *session, queue string, err error) error {
+	var throttled *limits.ThrottledError
+	if errors.As(err, &throttled) {
+		s.throttled.With(queue, throttled.Limit).Inc()
+		slog.Warn("Throttled push", "queue", queue, "client", sess.client(), "limit", throttled.Limit, "retryAfter", throttled.RetryAfter)
+	}
+	return err
+}
+
+// audit records a change the client of sess made to a loggable message.
+func (s *TCPServer) audit(sess *session, typ core.EventType, id core.MessageId, tmq *core.TimerMQ) {
+	if s.auditor == nil || !s.broker.Loggable(id) {
+		return
+	}
+	rec := audit.Record{Time: time.Now(), Event: typ, Id: id, Queue: tmq.Name(), Client: sess.header.ClientId, Addr: sess.addr}
+	if data, err := tmq.Get(id); err == nil {
+		rec.Value = string(data)
+	}
+	rec.Due, _ = tmq.Due(id)
+	s.auditor.Log(rec)
+}
+
+// authorize reports whether the client of sess may run msg. Until it
+// authenticates, a client may only send AUTH.
+func (s *TCPServer) authorize(sess *session, msg *entities.Message) error {
+	if s.auth == nil || sess.header.ClientId != "" || msg.CommandType() == values.Auth {
+		return nil
+	}
+	return adapters.ErrUnauthenticated
+}
+
+// redact hides the secret of an AUTH line so that it can be logged.
+func redact(line string) string {
+	words := strings.Fields(line)
+	if len(words) > 2 && strings.EqualFold(words[0], string(values.Auth)) {
+		return words[0] + " " + words[1] + " ***"
+	}
+	return line
+}
+
+// execute runs a parsed command against the broker and returns the reply
+// payload.
+func (s *TCPServer) execute(sess *session, msg *entities.Message) (any, error) {
+	switch msg.CommandType() {
+	case values.Push:
+		if err := s.permit(sess, msg, auth.PermPush, msg.GetQueue(), uuid.Nil); err != nil {
+			return nil, err
+		}
+		args := msg.GetArgs()
+		opts := core.PublishOpts{
+			Delay:     msg.GetDelay(),
+			Priority:  args.Priority,
+			Durable:   args.Durable,
+			Loggable:  args.Loggable,
+			Trace:     core.TraceContext{Producer: args.Traceparent, State: args.Tracestate},
+			Dedup:     args.Dedup,
+			Group:     args.Group,
+			NotBefore: args.NotBefore,
+			NotAfter:  args.NotAfter,
+			Jitter:    args.Jitter,
+		}
+		queue, data := msg.GetQueue(), msg.GetValueBytes()
+		if err := s.limits.Push(sess.client(), queue); err != nil {
+			return nil, s.throttle(sess, queue, err)
+		}
+		if err := s.broker.Queue(queue).Admit(s.ctx); errors.Is(err, core.ErrOverflow) {
+			return nil, fmt.Errorf("%w, retry after %s", err, overflowRetryAfter)
+		} else if err != nil {
+			return nil, ErrShuttingDown
+		}
+		id := core.NewMessageId()
+		span := s.tracer.StartPublish(opts.Trace, id, queue)
+		opts.Trace.Publish = span.Traceparent()
+		original, err := s.broker.Publish(id, queue, data, opts)
+		if err != nil {
+			span.SetError(err)
+			span.Finish(time.Now())
+			return nil, err
+		}
+		if original != id {
+			span.SetAttribute("timermq.duplicate_of", original.String())
+			span.Finish(time.Now())
+			slog.Info("Deduplicated message", "messageId", original, "queue", queue, "dedup", opts.Dedup)
+			return adapters.PushReply{Id: original, Duplicate: true}, nil
+		}
+		span.Finish(time.Now())
+		s.audit(sess, core.EventPublished, id, s.broker.Queue(queue))
+
+		slog.Info("Published message", "messageId", id, "queue", queue, "delayMs", msg.GetDelay().Milliseconds())
+		return adapters.PushReply{Id: id}, nil
+	case values.Get:
+		id, tmq, err := s.lookup(msg.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		if err := s.permit(sess, msg, auth.PermInspect, tmq.Name(), id); err != nil {
+			return nil, err
+		}
+		data, err := tmq.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		res := adapters.GetReply{Id: id, Queue: tmq.Name(), Value: string(data), State: string(tmq.State(id)), Priority: tmq.Priority(id), Group: tmq.Group(id)}
+		res.Deadline, _ = tmq.Deadline(id)
+		res.Reason = string(tmq.Reason(id))
+		if due, ok := tmq.Due(id); ok {
+			res.Due = due
+		}
+		return res, nil
+	case values.Cancel:
+		id, tmq, err := s.lookup(msg.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		if err := s.permit(sess, msg, auth.PermCancel, tmq.Name(), id); err != nil {
+			return nil, err
+		}
+		if err := tmq.CancelSend(id); err != nil {
+			return nil, err
+		}
+		s.audit(sess, core.EventCancelled, id, tmq)
+		return nil, nil
+	case values.Delay:
+		id, tmq, err := s.lookup(msg.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		if err := s.permit(sess, msg, auth.PermCancel, tmq.Name(), id); err != nil {
+			return nil, err
+		}
+		if err := tmq.Reschedule(id, msg.GetDelay()); err != nil {
+			return nil, err
+		}
+		s.audit(sess, core.EventRescheduled, id, tmq)
+		return nil, nil
+	case values.Replay:
+		id, tmq, err := s.lookup(msg.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		if err := s.permit(sess, msg, auth.PermAdmin, tmq.Name(), id); err != nil {
+			return nil, err
+		}
+		if err := tmq.Replay(id, 0); err != nil {
+			return nil, err
+		}
+		s.audit(sess, core.EventReplayed, id, tmq)
+		return nil, nil
+	case values.Ack:
+		id, tmq, err := s.lookup(msg.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		if err := s.permit(sess, msg, auth.PermConsume, tmq.Name(), id); err != nil {
+			return nil, err
+		}
+		if err := tmq.Ack(id); err != nil {
+			return nil, err
+		}
+		s.audit(sess, core.EventAcked, id, tmq)
+		return nil, nil
+	case values.Queues:
+		res := []adapters.QueueInfo{}
+		for _, name := range s.broker.Queues() {
+			if !s.allowed(sess, auth.PermInspect, name) {
+				continue
+			}
+			tmq, _ := s.broker.Lookup(name)
+			c := tmq.Counts()
+			// The cancelled count of QUEUES predates the other reasons to
+			// dead-letter a message, and counts every message in the DLQ.
+			res = append(res, adapters.QueueInfo{Name: name, Pending: c.Pending, Fired: c.Fired, Cancelled: c.Cancelled})
+		}
+		return res, nil
+	case values.Timers:
+		res := []adapters.TimerInfo{}
+		names, err := s.queues(sess, msg)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			tmq, ok := s.broker.Lookup(name)
+			if !ok {
+				continue
+			}
+			for _, t := range tmq.PendingTimers() {
+				res = append(res, adapters.TimerInfo{Id: t.Id, Queue: name, Due: t.Due})
+			}
+		}
+		return res, nil
+	case values.DeadLetters:
+		res := []adapters.DeadLetter{}
+		names, err := s.queues(sess, msg)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			tmq, ok := s.broker.Lookup(name)
+			if !ok {
+				continue
+			}
+			for _, id := range tmq.DeadLetters() {
+				data, err := tmq.Get(id)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, adapters.DeadLetter{Id: id, Queue: name, Value: string(data), Reason: string(tmq.Reason(id))})
+			}
+		}
+		return res, nil
+	case values.Info:
+		return adapters.InfoReply{
+			Version:   values.Version,
+			StartedAt: s.broker.StartedAt(),
+			Uptime:    s.broker.Uptime().Round(time.Second).String(),
+			Listeners: s.broker.Listeners(),
+			Queues:    len(s.broker.Queues()),
+		}, nil
+	case values.Health:
+		report := s.health.Ready()
+		res := adapters.HealthReply{Ready: report.Ready, Checks: make([]adapters.HealthCheck, 0, len(report.Checks))}
+		for _, c := range report.Checks {
+			res.Checks = append(res.Checks, adapters.HealthCheck{Name: c.Name, OK: c.OK, Detail: c.Detail})
+		}
+		return res, nil
+	case values.Stats:
+		res := []adapters.QueueStats{}
+		names, err := s.queues(sess, msg)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			tmq, ok := s.broker.Lookup(name)
+			if !ok {
+				continue
+			}
+			c := tmq.Counts()
+			res = append(res, adapters.QueueStats{
+				Name:         name,
+				Pending:      c.Pending,
+				Fired:        c.Fired,
+				Cancelled:    c.Cancelled,
+				DeadLettered: c.DeadLettered,
+				StoreBytes:   tmq.StoreBytes(),
+				PendingBytes: tmq.PendingBytes(),
+				Capacity:     tmq.Capacity(),
+				LagMs:        float64(tmq.Lag().Microseconds()) / 1000,
+			})
+		}
+		return res, nil
+	case values.Resize:
+		args := msg.GetArgs()
+		if err := s.permit(sess, msg, auth.PermAdmin, args.Queue, uuid.Nil); err != nil {
+			return nil, err
+		}
+		c := core.Capacity{Messages: args.MaxMessages, Bytes: args.MaxBytes}
+		s.broker.SetCapacity(args.Queue, c)
+		slog.Info("Resized queue", "queue", args.Queue, "client", sess.client(), "messages", c.Messages, "bytes", c.Bytes)
+		return adapters.ResizeReply{Queue: args.Queue, Capacity: c}, nil
+	case values.Auth:
+		if s.auth == nil {
+			return nil, adapters.ErrAuthDisabled
+		}
+		user := msg.GetValue()
+		if err := s.auth.Authenticate(user, msg.GetArgs().Secret); err != nil {
+			slog.Warn("Authentication failed", "user", user, "remote", sess.addr)
+			return nil, err
+		}
+		sess.header.ClientId = user
+		slog.Info("Client authenticated", "user", user, "remote", sess.addr)
+		return nil, nil
+	case values.Ping:
+		res := s.broker.Queue(core.DefaultQueue).Ping()
+		if res != "pong" {
+			slog.Warn("TimerMQ ping failed", "res", res)
+		}
+		return res, nil
+	default:
+		slog.Error("Unrecognized command type", "cmd", msg.CommandType())
+		return nil, adapters.ErrInvalidCommand
+	}
+}
+
+// reply executes msg and renders the line sent back to the client. parseErr is
+// the error returned while parsing msg, if any.
+func (s *TCPServer) reply(sess *session, msg *entities.Message, parseErr error) []byte {
+	if parseErr != nil {
+		return s.protocol.EncodeError(parseErr)
+	}
+	payload, err := s.execute(sess, msg)
+	if err != nil {
+		return s.protocol.EncodeError(err)
+	}
+	line, err := s.protocol.EncodeOK(payload)
+	if err != nil {
+		return s.protocol.EncodeError(err)
+	}
+	return line
+}
+
+// closed returns a channel closed once the client behind reader goes away.
+// Streaming connections do not accept further commands, so input is only
+// read to notice it.
+func closed(reader *bufio.Reader) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(done)
+	}()
+	return done
+}
+
+// bye sends the final frame of a streaming connection.
+func (s *TCPServer) bye(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(byeTimeout))
+	conn.Write(s.protocol.EncodeBye("server shutting down"))
+}
+
+// unackedSweep is how many deliveries a subscription records before it
+// first forgets the acked ones.
+const unackedSweep = 1024
+
+// unacked records the messages written to a subscriber that it has not acked
+// yet.
+type unacked struct {
+	tmq   *core.TimerMQ
+	ids   map[core.MessageId]struct{}
+	sweep int
+}
+
+func newUnacked(tmq *core.TimerMQ) *unacked {
+	return &unacked{tmq: tmq, ids: map[core.MessageId]struct{}{}, sweep: unackedSweep}
+}
+
+// add records id, first forgetting the messages acked or dead-lettered since
+// if enough were recorded.
+func (u *unacked) add(id core.MessageId) {
+	if len(u.ids) >= u.sweep {
+		for id := range u.ids {
+			if u.tmq.State(id) != core.StateDelivered {
+				delete(u.ids, id)
+			}
+		}
+		u.sweep = max(unackedSweep, 2*len(u.ids))
+	}
+	u.ids[id] = struct{}{}
+}
+
+// redeliver hands the messages still awaiting an ack back to the queue.
+func (u *unacked) redeliver() {
+	for id := range u.ids {
+		err := u.tmq.Redeliver(id)
+		switch {
+		case err == nil:
+			slog.Info("Redelivering unacked message", "messageId", id, "queue", u.tmq.Name())
+		case !errors.Is(err, core.ErrNotDelivered):
+			slog.Warn("Unable to redeliver message", "error", err, "messageId", id)
+		}
+	}
+}
+
+// subscribe streams fired messages to conn until the client disconnects or
+// the server is closed. Each delivery goes to a single subscriber; a message
+// whose frame could not be written, or that the subscriber had not acked
+// when it disconnected, is delivered again to the next one.
+func (s *TCPServer) subscribe(sess *session, conn net.Conn, reader *bufio.Reader, queue string) {
+	tmq := s.broker.Queue(queue)
+	if !s.promote(conn) {
+		conn.Write(s.protocol.EncodeError(ErrShuttingDown))
+		return
+	}
+	line, _ := s.protocol.EncodeOK(nil)
+	if _, err := conn.Write(line); err != nil {
+		return
+	}
+
+	done := closed(reader)
+	// Messages left unacked by a subscriber that goes away would otherwise
+	// stay delivered, and hold back the rest of their group for good.
+	pending := newUnacked(tmq)
+	disconnected := func() {
+		slog.Info("Subscriber disconnected")
+		pending.redeliver()
+	}
+	for {
+		// The next delivery is paced before it is taken, so that a message
+		// is never held back from the queue while the subscriber waits.
+		if wait := s.limits.Consume(sess.client(), queue); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+				timer.Stop()
+				disconnected()
+				return
+			case <-s.quit:
+				timer.Stop()
+				s.bye(conn)
+				return
+			case <-timer.C:
+			}
+		}
+
+		select {
+		case <-done:
+			disconnected()
+			return
+		case <-s.quit:
+			s.bye(conn)
+			return
+		case d, ok := <-tmq.Deliveries():
+			if !ok {
+				return
+			}
+			id := d.Id
+			// Marked first so that an ack racing the frame is accepted.
+			if err := tmq.MarkDelivered(id); errors.Is(err, core.ErrWindowMissed) {
+				continue
+			}
+			df := adapters.DeliveryFrame{Id: id, Queue: queue, Value: string(d.Data), Priority: d.Priority, Group: d.Group}
+			var span *tracing.Span
+			if tc, ok := s.broker.Trace(id); ok {
+				span = s.tracer.StartDelivery(tc, id, queue)
+				df.Traceparent, df.Tracestate = tc.Parent(), tc.State
+				if span != nil {
+					df.Traceparent = span.Traceparent()
+				}
+			}
+			frame, err := s.protocol.EncodeMsg(df)
+			if err != nil {
+				slog.Error("Unable to encode delivery", "error", err, "messageId", id)
+				continue
+			}
+			_, err = conn.Write(frame)
+			span.SetError(err)
+			span.Finish(time.Now())
+			if err != nil {
+				slog.Error("Unable to deliver message", "error", err, "messageId", id)
+				// The message never reached the subscriber, so another one
+				// gets it rather than leaving it, and its group, stuck.
+				pending.add(id)
+				pending.redeliver()
+				return
+			}
+			pending.add(id)
+			s.deliveries.With(queue).Inc()
+			s.audit(sess, core.EventDelivered, id, tmq)
+		}
+	}
+}
+
+// observeBuffer is how many events an observer may fall behind before the
+// later ones are dropped.
+const observeBuffer = 1024
+
+// observe streams the lifecycle events of queue to conn until the client
+// disconnects or the server is closed. Unlike subscribe, it takes no message
+// from the queue. Events the client is too slow to read are dropped rather
+// than held in memory.
+func (s *TCPServer) observe(conn net.Conn, reader *bufio.Reader, queue string) {
+	if !s.promote(conn) {
+		conn.Write(s.protocol.EncodeError(ErrShuttingDown))
+		return
+	}
+	line, _ := s.protocol.EncodeOK(nil)
+	if _, err := conn.Write(line); err != nil {
+		return
+	}
+
+	done := closed(reader)
+	events := make(chan core.Event, observeBuffer)
+	var dropped atomic.Int64
+	stop := s.broker.Queue(queue).Observe(func(e core.Event) {
+		select {
+		case events <- e:
+		default:
+			dropped.Add(1)
+		}
+	})
+	defer stop()
+
+	for {
+		select {
+		case <-done:
+			slog.Info("Observer disconnected")
+			return
+		case <-s.quit:
+			s.bye(conn)
+			return
+		case e := <-events:
+			if n := dropped.Swap(0); n > 0 {
+				slog.Warn("Observer fell behind, dropped events", "queue", queue, "dropped", n)
+			}
+			frame, err := s.protocol.EncodeEvent(adapters.EventFrame{Type: string(e.Type), Id: e.Id, Queue: e.Queue, Value: string(e.Data), Due: e.Due, At: e.At})
+			if err != nil {
+				slog.Error("Unable to encode event", "error", err, "messageId", e.Id)
+				continue
+			}
+			if _, err := conn.Write(frame); err != nil {
+				slog.Error("Unable to write event", "error", err, "messageId", e.Id)
+				return
+			}
+		}
+	}
+}
+
 func (s *TCPServer) handleConnection(conn net.Conn) {
-	slog.Info("New connection created")
 	defer conn.Close()
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+	sess := &session{addr: conn.RemoteAddr().String()}
+	if tc, ok := conn.(*tls.Conn); ok && !s.handshake(sess, tc) {
+		return
+	}
+	slog.Info("New connection created", "remote", sess.addr, "client", sess.header.ClientId)
 	reader := bufio.NewReader(conn)
 
 	for {
-		// buf := make([]byte, 2048)
-		// n, err := conn.Read(buf)
 		str, err := reader.ReadString(s.protocol.Delim)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			if errors.Is(err, io.EOF) || s.isDraining() {
 				slog.Info("Connection closed")
-				continue
+				return
 			}
 			slog.Error("Connection error", "error", err)
-			continue
+			return
 		}
-		slog.Debug("Received data from client", "data", str)
+		slog.Debug("Received data from client", "data", redact(str))
 
 		msg, err := s.protocol.Handle(str)
 		if err != nil {
-			slog.Error("Unable to parse message", "error", err, "message", str)
+			slog.Error("Unable to parse message", "error", err, "message", redact(str))
+		} else if err = s.authorize(sess, msg); err != nil {
+			slog.Warn("Rejected command of unauthenticated client", "cmd", msg.CommandType(), "remote", sess.addr)
+		} else if msg.CommandType() == values.Subscribe {
+			if err = s.permit(sess, msg, auth.PermConsume, msg.GetQueue(), uuid.Nil); err == nil {
+				s.subscribe(sess, conn, reader, msg.GetQueue())
+				return
+			}
+		} else if msg.CommandType() == values.Observe {
+			if err = s.permit(sess, msg, auth.PermInspect, msg.GetQueue(), uuid.Nil); err == nil {
+				s.observe(conn, reader, msg.GetQueue())
+				return
+			}
+		}
+
+		reply := s.reply(sess, msg, err)
+		if _, err := conn.Write(reply); err != nil {
+			slog.Error("Unable to write reply", "error", err)
+			return
 		}
+		if s.isDraining() {
+			return
+		}
+	}
+}
 
-		switch msg.CommandType() {
-		case values.Push:
-			id := s.tmq.Publish(msg.GetValueBytes(), msg.GetDelay())
-			s.idMap[msg.GetId()] = id
+// handshake completes the TLS handshake of conn and identifies its client
+// by certificate, reporting false if the connection must be dropped.
+func (s *TCPServer) handshake(sess *session, conn *tls.Conn) bool {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		slog.Warn("TLS handshake failed", "remote", sess.addr, "error", err)
+		return false
+	}
+	// Shutdown may have started during the handshake, whose deadline
+	// replaced the one meant to unblock the connection.
+	if s.isDraining() {
+		return false
+	}
+	conn.SetDeadline(time.Time{})
 
-			slog.Info("Published message", "messageId", msg.GetId(), "timermqId", id, "delayMs", msg.GetDelay().Milliseconds())
-		case values.Ping:
-			res := s.tmq.Ping()
-			if res == "pong" {
-				slog.Info("Ping returned: " + res)
-			} else {
-				slog.Info("Ping failed")
-				slog.Warn("TimerMQ ping failed", "res", res)
-			}
-		default:
-			slog.Error("Unrecognized command type", "cmd", msg.CommandType())
+	if id := tlsconfig.Identity(conn.ConnectionState()); id != "" {
+		if s.auth == nil || s.auth.HasUser(id) {
+			sess.header.ClientId = id
+		} else {
+			slog.Warn("Client certificate names an unknown user", "remote", sess.addr, "user", id)
 		}
 	}
+	return true
 }
 
 func (s *TCPServer) Start() {
@@ -111,14 +929,34 @@ func (s *TCPServer) Start() {
 		slog.Error("Failed to start server", "error", err)
 		return
 	}
+	s.Serve(listener)
+}
+
+// Serve accepts connections on listener and handles each one in its own
+// goroutine. It returns once Shutdown or Close is called.
+func (s *TCPServer) Serve(listener net.Listener) {
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		listener.Close()
+		return
+	}
+	scheme := "tcp://"
+	if s.tls != nil {
+		listener = tls.NewListener(listener, s.tls)
+		scheme = "tls://"
+	}
+	s.listener = listener
+	s.mu.Unlock()
 	defer listener.Close()
+	defer s.broker.AddListener(scheme + listener.Addr().String())()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				slog.Info("Connection closed")
-				continue
+			if errors.Is(err, net.ErrClosed) {
+				slog.Info("Stopped accepting connections", "address", listener.Addr().String())
+				return
 			}
 			slog.Error("Connection error", "error", err)
 			continue
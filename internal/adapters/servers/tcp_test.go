@@ -0,0 +1,253 @@
+package servers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters"
+	"github.com/BarunKGP/timermq/internal/auth"
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/values"
+	"github.com/google/uuid"
+)
+
+// failingConn fails every write after the first n.
+type failingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	if c.n == 0 {
+		return 0, errors.New("Connection reset")
+	}
+	c.n--
+	return c.Conn.Write(b)
+}
+
+func TestSubscriberDropped(t *testing.T) {
+	broker := core.NewBroker(4)
+	defer broker.Close()
+	s := NewTCPServer(InitOpts{}, broker)
+	client, server := net.Pipe()
+	defer client.Close()
+	closed := make(chan struct{})
+	go func() {
+		// The reply to SUBSCRIBE goes through, the first delivery does not.
+		s.handleConnection(&failingConn{Conn: server, n: 1})
+		close(closed)
+	}()
+
+	fmt.Fprint(client, "SUBSCRIBE orders\n")
+	if reply, err := bufio.NewReader(client).ReadString('\n'); err != nil || !strings.HasPrefix(reply, "OK") {
+		t.Fatalf("Expected the subscription to be accepted, received %q, %v", reply, err)
+	}
+	opts := core.PublishOpts{Group: "acct-1"}
+	first, _ := broker.Publish(core.NewMessageId(), "orders", []byte("first"), opts)
+	opts.Delay = time.Millisecond
+	second, _ := broker.Publish(core.NewMessageId(), "orders", []byte("second"), opts)
+	<-closed
+
+	tmq := broker.Queue("orders")
+	for _, id := range []core.MessageId{first, second} {
+		select {
+		case d := <-tmq.Deliveries():
+			if d.Id != id {
+				t.Fatalf("Expected %s to be delivered, received %s", id, d.Id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to be delivered again, the group is stuck", id)
+		}
+		if err := tmq.MarkDelivered(id); err != nil {
+			t.Fatal(err)
+		}
+		if err := tmq.Ack(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubscriberDisconnected(t *testing.T) {
+	broker := core.NewBroker(4)
+	defer broker.Close()
+	s := NewTCPServer(InitOpts{}, broker)
+	client, server := net.Pipe()
+	closed := make(chan struct{})
+	go func() {
+		s.handleConnection(server)
+		close(closed)
+	}()
+
+	fmt.Fprint(client, "SUBSCRIBE orders\n")
+	reader := bufio.NewReader(client)
+	if reply, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(reply, "OK") {
+		t.Fatalf("Expected the subscription to be accepted, received %q, %v", reply, err)
+	}
+	opts := core.PublishOpts{Group: "acct-1"}
+	first, _ := broker.Publish(core.NewMessageId(), "orders", []byte("first"), opts)
+	opts.Delay = time.Millisecond
+	second, _ := broker.Publish(core.NewMessageId(), "orders", []byte("second"), opts)
+	if frame, err := reader.ReadString('\n'); err != nil || !strings.Contains(frame, first.String()) {
+		t.Fatalf("Expected %s to be delivered, received %q, %v", first, frame, err)
+	}
+	// The subscriber goes away in the middle of the group, without acking.
+	client.Close()
+	<-closed
+
+	tmq := broker.Queue("orders")
+	for _, id := range []core.MessageId{first, second} {
+		select {
+		case d := <-tmq.Deliveries():
+			if d.Id != id {
+				t.Fatalf("Expected %s to be delivered, received %s", id, d.Id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to be delivered again, the group is stuck", id)
+		}
+		if err := tmq.MarkDelivered(id); err != nil {
+			t.Fatal(err)
+		}
+		if err := tmq.Ack(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// run parses line and executes it for sess like a command connection does.
+func run(s *TCPServer, sess *session, line string) (any, error) {
+	msg, err := s.protocol.Handle(line + "\n")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(sess, msg); err != nil {
+		return nil, err
+	}
+	return s.execute(sess, msg)
+}
+
+func TestExecute(t *testing.T) {
+	store, err := auth.NewStore(auth.File{
+		Users: []auth.User{
+			// hunter2, hashed cheaply to keep the test fast.
+			{Name: "admin", Secret: "pbkdf2-sha256$1000$TbEYpCUh+3qC+8Y6PWj1qg$xCahiLx6vyAYCH+uMSeyNkiARDO/4uP79mJqW6/ikJs"},
+			{Name: "orders", Secret: "pbkdf2-sha256$1000$TbEYpCUh+3qC+8Y6PWj1qg$xCahiLx6vyAYCH+uMSeyNkiARDO/4uP79mJqW6/ikJs"},
+		},
+		ACL: []auth.Rule{
+			{User: "admin", Queues: "*", Permissions: []auth.Permission{auth.PermAdmin}},
+			{User: "orders", Queues: "orders.*", Permissions: []auth.Permission{auth.PermPush, auth.PermInspect}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := core.NewBroker(4).WithDedupWindow(time.Hour)
+	defer broker.Close()
+	s := NewTCPServer(InitOpts{}, broker, WithAuth(store))
+
+	pending, _ := broker.Publish(core.NewMessageId(), "orders.eu", []byte("pending"), core.PublishOpts{Delay: time.Hour, Dedup: "order-1"})
+	billing, _ := broker.Publish(core.NewMessageId(), "billing", []byte("invoice"), core.PublishOpts{Delay: time.Hour})
+	cancelled, _ := broker.Publish(core.NewMessageId(), "billing", []byte("refund"), core.PublishOpts{Delay: time.Hour})
+	if err := broker.Queue("billing").CancelSend(cancelled); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish(core.NewMessageId(), "full", []byte("first"), core.PublishOpts{Delay: time.Hour})
+	broker.SetCapacity("full", core.Capacity{Messages: 1})
+
+	tests := []struct {
+		name  string
+		user  string
+		line  string
+		err   error
+		check func(t *testing.T, res any)
+	}{
+		{name: "unauthenticated", line: "PING", err: adapters.ErrUnauthenticated},
+		{name: "auth", line: "AUTH orders hunter2"},
+		{name: "auth wrong secret", line: "AUTH orders hunter3", err: auth.ErrInvalidCredentials},
+		{name: "ping", user: "orders", line: "PING", check: func(t *testing.T, res any) {
+			if res != "pong" {
+				t.Errorf("Expected pong, received %v", res)
+			}
+		}},
+		{name: "push", user: "orders", line: "PUSH hello queue=orders.eu delay=60000", check: func(t *testing.T, res any) {
+			if r, ok := res.(adapters.PushReply); !ok || r.Id == uuid.Nil || r.Duplicate {
+				t.Errorf("Expected a new message, received %+v", res)
+			}
+		}},
+		{name: "push duplicate", user: "orders", line: "PUSH again queue=orders.eu dedup=order-1", check: func(t *testing.T, res any) {
+			if r, ok := res.(adapters.PushReply); !ok || r.Id != pending || !r.Duplicate {
+				t.Errorf("Expected the first message with the key, received %+v", res)
+			}
+		}},
+		{name: "push forbidden", user: "orders", line: "PUSH hello queue=billing", err: adapters.ErrForbidden},
+		{name: "push over capacity", user: "admin", line: "PUSH second queue=full", err: core.ErrQueueFull},
+		{name: "push invalid window", user: "admin", line: "PUSH late window=2026-01-02T00:00:00Z/2026-01-01T00:00:00Z", err: adapters.ErrInvalidCommandArgs},
+		{name: "get", user: "orders", line: "GET " + pending.String(), check: func(t *testing.T, res any) {
+			if r, ok := res.(adapters.GetReply); !ok || r.Queue != "orders.eu" || r.Value != "pending" || r.State != string(core.StatePending) {
+				t.Errorf("Unexpected message %+v", res)
+			}
+		}},
+		{name: "get dead-lettered", user: "admin", line: "GET " + cancelled.String(), check: func(t *testing.T, res any) {
+			if r, ok := res.(adapters.GetReply); !ok || r.State != string(core.StateDeadLettered) || r.Reason != string(core.ReasonCancelled) {
+				t.Errorf("Unexpected message %+v", res)
+			}
+		}},
+		{name: "get forbidden", user: "orders", line: "GET " + billing.String(), err: adapters.ErrForbidden},
+		{name: "get unknown", user: "admin", line: "GET " + uuid.NewString(), err: adapters.ErrMessageNotFound},
+		{name: "get invalid id", user: "admin", line: "GET order-1", err: adapters.ErrInvalidCommandArgs},
+		{name: "cancel forbidden", user: "orders", line: "CANCEL " + pending.String(), err: adapters.ErrForbidden},
+		{name: "cancel fired", user: "admin", line: "CANCEL " + cancelled.String(), err: core.ErrNotPending},
+		{name: "delay", user: "admin", line: "DELAY " + billing.String() + " 1000"},
+		{name: "ack pending", user: "admin", line: "ACK " + pending.String(), err: core.ErrNotDelivered},
+		{name: "queues", user: "orders", line: "QUEUES", check: func(t *testing.T, res any) {
+			if r, ok := res.([]adapters.QueueInfo); !ok || len(r) != 1 || r[0].Name != "orders.eu" || r[0].Pending != 2 {
+				t.Errorf("Expected only the queues the user may inspect, received %+v", res)
+			}
+		}},
+		{name: "dlq", user: "admin", line: "DLQ billing", check: func(t *testing.T, res any) {
+			if r, ok := res.([]adapters.DeadLetter); !ok || len(r) != 1 || r[0].Id != cancelled || r[0].Reason != string(core.ReasonCancelled) {
+				t.Errorf("Unexpected dead letters %+v", res)
+			}
+		}},
+		{name: "dlq forbidden", user: "orders", line: "DLQ billing", err: adapters.ErrForbidden},
+		{name: "resize forbidden", user: "orders", line: "RESIZE orders.eu 10 100", err: adapters.ErrForbidden},
+		{name: "resize", user: "admin", line: "RESIZE orders.eu 10 100", check: func(t *testing.T, res any) {
+			if c := broker.Queue("orders.eu").Capacity(); c != (core.Capacity{Messages: 10, Bytes: 100}) {
+				t.Errorf("Expected the queue to be resized, found %+v", c)
+			}
+		}},
+		{name: "replay", user: "admin", line: "REPLAY " + cancelled.String()},
+		{name: "replay again", user: "admin", line: "REPLAY " + cancelled.String(), err: core.ErrNotArchived},
+		{name: "queues after replay", user: "admin", line: "QUEUES", check: func(t *testing.T, res any) {
+			r, _ := res.([]adapters.QueueInfo)
+			i := slices.IndexFunc(r, func(q adapters.QueueInfo) bool { return q.Name == "billing" })
+			if i < 0 || r[i].Cancelled != 1 {
+				t.Errorf("Expected billing to count its cancel once its DLQ is empty, received %+v", res)
+			}
+		}},
+		{name: "info", user: "admin", line: "INFO", check: func(t *testing.T, res any) {
+			if r, ok := res.(adapters.InfoReply); !ok || !r.StartedAt.Equal(broker.StartedAt()) {
+				t.Errorf("Expected the start time of the broker, received %+v", res)
+			}
+		}},
+		{name: "unknown command", user: "admin", line: "PULL", err: values.ErrUnknownCommand},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sess := &session{addr: "127.0.0.1:1234"}
+			sess.header.ClientId = tc.user
+			res, err := run(s, sess, tc.line)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Expected %v, received %v", tc.err, err)
+			}
+			if tc.check != nil {
+				tc.check(t, res)
+			}
+		})
+	}
+}
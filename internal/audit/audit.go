@@ -0,0 +1,201 @@
+// Package audit records the state changes of loggable messages to a sink: a
+// rotating JSON lines file, stdout or a syslog socket.
+//
+// Every method on a nil *Logger is a no-op, so callers do not need to check
+// whether auditing is enabled.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidOutput = errors.New("Invalid audit output")
+
+// EventDenied records a command refused by access control. Denials are
+// recorded whether or not the message is loggable.
+const EventDenied core.EventType = "denied"
+
+// Record is a single state change of a loggable message, or a denied
+// command.
+type Record struct {
+	Time  time.Time      `json:"time"`
+	Event core.EventType `json:"event"`
+	Id    uuid.UUID      `json:"id,omitzero"`
+	Queue string         `json:"queue"`
+	Value string         `json:"value"`
+	Due   time.Time      `json:"due,omitzero"`
+	// Client is the identity of the client that caused the change, when
+	// known. Changes made by the broker itself, such as a timer firing,
+	// have neither a client nor an address.
+	Client string `json:"client,omitempty"`
+	Addr   string `json:"addr,omitempty"`
+	// Command and Permission are what a denied client attempted and
+	// lacked.
+	Command    string `json:"command,omitempty"`
+	Permission string `json:"permission,omitempty"`
+}
+
+// Sink writes records somewhere. Write is never called concurrently.
+type Sink interface {
+	Write(r Record) error
+	Close() error
+}
+
+// Logger serialises records onto a sink. Write errors are logged and the
+// record is dropped, so that auditing never fails a command. Records logged
+// after Close are dropped too.
+type Logger struct {
+	mu     sync.Mutex
+	sink   Sink
+	closed bool
+}
+
+func NewLogger(sink Sink) *Logger {
+	return &Logger{sink: sink}
+}
+
+func (l *Logger) Log(r Record) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if err := l.sink.Write(r); err != nil {
+		slog.Error("Unable to write audit record", "error", err, "event", r.Event, "messageId", r.Id)
+	}
+}
+
+// Watch records the state changes of broker's loggable messages that no
+// client causes: timers firing and messages expiring. Changes made by a
+// command are recorded by the server that ran it, along with the client.
+func (l *Logger) Watch(broker *core.Broker) (stop func()) {
+	if l == nil {
+		return func() {}
+	}
+	return broker.Observe(func(e core.Event) {
+		if e.Type != core.EventFired && e.Type != core.EventExpired {
+			return
+		}
+		if !broker.Loggable(e.Id) {
+			return
+		}
+		l.Log(Record{Time: e.At, Event: e.Type, Id: e.Id, Queue: e.Queue, Value: string(e.Data), Due: e.Due})
+	})
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.sink.Close()
+}
+
+// Options describe where records go.
+type Options struct {
+	// Output is "stdout", a file path or a syslog URL such as
+	// syslog+udp://host:514, syslog+tcp://host:601 or
+	// syslog+unix:///dev/log.
+	Output string
+	// MaxSize is the size in bytes a file may reach before it is rotated.
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept.
+	MaxBackups int
+}
+
+// Open builds the sink described by opts.
+func Open(opts Options) (Sink, error) {
+	switch {
+	case opts.Output == "":
+		return nil, fmt.Errorf("%w: output is required", ErrInvalidOutput)
+	case opts.Output == "stdout":
+		return NewWriterSink(nopCloser{os.Stdout}), nil
+	case strings.HasPrefix(opts.Output, "syslog+"):
+		network, addr, err := ParseSyslogURL(opts.Output)
+		if err != nil {
+			return nil, err
+		}
+		return NewSyslogSink(network, addr)
+	default:
+		return NewFileSink(opts.Output, opts.MaxSize, opts.MaxBackups)
+	}
+}
+
+// ParseSyslogURL splits a syslog+<network>:// URL into the network and
+// address to dial.
+func ParseSyslogURL(raw string) (network, addr string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("%w %q: %w", ErrInvalidOutput, raw, err)
+	}
+	switch u.Scheme {
+	case "syslog+udp", "syslog+tcp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("%w %q: host:port is required", ErrInvalidOutput, raw)
+		}
+		return strings.TrimPrefix(u.Scheme, "syslog+"), u.Host, nil
+	case "syslog+unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("%w %q: socket path is required", ErrInvalidOutput, raw)
+		}
+		return "unix", u.Path, nil
+	default:
+		return "", "", fmt.Errorf("%w %q: unknown scheme %s", ErrInvalidOutput, raw, u.Scheme)
+	}
+}
+
+// WriterSink writes records as JSON lines.
+type WriterSink struct {
+	w io.WriteCloser
+}
+
+func NewWriterSink(w io.WriteCloser) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(r Record) error {
+	line, err := encodeLine(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(line)
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return s.w.Close()
+}
+
+func encodeLine(r Record) ([]byte, error) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock/clocktest"
+	"github.com/BarunKGP/timermq/internal/core"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (s *memorySink) Write(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Record(nil), s.records...)
+}
+
+func readLines(t *testing.T, path string) []Record {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	line, _ := encodeLine(Record{Event: core.EventPublished, Value: "x"})
+	// Room for two records per file.
+	sink, err := NewFileSink(path, int64(2*len(line)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 7 {
+		if err := sink.Write(Record{Event: core.EventPublished, Value: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	for file, want := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		if n := len(readLines(t, file)); n != want {
+			t.Errorf("Expected %d records in %s, found %d", want, file, n)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept, found %s.3", path)
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewSyslogSink("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	id := core.NewMessageId()
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := sink.Write(Record{Time: at, Event: core.EventAcked, Id: id, Queue: "emails", Addr: "10.0.0.1:5000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<134>1 2024-01-01T00:00:00Z ") || !strings.Contains(msg, " timermq ") {
+		t.Errorf("Unexpected syslog header in %q", msg)
+	}
+	var r Record
+	if err := json.Unmarshal([]byte(msg[strings.Index(msg, "{"):]), &r); err != nil || r.Id != id || r.Addr != "10.0.0.1:5000" {
+		t.Errorf("Unexpected syslog body in %q: %v", msg, err)
+	}
+}
+
+func TestParseSyslogURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"syslog+udp://localhost:514":  "udp localhost:514",
+		"syslog+tcp://10.0.0.1:601":   "tcp 10.0.0.1:601",
+		"syslog+unix:///dev/log":      "unix /dev/log",
+		"syslog+udp://":               "",
+		"syslog+http://localhost:514": "",
+	} {
+		network, addr, err := ParseSyslogURL(raw)
+		if want == "" {
+			if err == nil {
+				t.Errorf("Expected %q to be rejected", raw)
+			}
+			continue
+		}
+		if err != nil || network+" "+addr != want {
+			t.Errorf("Expected %q for %q, found %q %q, %v", want, raw, network, addr, err)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := core.NewBroker(4).WithClock(clk)
+	defer broker.Close()
+	sink := &memorySink{}
+	logger := NewLogger(sink)
+	stop := logger.Watch(broker)
+	defer stop()
+
+	loggable := core.NewMessageId()
+	broker.Publish(loggable, "emails", []byte("audited"), core.PublishOpts{Delay: time.Second, Loggable: true})
+	broker.Publish(core.NewMessageId(), "emails", []byte("quiet"), core.PublishOpts{Delay: time.Second})
+	clk.Advance(time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for len(sink.Records()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Give a wrongly recorded event for the other message time to show up.
+	time.Sleep(10 * time.Millisecond)
+	records := sink.Records()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, found %+v", records)
+	}
+	if r := records[0]; r.Event != core.EventFired || r.Id != loggable || r.Value != "audited" || !r.Time.Equal(clk.Now()) {
+		t.Errorf("Unexpected record %+v", r)
+	}
+
+	logger.Close()
+	logger.Log(Record{Event: core.EventAcked})
+	if n := len(sink.Records()); n != 1 {
+		t.Errorf("Expected records after Close to be dropped, found %d", n)
+	}
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// FileSink appends JSON lines to a file and rotates it once it would grow
+// past maxSize: path becomes path.1, path.1 becomes path.2 and so on, and
+// only maxBackups rotated files are kept.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("%w: max size must be positive, found %d", ErrInvalidOutput, maxSize)
+	}
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("%w: max backups must not be negative, found %d", ErrInvalidOutput, maxBackups)
+	}
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(r Record) error {
+	line, err := encodeLine(r)
+	if err != nil {
+		return err
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate moves the current file aside and starts a new one. The file is
+// reopened even if moving it failed, so that later records are not lost.
+func (s *FileSink) rotate() error {
+	err := errors.Join(s.f.Close(), s.shift())
+	if openErr := s.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+func (s *FileSink) shift() error {
+	if s.maxBackups == 0 {
+		return os.Remove(s.path)
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(s.backup(i), s.backup(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.backup(1))
+}
+
+func (s *FileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
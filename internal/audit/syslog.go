@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Syslog priority of audit records: facility local0, severity info.
+const syslogPriority = 16*8 + 6
+
+const syslogDialTimeout = 5 * time.Second
+
+// SyslogSink sends each record as an RFC 5424 message whose body is the
+// record in JSON. Messages sent over TCP are framed with their length
+// (RFC 6587). A broken connection is dialed again on the next record.
+type SyslogSink struct {
+	network  string
+	addr     string
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslogSink connects to a syslog daemon. network is "udp", "tcp" or
+// "unix"; unix sockets are tried as datagram sockets first, as /dev/log
+// usually is.
+func NewSyslogSink(network, addr string) (*SyslogSink, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	s := &SyslogSink{network: network, addr: addr, hostname: hostname}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) dial() error {
+	if s.network != "unix" {
+		conn, err := net.DialTimeout(s.network, s.addr, syslogDialTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}
+
+	conn, err := net.DialTimeout("unixgram", s.addr, syslogDialTimeout)
+	if err != nil {
+		if conn, err = net.DialTimeout("unix", s.addr, syslogDialTimeout); err != nil {
+			return err
+		}
+		s.network = "unix-stream"
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *SyslogSink) format(r Record) ([]byte, error) {
+	body, err := encodeLine(r)
+	if err != nil {
+		return nil, err
+	}
+	msg := fmt.Appendf(nil, "<%d>1 %s %s timermq %d audit - %s",
+		syslogPriority, r.Time.UTC().Format(time.RFC3339Nano), s.hostname, os.Getpid(), body[:len(body)-1])
+	switch s.network {
+	case "tcp":
+		return append(fmt.Appendf(nil, "%d ", len(msg)), msg...), nil
+	case "unix-stream":
+		return append(msg, '\n'), nil
+	default:
+		return msg, nil
+	}
+}
+
+func (s *SyslogSink) Write(r Record) error {
+	msg, err := s.format(r)
+	if err != nil {
+		return err
+	}
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.conn.Write(msg); err == nil {
+		return nil
+	}
+
+	s.conn.Close()
+	s.conn = nil
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
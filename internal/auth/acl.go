@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+)
+
+// Permission is what an ACL rule grants on the queues it matches.
+type Permission string
+
+const (
+	// PermPush allows publishing messages.
+	PermPush Permission = "push"
+	// PermConsume allows subscribing and acknowledging deliveries.
+	PermConsume Permission = "consume"
+	// PermCancel allows cancelling and rescheduling pending messages.
+	PermCancel Permission = "cancel"
+	// PermInspect allows reading messages, timers, dead letters and stats,
+	// and observing lifecycle events.
+	PermInspect Permission = "inspect"
+	// PermAdmin allows replaying dead letters and resizing queues, and
+	// implies every other permission.
+	PermAdmin Permission = "admin"
+)
+
+var permissions = []Permission{PermPush, PermConsume, PermCancel, PermInspect, PermAdmin}
+
+// Rule grants permissions on the queues whose name matches Queues, a
+// path.Match pattern such as "orders.*", to a user or to every user holding
+// a role. Exactly one of User and Role is set.
+type Rule struct {
+	User        string       `json:"user,omitempty"`
+	Role        string       `json:"role,omitempty"`
+	Queues      string       `json:"queues"`
+	Permissions []Permission `json:"permissions"`
+}
+
+func (r Rule) check(users map[string]secretHash) error {
+	var errs []error
+	if (r.User == "") == (r.Role == "") {
+		errs = append(errs, errors.New("exactly one of user and role is required"))
+	} else if _, ok := users[r.User]; r.User != "" && !ok {
+		errs = append(errs, fmt.Errorf("unknown user %s", r.User))
+	}
+	if _, err := path.Match(r.Queues, ""); err != nil || r.Queues == "" {
+		errs = append(errs, fmt.Errorf("invalid queue pattern %q", r.Queues))
+	}
+	if len(r.Permissions) == 0 {
+		errs = append(errs, errors.New("at least one permission is required"))
+	}
+	for _, p := range r.Permissions {
+		if !slices.Contains(permissions, p) {
+			errs = append(errs, fmt.Errorf("unknown permission %q", p))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r Rule) grants(user string, roles []string, perm Permission, queue string) bool {
+	if r.User != "" && r.User != user || r.Role != "" && !slices.Contains(roles, r.Role) {
+		return false
+	}
+	if matched, _ := path.Match(r.Queues, queue); !matched {
+		return false
+	}
+	return slices.Contains(r.Permissions, perm) || slices.Contains(r.Permissions, PermAdmin)
+}
+
+// Allowed reports whether user holds perm on queue. A credentials file
+// without ACL rules grants every permission to every user; otherwise
+// anything not granted by a rule is denied.
+func (s *Store) Allowed(user string, perm Permission, queue string) bool {
+	if s == nil {
+		return false
+	}
+	if _, ok := s.users[user]; !ok {
+		return false
+	}
+	if len(s.rules) == 0 {
+		return true
+	}
+	for _, r := range s.rules {
+		if r.grants(user, s.roles[user], perm, queue) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+// Package auth authenticates clients against a credentials file: users
+// sending AUTH on the TCP protocol, and bearer tokens on the HTTP listener.
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+	ErrInvalidHash        = errors.New("Invalid hash")
+)
+
+// File is the layout of a credentials file. Secrets and tokens are stored
+// hashed, as produced by HashSecret and HashToken.
+type File struct {
+	Users  []User  `json:"users"`
+	Tokens []Token `json:"tokens"`
+	// ACL lists what users may do to which queues.
+	ACL []Rule `json:"acl"`
+}
+
+// User is a client allowed to AUTH with its secret. Users without a secret
+// can only be identified by a TLS client certificate whose common name is
+// their name.
+type User struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret,omitempty"`
+	// Roles are matched by the ACL rules granted to a role.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Token is a bearer token accepted by the HTTP listener. Name identifies
+// whoever holds it.
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// Store checks credentials. A nil Store accepts nothing.
+type Store struct {
+	users  map[string]secretHash
+	roles  map[string][]string
+	tokens []namedDigest
+	rules  []Rule
+	// decoy is hashed for unknown users so that they take as long to
+	// reject as wrong secrets.
+	decoy secretHash
+}
+
+type namedDigest struct {
+	name   string
+	digest []byte
+}
+
+// Load reads the credentials file at path.
+func Load(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", path, err)
+	}
+	store, err := NewStore(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return store, nil
+}
+
+// NewStore checks every entry of f and reports all problems at once.
+func NewStore(f File) (*Store, error) {
+	s := &Store{users: map[string]secretHash{}, roles: map[string][]string{}}
+	var errs []error
+	for i, u := range f.Users {
+		var h secretHash
+		var err error
+		if u.Secret != "" {
+			h, err = parseSecretHash(u.Secret)
+		}
+		switch {
+		case u.Name == "" || strings.ContainsAny(u.Name, " \t\r\n"):
+			errs = append(errs, fmt.Errorf("users[%d]: name must be non-empty and must not contain whitespace", i))
+		case err != nil:
+			errs = append(errs, fmt.Errorf("users[%d]: %w", i, err))
+		default:
+			if _, dup := s.users[u.Name]; dup {
+				errs = append(errs, fmt.Errorf("users[%d]: %s is listed more than once", i, u.Name))
+			}
+			s.users[u.Name] = h
+			s.roles[u.Name] = u.Roles
+			if h.key != nil {
+				s.decoy = h
+			}
+		}
+	}
+	for i, t := range f.Tokens {
+		digest, err := parseTokenHash(t.Token)
+		switch {
+		case t.Name == "":
+			errs = append(errs, fmt.Errorf("tokens[%d]: name is required", i))
+		case err != nil:
+			errs = append(errs, fmt.Errorf("tokens[%d]: %w", i, err))
+		default:
+			s.tokens = append(s.tokens, namedDigest{name: t.Name, digest: digest})
+		}
+	}
+	for i, r := range f.ACL {
+		if err := r.check(s.users); err != nil {
+			errs = append(errs, fmt.Errorf("acl[%d]: %w", i, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	s.rules = f.ACL
+	return s, nil
+}
+
+// Authenticate checks the secret of user.
+func (s *Store) Authenticate(user, secret string) error {
+	if s == nil {
+		return ErrInvalidCredentials
+	}
+	h, ok := s.users[user]
+	if !ok || h.key == nil {
+		if s.decoy.key != nil {
+			s.decoy.matches(secret)
+		}
+		return ErrInvalidCredentials
+	}
+	if !h.matches(secret) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// HasUser reports whether name is a user of the store, for clients
+// identified by a certificate rather than by AUTH.
+func (s *Store) HasUser(name string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.users[name]
+	return ok
+}
+
+// Bearer returns the name of the holder of token.
+func (s *Store) Bearer(token string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(token))
+	name, ok := "", false
+	// Every digest is compared so that the time taken does not reveal
+	// which one matched.
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(sum[:], t.digest) == 1 {
+			name, ok = t.name, true
+		}
+	}
+	return name, ok
+}
+
+// Protect rejects requests to next that do not carry a known bearer token
+// in their Authorization header.
+func (s *Store) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			unauthorized(w)
+			return
+		}
+		if _, ok := s.Bearer(strings.TrimSpace(token)); !ok {
+			unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="timermq"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	secret, err := hashSecret("hunter2", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(File{
+		Users:  []User{{Name: "orders", Secret: secret}},
+		Tokens: []Token{{Name: "prometheus", Token: HashToken(token)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, token
+}
+
+func TestAuthenticate(t *testing.T) {
+	store, _ := testStore(t)
+	if err := store.Authenticate("orders", "hunter2"); err != nil {
+		t.Errorf("Expected the right secret to be accepted, received %v", err)
+	}
+	for _, c := range [][2]string{{"orders", "hunter3"}, {"billing", "hunter2"}, {"orders", ""}} {
+		if err := store.Authenticate(c[0], c[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Expected %v to be rejected, received %v", c, err)
+		}
+	}
+
+	var disabled *Store
+	if err := disabled.Authenticate("orders", "hunter2"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a nil store to reject everyone, received %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	secret, _ := hashSecret("hunter2", 1000)
+	os.WriteFile(path, []byte(`{"users": [{"name": "orders", "secret": "`+secret+`"}]}`), 0o600)
+	store, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Authenticate("orders", "hunter2"); err != nil {
+		t.Errorf("Expected the loaded user to authenticate, received %v", err)
+	}
+
+	_, err = NewStore(File{
+		Users: []User{
+			{Name: "orders", Secret: "hunter2"},
+			{Name: "", Secret: secret},
+			{Name: "billing", Secret: HashToken("hunter2")},
+		},
+		Tokens: []Token{{Name: "prometheus", Token: secret}},
+	})
+	if !errors.Is(err, ErrInvalidHash) {
+		t.Fatalf("Expected invalid hashes to be reported, received %v", err)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 4 {
+		t.Errorf("Expected every invalid entry to be reported, found %d: %v", n, err)
+	}
+}
+
+func TestProtect(t *testing.T) {
+	store, token := testStore(t)
+	h := store.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for header, want := range map[string]int{
+		"":                http.StatusUnauthorized,
+		"Bearer wrong":    http.StatusUnauthorized,
+		"Basic " + token:  http.StatusUnauthorized,
+		"Bearer " + token: http.StatusNoContent,
+		"bearer " + token: http.StatusNoContent,
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Expected %d for Authorization %q, found %d", want, header, rec.Code)
+		}
+	}
+	if name, ok := store.Bearer(token); !ok || name != "prometheus" {
+		t.Errorf("Expected the token to belong to prometheus, found %q", name)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	secret, _ := hashSecret("hunter2", 1000)
+	store, err := NewStore(File{
+		Users: []User{
+			{Name: "orders", Secret: secret, Roles: []string{"producer"}},
+			{Name: "ops", Secret: secret},
+		},
+		ACL: []Rule{
+			{Role: "producer", Queues: "orders.*", Permissions: []Permission{PermPush, PermInspect}},
+			{User: "ops", Queues: "*", Permissions: []Permission{PermAdmin}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		user  string
+		perm  Permission
+		queue string
+		want  bool
+	}{
+		{"orders", PermPush, "orders.eu", true},
+		{"orders", PermInspect, "orders.eu", true},
+		{"orders", PermCancel, "orders.eu", false},
+		{"orders", PermPush, "billing", false},
+		{"ops", PermCancel, "billing", true},
+		{"nobody", PermInspect, "orders.eu", false},
+	} {
+		if got := store.Allowed(c.user, c.perm, c.queue); got != c.want {
+			t.Errorf("Expected Allowed(%s, %s, %s) to be %v", c.user, c.perm, c.queue, c.want)
+		}
+	}
+
+	open, _ := NewStore(File{Users: []User{{Name: "orders", Secret: secret}}})
+	if !open.Allowed("orders", PermAdmin, "billing") {
+		t.Error("Expected every permission to be granted without ACL rules")
+	}
+
+	_, err = NewStore(File{
+		Users: []User{{Name: "orders", Secret: secret}},
+		ACL: []Rule{
+			{User: "orders", Role: "producer", Queues: "*", Permissions: []Permission{PermPush}},
+			{User: "billing", Queues: "[", Permissions: []Permission{"write"}},
+		},
+	})
+	if err == nil {
+		t.Error("Expected invalid rules to be rejected")
+	}
+}
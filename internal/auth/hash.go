@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hash schemes. Secrets chosen by people are stretched with PBKDF2; bearer
+// tokens are random, so a single SHA-256 is enough and keeps every HTTP
+// request cheap to check.
+const (
+	schemePBKDF2 = "pbkdf2-sha256"
+	schemeSHA256 = "sha256"
+)
+
+// DefaultIterations is the PBKDF2 work factor HashSecret uses.
+const DefaultIterations = 600_000
+
+const (
+	saltSize  = 16
+	keySize   = 32
+	tokenSize = 32
+)
+
+// secretHash is a parsed pbkdf2-sha256$<iterations>$<salt>$<key> hash, with
+// salt and key in unpadded base64.
+type secretHash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func parseSecretHash(s string) (secretHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 || parts[0] != schemePBKDF2 {
+		return secretHash{}, fmt.Errorf("%w: expected %s$<iterations>$<salt>$<key>", ErrInvalidHash, schemePBKDF2)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return secretHash{}, fmt.Errorf("%w: invalid iterations %q", ErrInvalidHash, parts[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(salt) == 0 {
+		return secretHash{}, fmt.Errorf("%w: invalid salt", ErrInvalidHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return secretHash{}, fmt.Errorf("%w: invalid key", ErrInvalidHash)
+	}
+	return secretHash{iterations: iterations, salt: salt, key: key}, nil
+}
+
+func (h secretHash) String() string {
+	return fmt.Sprintf("%s$%d$%s$%s", schemePBKDF2, h.iterations,
+		base64.RawStdEncoding.EncodeToString(h.salt), base64.RawStdEncoding.EncodeToString(h.key))
+}
+
+func (h secretHash) matches(secret string) bool {
+	key, err := pbkdf2.Key(sha256.New, secret, h.salt, h.iterations, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// HashSecret hashes secret for the users of a credentials file.
+func HashSecret(secret string) (string, error) {
+	return hashSecret(secret, DefaultIterations)
+}
+
+func hashSecret(secret string, iterations int) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, secret, salt, iterations, keySize)
+	if err != nil {
+		return "", err
+	}
+	return secretHash{iterations: iterations, salt: salt, key: key}.String(), nil
+}
+
+func parseTokenHash(s string) ([]byte, error) {
+	scheme, digest, ok := strings.Cut(s, "$")
+	if !ok || scheme != schemeSHA256 {
+		return nil, fmt.Errorf("%w: expected %s$<hex digest>", ErrInvalidHash, schemeSHA256)
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("%w: invalid digest", ErrInvalidHash)
+	}
+	return sum, nil
+}
+
+// HashToken hashes a bearer token for the tokens of a credentials file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return schemeSHA256 + "$" + hex.EncodeToString(sum[:])
+}
+
+// NewToken generates a random bearer token.
+func NewToken() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
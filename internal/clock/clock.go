@@ -0,0 +1,42 @@
+// Package clock abstracts the passage of time so that scheduling code can be
+// driven by a fake clock in tests. See the clocktest package.
+package clock
+
+import "time"
+
+// Clock tells the time and schedules callbacks.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a callback scheduled by a Clock. Stop and Reset behave like the
+// methods of time.Timer.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the Clock backed by the time package.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Until returns the duration until t according to c.
+func Until(c Clock, t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
+
+// Since returns the time elapsed since t according to c.
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
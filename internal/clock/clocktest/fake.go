@@ -0,0 +1,130 @@
+// Package clocktest provides a clock.Clock whose time only moves when a test
+// advances it.
+package clocktest
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock"
+)
+
+// Fake is a manually advanced clock. Callbacks scheduled with AfterFunc run
+// during Advance or Set, on the calling goroutine and in order of their due
+// time, so that the effects of advancing the clock are visible as soon as it
+// returns. A callback that blocks therefore blocks the test.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	timers []*fakeTimer
+}
+
+// NewFake returns a fake clock set to start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) clock.Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, fn: fn}
+	f.schedule(t, d)
+	return t
+}
+
+// schedule queues t to fire d from now. f.mu must be held.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	f.seq++
+	t.due, t.seq = f.now.Add(d), f.seq
+	f.timers = append(f.timers, t)
+}
+
+// remove unqueues t, reporting whether it was pending. f.mu must be held.
+func (f *Fake) remove(t *fakeTimer) bool {
+	i := slices.Index(f.timers, t)
+	if i < 0 {
+		return false
+	}
+	f.timers = slices.Delete(f.timers, i, i+1)
+	return true
+}
+
+// Advance moves the clock forward by d, firing every timer that becomes due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing every timer due by then. Timers scheduled
+// by the callbacks fire too if they are due by t. Moving backwards only
+// changes the time.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.mu.Lock()
+		next := f.next(t)
+		if next == nil {
+			if t.After(f.now) {
+				f.now = t
+			}
+			f.mu.Unlock()
+			return
+		}
+		f.remove(next)
+		if next.due.After(f.now) {
+			f.now = next.due
+		}
+		f.mu.Unlock()
+
+		next.fn()
+	}
+}
+
+// next returns the earliest timer due by t, if any. f.mu must be held.
+func (f *Fake) next(t time.Time) *fakeTimer {
+	var next *fakeTimer
+	for _, timer := range f.timers {
+		if timer.due.After(t) {
+			continue
+		}
+		if next == nil || timer.due.Before(next.due) || (timer.due.Equal(next.due) && timer.seq < next.seq) {
+			next = timer
+		}
+	}
+	return next
+}
+
+// Pending returns how many timers are waiting to fire.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+type fakeTimer struct {
+	clock *Fake
+	fn    func()
+	due   time.Time
+	// seq orders timers due at the same time by when they were scheduled.
+	seq int
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	pending := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return pending
+}
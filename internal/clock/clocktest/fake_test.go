@@ -0,0 +1,44 @@
+package clocktest
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFake(start)
+
+	var fired []string
+	clk.AfterFunc(2*time.Second, func() { fired = append(fired, "2s") })
+	stopped := clk.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	clk.AfterFunc(time.Second, func() {
+		fired = append(fired, "1s")
+		clk.AfterFunc(500*time.Millisecond, func() { fired = append(fired, "1.5s") })
+	})
+	reset := clk.AfterFunc(time.Hour, func() { fired = append(fired, "reset") })
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Expected only the first Stop to report a pending timer")
+	}
+	if !reset.Reset(3 * time.Second) {
+		t.Error("Expected Reset to report a pending timer")
+	}
+
+	clk.Advance(2 * time.Second)
+	if want := []string{"1s", "1.5s", "2s"}; !slices.Equal(fired, want) {
+		t.Errorf("Expected %v to fire, found %v", want, fired)
+	}
+	if !clk.Now().Equal(start.Add(2 * time.Second)) {
+		t.Errorf("Unexpected time %v", clk.Now())
+	}
+	if clk.Pending() != 1 {
+		t.Errorf("Expected 1 pending timer, found %d", clk.Pending())
+	}
+
+	clk.Advance(time.Second)
+	if fired[len(fired)-1] != "reset" || clk.Pending() != 0 {
+		t.Errorf("Expected the reset timer to fire, found %v", fired)
+	}
+}
@@ -0,0 +1,640 @@
+// Package config loads the settings used by `timermq serve`.
+//
+// Settings are resolved in increasing order of precedence: built-in defaults,
+// the config file, TIMERMQ_* environment variables and command line flags.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters/servers"
+	"github.com/BarunKGP/timermq/internal/audit"
+	"github.com/BarunKGP/timermq/internal/auth"
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/health"
+	"github.com/BarunKGP/timermq/internal/limits"
+	"github.com/BarunKGP/timermq/internal/tlsconfig"
+	"github.com/BarunKGP/timermq/internal/tracing"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("Unsupported config format")
+	ErrUnknownSetting    = errors.New("Unknown setting")
+)
+
+type QueueConfig struct {
+	// Capacity is how many fired messages a queue buffers for its
+	// subscribers.
+	Capacity int `json:"capacity"`
+	// Admit is the capacity every queue starts with: how many messages may
+	// be pending and how many payload bytes they may add up to. RESIZE
+	// changes it per queue at runtime.
+	Admit core.Capacity `json:"admit"`
+	// Retention is how long delivered and dead-lettered messages can still
+	// be looked up, acked or replayed before their memory is reclaimed.
+	Retention Duration `json:"retention"`
+	// Dedup is how long a queue remembers the dedup key of a pushed
+	// message, during which pushes repeating the key return the id of that
+	// message instead of scheduling another. DedupWindows overrides it per
+	// queue. Zero disables deduplication.
+	Dedup        Duration            `json:"dedup"`
+	DedupWindows map[string]Duration `json:"dedupWindows"`
+	// Spread smooths the firing of messages due together in every queue,
+	// and Spreads overrides it per queue.
+	Spread  SpreadConfig            `json:"spread"`
+	Spreads map[string]SpreadConfig `json:"spreads"`
+	// Overflow is what happens when a queue fires more messages than its
+	// subscribers take: "block" or "reject" producers, "spill" the excess to
+	// SpillDir, or move the oldest to the DLQ with "drop-oldest".
+	Overflow core.OverflowPolicy `json:"overflow"`
+	SpillDir string              `json:"spillDir"`
+}
+
+// SpreadConfig smooths the firing of messages due together. Window delays
+// each message by a random amount below it, unless the message was pushed
+// with a jitter of its own, and Rate caps how many messages fire per second.
+// Zero disables either.
+type SpreadConfig struct {
+	Window Duration `json:"window"`
+	Rate   float64  `json:"rate"`
+}
+
+func (s SpreadConfig) Spread() core.Spread {
+	return core.Spread{Window: time.Duration(s.Window), Rate: s.Rate}
+}
+
+func (s SpreadConfig) validate(name string) error {
+	if s.Window < 0 || s.Rate < 0 {
+		return fmt.Errorf("%s must not be negative, found window %s and rate %g", name, time.Duration(s.Window), s.Rate)
+	}
+	return nil
+}
+
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+	// Output is "stderr", "stdout" or a file path.
+	Output string `json:"output"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in config
+// files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type ShutdownConfig struct {
+	// Timeout bounds the whole shutdown, after which connections are
+	// closed and whatever is left is snapshotted.
+	Timeout Duration `json:"timeout"`
+	// Drain makes shutdown wait for messages due within this window to be
+	// delivered before closing subscribers.
+	Drain Duration `json:"drain"`
+}
+
+type PersistenceConfig struct {
+	// Snapshot is the file durable messages are saved to on shutdown and
+	// restored from on startup. Empty disables snapshots.
+	Snapshot string `json:"snapshot"`
+}
+
+type MetricsConfig struct {
+	// Addr is the host:port of the HTTP listener serving Prometheus metrics
+	// under /metrics, along with the health checks. Empty disables it.
+	Addr string `json:"addr"`
+}
+
+type HealthConfig struct {
+	// Addr is the host:port of the HTTP listener serving health checks under
+	// /healthz and /readyz. It may be the same as Metrics.Addr.
+	Addr string `json:"addr"`
+	// MaxLag is how long a timer may be overdue before the broker reports
+	// itself not ready.
+	MaxLag Duration `json:"maxLag"`
+}
+
+type AuditConfig struct {
+	// Output is where state changes of loggable messages are recorded:
+	// "stdout", a file path or a syslog URL such as syslog+udp://host:514.
+	// Empty disables the audit log.
+	Output string `json:"output"`
+	// MaxSize is the size in bytes an audit file reaches before it is
+	// rotated, and MaxBackups how many rotated files are kept.
+	MaxSize    int64 `json:"maxSize"`
+	MaxBackups int   `json:"maxBackups"`
+}
+
+type TracingConfig struct {
+	// Exporter is where spans of messages published with a trace context
+	// go: "stdout" or "otlp-file". Empty disables tracing, although trace
+	// context is still passed on to subscribers.
+	Exporter string `json:"exporter"`
+	// File is the file the otlp-file exporter appends to.
+	File string `json:"file"`
+}
+
+type AuthConfig struct {
+	// Credentials is the file of users allowed to AUTH and of bearer tokens
+	// accepted by the HTTP listener. Empty disables authentication.
+	Credentials string `json:"credentials"`
+}
+
+// TLSConfig secures every listener, TCP and HTTP alike. Certificates are
+// reloaded when their files change.
+type TLSConfig struct {
+	Cert     string `json:"cert"`
+	Key      string `json:"key"`
+	ClientCA string `json:"clientCA"`
+	// ClientAuth is "none", "request" or "require". Clients with a
+	// verified certificate are identified by its common name.
+	ClientAuth   string   `json:"clientAuth"`
+	MinVersion   string   `json:"minVersion"`
+	CipherSuites []string `json:"cipherSuites"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.Cert != "" || t.Key != "" || t.ClientCA != "" || t.ClientAuth != "" || len(t.CipherSuites) > 0
+}
+
+func (t TLSConfig) options() tlsconfig.Options {
+	return tlsconfig.Options{
+		Cert:         t.Cert,
+		Key:          t.Key,
+		ClientCA:     t.ClientCA,
+		ClientAuth:   t.ClientAuth,
+		MinVersion:   t.MinVersion,
+		CipherSuites: t.CipherSuites,
+	}
+}
+
+type Config struct {
+	Listeners   []servers.InitOpts `json:"listeners"`
+	Queue       QueueConfig        `json:"queue"`
+	Log         LogConfig          `json:"log"`
+	Shutdown    ShutdownConfig     `json:"shutdown"`
+	Persistence PersistenceConfig  `json:"persistence"`
+	Metrics     MetricsConfig      `json:"metrics"`
+	Audit       AuditConfig        `json:"audit"`
+	Tracing     TracingConfig      `json:"tracing"`
+	Health      HealthConfig       `json:"health"`
+	Auth        AuthConfig         `json:"auth"`
+	TLS         TLSConfig          `json:"tls"`
+	// Limits throttle pushes and deliveries per client and per queue, and
+	// cap what each queue holds.
+	Limits limits.Limits `json:"limits"`
+}
+
+func Default() Config {
+	return Config{
+		Listeners: []servers.InitOpts{
+			{Addr: "localhost", Port: 8080, Protocol: servers.TCP},
+		},
+		Queue:    QueueConfig{Capacity: 1024, Overflow: core.OverflowBlock, Retention: Duration(time.Hour), Dedup: Duration(10 * time.Minute)},
+		Log:      LogConfig{Level: "info", Format: "text", Output: "stderr"},
+		Shutdown: ShutdownConfig{Timeout: Duration(30 * time.Second)},
+		Audit:    AuditConfig{MaxSize: 100 << 20, MaxBackups: 5},
+		Health:   HealthConfig{Addr: "localhost:8081", MaxLag: Duration(health.DefaultMaxLag)},
+	}
+}
+
+// Load reads the config file at path on top of the defaults. Only JSON files
+// are understood; YAML and TOML would need parsers this module does not
+// vendor.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	default:
+		return cfg, fmt.Errorf("%w %q: only .json config files are supported", ErrUnsupportedFormat, filepath.Ext(path))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.decode(data); err != nil {
+		return cfg, fmt.Errorf("Unable to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) decode(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(c)
+}
+
+// Settings overridable from the environment and the command line. The
+// environment variable for a setting is TIMERMQ_ followed by the upper-cased
+// name with dashes replaced by underscores, e.g. TIMERMQ_LOG_LEVEL.
+var Settings = map[string]string{
+	"tcp":            "host:port of the TCP listener",
+	"capacity":       "capacity of each queue",
+	"overflow":       "when subscribers fall behind: block, reject, spill or drop-oldest",
+	"spill-dir":      "directory queues spill overflowing messages to",
+	"admit-messages": "pending messages each queue admits, 0 for no bound",
+	"admit-bytes":    "pending payload bytes each queue admits, 0 for no bound",
+	"retention":      "how long delivered and dead-lettered messages are kept before being reclaimed",
+	"dedup-window":   "how long each queue remembers dedup keys, 0 to disable deduplication",
+	"spread-window":  "bound of the random delay added to each message, to spread messages due together",
+	"spread-rate":    "messages each queue fires per second at most, 0 for no bound",
+	"log-level":      "log level: debug, info, warn or error",
+	"log-format":     "log format: text or json",
+	"log-output":     "log destination: stderr, stdout or a file path",
+
+	"shutdown-timeout": "how long shutdown may take before connections are cut",
+	"drain":            "on shutdown, deliver messages due within this window first",
+	"snapshot":         "file durable messages are saved to on shutdown and restored from",
+	"metrics":          "host:port serving Prometheus metrics on /metrics and health checks on /healthz and /readyz",
+	"health":           "host:port serving health checks on /healthz and /readyz",
+	"audit":            "audit log of loggable messages: stdout, a file path or a syslog+udp|tcp|unix URL",
+	"trace-exporter":   "exporter of message spans: stdout or otlp-file",
+	"trace-file":       "file the otlp-file trace exporter appends to",
+	"max-lag":          "how long a timer may be overdue before the broker reports itself not ready",
+	"credentials":      "file of users and bearer tokens clients must authenticate with",
+	"tls-cert":         "PEM certificate every listener serves TLS with",
+	"tls-key":          "PEM key of the TLS certificate",
+	"tls-client-ca":    "PEM bundle client certificates are verified against",
+	"tls-client-auth":  "client certificates: none, request or require",
+	"tls-min-version":  "minimum TLS version: 1.2 or 1.3",
+	"push-rate":        "pushes per second allowed to each client",
+	"consume-rate":     "deliveries per second made to each client",
+}
+
+func EnvName(setting string) string {
+	return "TIMERMQ_" + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// ApplyEnv overrides every setting whose environment variable is set.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	for setting := range Settings {
+		if value, ok := lookup(EnvName(setting)); ok {
+			if err := c.Set(setting, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", EnvName(setting), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Set overrides a single setting by name.
+func (c *Config) Set(setting, value string) error {
+	switch setting {
+	case "tcp":
+		return c.setTCP(value)
+	case "capacity":
+		capacity, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid capacity %q", value)
+		}
+		c.Queue.Capacity = capacity
+	case "overflow":
+		c.Queue.Overflow = core.OverflowPolicy(value)
+	case "spill-dir":
+		c.Queue.SpillDir = value
+	case "admit-messages":
+		return setInt(&c.Queue.Admit.Messages, "admit-messages", value)
+	case "admit-bytes":
+		return setInt(&c.Queue.Admit.Bytes, "admit-bytes", value)
+	case "retention":
+		return c.Queue.Retention.UnmarshalText([]byte(value))
+	case "dedup-window":
+		return c.Queue.Dedup.UnmarshalText([]byte(value))
+	case "spread-window":
+		return c.Queue.Spread.Window.UnmarshalText([]byte(value))
+	case "spread-rate":
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid spread-rate %q", value)
+		}
+		c.Queue.Spread.Rate = rate
+	case "log-level":
+		c.Log.Level = value
+	case "log-format":
+		c.Log.Format = value
+	case "log-output":
+		c.Log.Output = value
+	case "shutdown-timeout":
+		return c.Shutdown.Timeout.UnmarshalText([]byte(value))
+	case "drain":
+		return c.Shutdown.Drain.UnmarshalText([]byte(value))
+	case "snapshot":
+		c.Persistence.Snapshot = value
+	case "metrics":
+		c.Metrics.Addr = value
+	case "health":
+		c.Health.Addr = value
+	case "audit":
+		c.Audit.Output = value
+	case "trace-exporter":
+		c.Tracing.Exporter = value
+	case "trace-file":
+		c.Tracing.File = value
+	case "max-lag":
+		return c.Health.MaxLag.UnmarshalText([]byte(value))
+	case "credentials":
+		c.Auth.Credentials = value
+	case "tls-cert":
+		c.TLS.Cert = value
+	case "tls-key":
+		c.TLS.Key = value
+	case "tls-client-ca":
+		c.TLS.ClientCA = value
+	case "tls-client-auth":
+		c.TLS.ClientAuth = value
+	case "tls-min-version":
+		c.TLS.MinVersion = value
+	case "push-rate":
+		return setRate(&c.Limits.ClientPush, value)
+	case "consume-rate":
+		return setRate(&c.Limits.ClientConsume, value)
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownSetting, setting)
+	}
+	return nil
+}
+
+func setRate(r *limits.Rate, value string) error {
+	perSecond, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid rate %q", value)
+	}
+	r.PerSecond = perSecond
+	return nil
+}
+
+func setInt(dst *int, setting, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("Invalid %s %q", setting, value)
+	}
+	*dst = n
+	return nil
+}
+
+// setTCP points the first TCP listener at hostport, adding one if needed.
+func (c *Config) setTCP(hostport string) error {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("Invalid port %q", portStr)
+	}
+
+	for i, l := range c.Listeners {
+		if l.Protocol == servers.TCP {
+			c.Listeners[i].Addr, c.Listeners[i].Port = host, uint16(port)
+			return nil
+		}
+	}
+	c.Listeners = append(c.Listeners, servers.InitOpts{Addr: host, Port: uint16(port), Protocol: servers.TCP})
+	return nil
+}
+
+// Validate reports every problem with the config at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Listeners) == 0 {
+		errs = append(errs, errors.New("At least one listener is required"))
+	}
+	seen := map[string]bool{}
+	for i, l := range c.Listeners {
+		if l.Port == 0 {
+			errs = append(errs, fmt.Errorf("listeners[%d]: port is required", i))
+		}
+		addr := net.JoinHostPort(l.Addr, strconv.Itoa(int(l.Port)))
+		if seen[addr] {
+			errs = append(errs, fmt.Errorf("listeners[%d]: %s is used by more than one listener", i, addr))
+		}
+		seen[addr] = true
+	}
+
+	if c.Queue.Capacity <= 0 {
+		errs = append(errs, fmt.Errorf("queue.capacity must be positive, found %d", c.Queue.Capacity))
+	}
+	if c.Queue.Admit.Messages < 0 || c.Queue.Admit.Bytes < 0 {
+		errs = append(errs, fmt.Errorf("queue.admit must not be negative, found %d messages and %d bytes", c.Queue.Admit.Messages, c.Queue.Admit.Bytes))
+	}
+	if c.Queue.Retention < 0 {
+		errs = append(errs, fmt.Errorf("queue.retention must not be negative, found %s", time.Duration(c.Queue.Retention)))
+	}
+	if c.Queue.Dedup < 0 {
+		errs = append(errs, fmt.Errorf("queue.dedup must not be negative, found %s", time.Duration(c.Queue.Dedup)))
+	}
+	for queue, window := range c.Queue.DedupWindows {
+		if !core.ValidQueueName(queue) {
+			errs = append(errs, fmt.Errorf("queue.dedupWindows: invalid queue name %q", queue))
+		} else if window < 0 {
+			errs = append(errs, fmt.Errorf("queue.dedupWindows.%s must not be negative, found %s", queue, time.Duration(window)))
+		}
+	}
+	if err := c.Queue.Spread.validate("queue.spread"); err != nil {
+		errs = append(errs, err)
+	}
+	for queue, spread := range c.Queue.Spreads {
+		if !core.ValidQueueName(queue) {
+			errs = append(errs, fmt.Errorf("queue.spreads: invalid queue name %q", queue))
+		} else if err := spread.validate("queue.spreads." + queue); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !c.Queue.Overflow.Valid() {
+		errs = append(errs, fmt.Errorf("queue.overflow must be block, reject, spill or drop-oldest, found %q", c.Queue.Overflow))
+	} else if c.Queue.Overflow == core.OverflowSpill && c.Queue.SpillDir == "" {
+		errs = append(errs, errors.New("queue.spillDir is required by the spill overflow policy"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format must be text or json, found %q", c.Log.Format))
+	}
+	if c.Log.Output == "" {
+		errs = append(errs, errors.New("log.output is required"))
+	}
+
+	if c.Metrics.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.addr: %w", err))
+		} else if seen[c.Metrics.Addr] {
+			errs = append(errs, fmt.Errorf("metrics.addr: %s is used by a listener", c.Metrics.Addr))
+		}
+	}
+
+	if c.Shutdown.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown.timeout must be positive, found %s", time.Duration(c.Shutdown.Timeout)))
+	}
+	if c.Shutdown.Drain < 0 {
+		errs = append(errs, fmt.Errorf("shutdown.drain must not be negative, found %s", time.Duration(c.Shutdown.Drain)))
+	}
+
+	if strings.HasPrefix(c.Audit.Output, "syslog+") {
+		if _, _, err := audit.ParseSyslogURL(c.Audit.Output); err != nil {
+			errs = append(errs, fmt.Errorf("audit.output: %w", err))
+		}
+	}
+	if c.Audit.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("audit.maxSize must be positive, found %d", c.Audit.MaxSize))
+	}
+	if c.Audit.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("audit.maxBackups must not be negative, found %d", c.Audit.MaxBackups))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Health.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("health.addr: %w", err))
+	} else if seen[c.Health.Addr] {
+		errs = append(errs, fmt.Errorf("health.addr: %s is used by a listener", c.Health.Addr))
+	}
+	if c.Health.MaxLag <= 0 {
+		errs = append(errs, fmt.Errorf("health.maxLag must be positive, found %s", time.Duration(c.Health.MaxLag)))
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("limits: %w", err))
+	}
+
+	if c.TLS.enabled() {
+		if err := c.TLS.options().Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "stdout":
+	case "otlp-file":
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing.file is required by the otlp-file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be stdout or otlp-file, found %q", c.Tracing.Exporter))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Certificates loads the TLS certificates described by c.TLS, or returns nil
+// if TLS is disabled.
+func (c *Config) Certificates() (*tlsconfig.Reloader, error) {
+	if !c.TLS.enabled() {
+		return nil, nil
+	}
+	return tlsconfig.Load(c.TLS.options())
+}
+
+// Limiter builds the limiter described by c.Limits, or returns nil if no
+// limit is set.
+func (c *Config) Limiter() *limits.Limiter {
+	if !c.Limits.Enabled() {
+		return nil
+	}
+	return limits.NewLimiter(c.Limits)
+}
+
+// Authenticator loads the credentials named by c.Auth, or returns nil if
+// authentication is disabled.
+func (c *Config) Authenticator() (*auth.Store, error) {
+	if c.Auth.Credentials == "" {
+		return nil, nil
+	}
+	return auth.Load(c.Auth.Credentials)
+}
+
+// Checker builds the readiness checker described by the config.
+func (c *Config) Checker(broker *core.Broker) *health.Checker {
+	return health.NewChecker(broker).
+		WithMaxLag(time.Duration(c.Health.MaxLag)).
+		WithSnapshot(c.Persistence.Snapshot)
+}
+
+// Tracer builds the tracer described by c.Tracing, or returns nil if tracing
+// is disabled.
+func (c *Config) Tracer() (*tracing.Tracer, error) {
+	if c.Tracing.Exporter == "" {
+		return nil, nil
+	}
+	exporter, err := tracing.Open(tracing.Options{Exporter: c.Tracing.Exporter, File: c.Tracing.File})
+	if err != nil {
+		return nil, err
+	}
+	return tracing.NewTracer(exporter), nil
+}
+
+// Auditor builds the audit logger described by c.Audit, or returns nil if
+// auditing is disabled.
+func (c *Config) Auditor() (*audit.Logger, error) {
+	if c.Audit.Output == "" {
+		return nil, nil
+	}
+	sink, err := audit.Open(audit.Options{
+		Output:     c.Audit.Output,
+		MaxSize:    c.Audit.MaxSize,
+		MaxBackups: c.Audit.MaxBackups,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewLogger(sink), nil
+}
+
+// Logger builds the logger described by c.Log. The returned closer releases
+// the log file, if one was opened.
+func (c *Config) Logger() (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return nil, nil, err
+	}
+
+	var w io.WriteCloser
+	switch c.Log.Output {
+	case "stderr":
+		w = nopCloser{os.Stderr}
+	case "stdout":
+		w = nopCloser{os.Stdout}
+	default:
+		f, err := os.OpenFile(c.Log.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if c.Log.Format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts)), w, nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), w, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
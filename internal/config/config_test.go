@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters/servers"
+)
+
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, "timermq.json", `{
+		"listeners": [{"addr": "0.0.0.0", "port": 9000, "protocol": "tcp"}],
+		"queue": {"capacity": 16},
+		"shutdown": {"drain": "5s"}
+	}`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Listeners) != 1 || cfg.Listeners[0].Port != 9000 || cfg.Listeners[0].Protocol != servers.TCP {
+		t.Errorf("Unexpected listeners %+v", cfg.Listeners)
+	}
+	if cfg.Queue.Capacity != 16 {
+		t.Errorf("Expected capacity 16, found %d", cfg.Queue.Capacity)
+	}
+	if cfg.Shutdown.Drain != Duration(5*time.Second) || cfg.Shutdown.Timeout != Duration(30*time.Second) {
+		t.Errorf("Unexpected shutdown config %+v", cfg.Shutdown)
+	}
+	if cfg.Log.Level != "info" {
+		t.Errorf("Expected default log level to be kept, found %q", cfg.Log.Level)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected validation error: %v", err)
+	}
+
+	for _, name := range []string{"timermq.yaml", "timermq.toml", "timermq.ini"} {
+		if _, err := Load(writeConfig(t, name, "[queue]")); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat for %s, received %v", name, err)
+		}
+	}
+	if _, err := Load(writeConfig(t, "typo.json", `{"queues": {}}`)); err == nil {
+		t.Error("Expected unknown fields to be rejected")
+	}
+	if _, err := Load(writeConfig(t, "amqp.json", `{"listeners": [{"port": 5672, "protocol": "amqp"}]}`)); err == nil {
+		t.Error("Expected unknown listener protocols to be rejected")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	cfg := Default()
+	env := map[string]string{
+		"TIMERMQ_CAPACITY":  "32",
+		"TIMERMQ_LOG_LEVEL": "debug",
+	}
+	err := cfg.ApplyEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("tcp", "127.0.0.1:9001"); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Queue.Capacity != 32 || cfg.Log.Level != "debug" {
+		t.Errorf("Environment overrides not applied: %+v", cfg)
+	}
+	if l := cfg.Listeners[0]; l.Addr != "127.0.0.1" || l.Port != 9001 {
+		t.Errorf("TCP override not applied: %+v", l)
+	}
+	if err := cfg.Set("shutdown-timeout", "1m"); err != nil || cfg.Shutdown.Timeout != Duration(time.Minute) {
+		t.Errorf("Shutdown timeout override not applied: %v, %+v", err, cfg.Shutdown)
+	}
+	if err := cfg.Set("drain", "soon"); err == nil {
+		t.Error("Expected an invalid drain window to be rejected")
+	}
+	if err := cfg.Set("colour", "blue"); !errors.Is(err, ErrUnknownSetting) {
+		t.Errorf("Expected ErrUnknownSetting, received %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Listeners = append(cfg.Listeners,
+		servers.InitOpts{Addr: "localhost", Port: 8080, Protocol: servers.TCP},
+		servers.InitOpts{Addr: "localhost", Port: 0, Protocol: servers.TCP},
+	)
+	cfg.Queue.Capacity = -1
+	cfg.Queue.Admit.Bytes = -1
+	cfg.Log.Format = "xml"
+	cfg.Metrics.Addr = "localhost:8080"
+	cfg.Health.Addr = "8081"
+	cfg.Audit.Output = "syslog+udp://"
+	cfg.Tracing.Exporter = "jaeger"
+	cfg.TLS.ClientAuth = "require"
+	cfg.Limits.ClientPush.PerSecond = -1
+	cfg.Queue.Overflow = "discard"
+	cfg.Queue.DedupWindows = map[string]Duration{"emails": Duration(-time.Minute)}
+	cfg.Queue.Spreads = map[string]SpreadConfig{"reports": {Rate: -1}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	// duplicate address, missing port, capacity, admit, dedup window,
+	// spread, overflow, format, metrics address, audit output, health
+	// address, trace exporter, tls, limits
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 14 {
+		t.Errorf("Expected 14 validation errors, found %d: %v", n, err)
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+// fairEvery is how often the backlog hands out the message that waited
+// longest instead of the one with the highest priority.
+const fairEvery = 8
+
+// backlog holds the fired messages waiting for room on the delivery channel,
+// in a FIFO per priority. Higher priorities are taken first, except that
+// every fairEvery-th take is the message that waited longest, so that a
+// steady stream of high priority messages cannot starve the others.
+type backlog struct {
+	levels [MaxPriority + 1][]backlogEntry
+	n      int
+	seq    uint64
+	taken  int
+}
+
+type backlogEntry struct {
+	d   Delivery
+	seq uint64
+}
+
+func (b *backlog) len() int {
+	return b.n
+}
+
+func (b *backlog) push(d Delivery) {
+	b.seq++
+	level := &b.levels[clampPriority(d.Priority)]
+	*level = append(*level, backlogEntry{d: d, seq: b.seq})
+	b.n++
+}
+
+func (b *backlog) pop() (Delivery, bool) {
+	if b.n == 0 {
+		return Delivery{}, false
+	}
+	b.taken++
+	next := -1
+	for p := MaxPriority; p >= 0; p-- {
+		if len(b.levels[p]) == 0 {
+			continue
+		}
+		if next < 0 {
+			next = p
+			if b.taken%fairEvery != 0 {
+				break
+			}
+		} else if b.levels[p][0].seq < b.levels[next][0].seq {
+			next = p
+		}
+	}
+	e := b.levels[next][0]
+	if b.levels[next] = b.levels[next][1:]; len(b.levels[next]) == 0 {
+		b.levels[next] = nil
+	}
+	b.n--
+	return e.d, true
+}
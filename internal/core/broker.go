@@ -0,0 +1,424 @@
+package core
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock"
+)
+
+const DefaultQueue = "default"
+
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ValidQueueName reports whether name can be used as a queue name.
+func ValidQueueName(name string) bool {
+	return queueNamePattern.MatchString(name)
+}
+
+// Broker owns a set of named queues, each backed by its own TimerMQ. Queues
+// are created on first use with the broker's default capacity. The broker
+// also keeps the queue of every message, so that it can be found by id.
+type Broker struct {
+	capacity  int
+	limit     Capacity
+	reclaim   bool
+	retention time.Duration
+	overflow  OverflowPolicy
+	spillDir  string
+	spread    Spread
+	spreads   map[string]Spread
+	clk       clock.Clock
+	queues    map[string]*TimerMQ
+	located   map[MessageId]string
+	durable   map[MessageId]bool
+	loggable  map[MessageId]bool
+	traces    map[MessageId]TraceContext
+	dedup     dedupIndex
+	metrics   *brokerMetrics
+	observers []*brokerObserver
+	mu        sync.Mutex
+
+	startedAt time.Time
+	listeners []string
+}
+
+// PublishOpts modify how a message is scheduled.
+type PublishOpts struct {
+	Delay time.Duration
+	// Priority orders the message among those due at the same time, from 0
+	// to MaxPriority.
+	Priority int
+	// Group, if set, holds the message back until the messages published to
+	// the same group of the queue and due before it are acked.
+	Group string
+	// NotBefore and NotAfter bound the delivery window of the message. It
+	// fires no earlier than NotBefore, even if Delay is shorter, and is moved
+	// to the DLQ if it cannot be handed to a consumer by NotAfter. Either is
+	// ignored when zero.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Jitter delays the message by a random amount below it. It defaults to
+	// the spread window of the queue.
+	Jitter time.Duration
+	// Durable messages are kept in snapshots taken on shutdown.
+	Durable bool
+	// Loggable messages have their state changes written to the audit log.
+	Loggable bool
+	// Trace is the trace context the message was published with, if any.
+	Trace TraceContext
+	// Dedup is the deduplication key of the message. Publishing another
+	// message with the same key to the same queue within its deduplication
+	// window schedules nothing.
+	Dedup string
+}
+
+func (opts PublishOpts) message(now time.Time) MessageOpts {
+	delay := opts.Delay
+	if !opts.NotBefore.IsZero() {
+		delay = max(delay, opts.NotBefore.Sub(now))
+	}
+	return MessageOpts{Delay: delay, Priority: opts.Priority, Group: opts.Group, Jitter: opts.Jitter, Deadline: opts.NotAfter}
+}
+
+// TraceContext carries W3C trace context headers from the producer of a
+// message to the spans recorded for it and on to its consumer.
+type TraceContext struct {
+	// Producer is the traceparent the message was published with.
+	Producer string `json:"producer"`
+	// Publish is the traceparent of the span that recorded the publish,
+	// which the later spans of the message descend from. It is empty when
+	// no spans are recorded.
+	Publish string `json:"publish,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+// Parent returns the traceparent spans following the publish descend from.
+func (tc TraceContext) Parent() string {
+	if tc.Publish != "" {
+		return tc.Publish
+	}
+	return tc.Producer
+}
+
+func NewBroker(capacity int) *Broker {
+	return &Broker{
+		capacity: capacity,
+		overflow: OverflowBlock,
+		clk:      clock.Real,
+		queues:   map[string]*TimerMQ{},
+		spreads:  map[string]Spread{},
+		located:  map[MessageId]string{},
+		durable:  map[MessageId]bool{},
+		loggable: map[MessageId]bool{},
+		traces:   map[MessageId]TraceContext{},
+		dedup:    newDedupIndex(),
+
+		startedAt: time.Now(),
+	}
+}
+
+// WithClock makes every queue of the broker schedule its timers on clk. It
+// must be called before any queue is created.
+func (b *Broker) WithClock(clk clock.Clock) *Broker {
+	b.clk = clk
+	b.startedAt = clk.Now()
+	return b
+}
+
+// WithOverflow sets the overflow policy of every queue. Under OverflowSpill,
+// each queue spills to <spillDir>/<queue>.spill. It must be called before
+// any queue is created.
+func (b *Broker) WithOverflow(p OverflowPolicy, spillDir string) *Broker {
+	b.overflow, b.spillDir = p, spillDir
+	return b
+}
+
+// WithCapacity sets the capacity new queues start with, which SetCapacity
+// can change per queue afterwards.
+func (b *Broker) WithCapacity(c Capacity) *Broker {
+	b.limit = c
+	return b
+}
+
+// WithRetention makes every queue reclaim the messages delivered or moved to
+// the DLQ more than retention ago, along with their id. Queues are compacted
+// as messages are published to them, and by Compact and CompactEvery. It must
+// be called before any queue is created.
+func (b *Broker) WithRetention(retention time.Duration) *Broker {
+	b.reclaim, b.retention = true, retention
+	return b
+}
+
+// WithDedupWindow sets how long every queue remembers the deduplication key
+// of a message after it is published, which SetDedupWindow can change per
+// queue. Without it, deduplication keys are ignored.
+func (b *Broker) WithDedupWindow(window time.Duration) *Broker {
+	b.dedup.window = window
+	return b
+}
+
+// WithSpread sets how new queues smooth the firing of messages due together,
+// which SetSpread can change per queue afterwards.
+func (b *Broker) WithSpread(s Spread) *Broker {
+	b.spread = s
+	return b
+}
+
+// SetCapacity changes the capacity of queue, creating it if needed.
+func (b *Broker) SetCapacity(queue string, c Capacity) {
+	b.Queue(queue).SetCapacity(c)
+}
+
+// SetSpread changes how queue smooths the firing of messages due together.
+// A queue that does not exist yet gets s when it is created.
+func (b *Broker) SetSpread(queue string, s Spread) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spreads[queue] = s
+	if tmq, exists := b.queues[queue]; exists {
+		tmq.SetSpread(s)
+	}
+}
+
+// SetDedupWindow changes the deduplication window of queue. Keys remembered
+// already keep the window they were published under.
+func (b *Broker) SetDedupWindow(queue string, window time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dedup.windows[queue] = window
+}
+
+// DedupWindow returns the deduplication window of queue.
+func (b *Broker) DedupWindow(queue string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dedup.windowOf(queue)
+}
+
+// StartedAt returns when the broker was created.
+func (b *Broker) StartedAt() time.Time {
+	return b.startedAt
+}
+
+// Uptime returns how long ago the broker was created.
+func (b *Broker) Uptime() time.Duration {
+	return clock.Since(b.clk, b.startedAt)
+}
+
+// AddListener records a listener serving the broker, described as
+// protocol://address, until the returned function is called.
+func (b *Broker) AddListener(listener string) (remove func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if i := slices.Index(b.listeners, listener); i >= 0 {
+			b.listeners = slices.Delete(b.listeners, i, i+1)
+		}
+	}
+}
+
+// Listeners returns the listeners serving the broker, sorted.
+func (b *Broker) Listeners() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Sorted(slices.Values(b.listeners))
+}
+
+// Publish schedules data on queue under id, obtained from NewMessageId, and
+// returns id. If a message was published to queue with the same opts.Dedup
+// within its deduplication window, nothing is scheduled and the id of that
+// message is returned instead. It fails with ErrQueueFull if the queue is at
+// its capacity.
+func (b *Broker) Publish(id MessageId, queue string, data []byte, opts PublishOpts) (MessageId, error) {
+	tmq := b.Queue(queue)
+
+	// Hold the lock across PublishAs so the message is located before it
+	// can fire and be looked up by a subscriber.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clk.Now()
+	if original, ok := b.dedup.lookup(queue, opts.Dedup, now); ok {
+		if b.metrics != nil {
+			b.metrics.deduplicated.With(queue).Inc()
+		}
+		return original, nil
+	}
+	if err := tmq.PublishAs(id, data, opts.message(now)); err != nil {
+		return id, err
+	}
+	b.record(id, queue, opts)
+	b.dedup.add(queue, opts.Dedup, id, opts.Durable, now)
+	b.compact(tmq)
+	return id, nil
+}
+
+// Compact reclaims the messages of every queue whose retention has passed,
+// which Publish otherwise only does for the queue it publishes to.
+func (b *Broker) Compact() {
+	for _, name := range b.Queues() {
+		tmq, _ := b.Lookup(name)
+		b.mu.Lock()
+		b.compact(tmq)
+		b.mu.Unlock()
+	}
+}
+
+// CompactEvery calls Compact every interval, so that queues nobody publishes
+// to are reclaimed too, until the returned function is called.
+func (b *Broker) CompactEvery(interval time.Duration) func() {
+	var mu sync.Mutex
+	var timer clock.Timer
+	stopped := false
+	mu.Lock()
+	defer mu.Unlock()
+	timer = b.clk.AfterFunc(interval, func() {
+		b.Compact()
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			timer.Reset(interval)
+		}
+	})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		timer.Stop()
+	}
+}
+
+// compact reclaims the messages of tmq whose retention has passed and
+// forgets them. b.mu must be held.
+func (b *Broker) compact(tmq *TimerMQ) {
+	for _, id := range tmq.Compact() {
+		delete(b.located, id)
+		delete(b.durable, id)
+		delete(b.loggable, id)
+		delete(b.traces, id)
+	}
+}
+
+// restore schedules data like Publish, regardless of the capacity of queue
+// and without deduplicating it or jittering it again.
+func (b *Broker) restore(id MessageId, queue string, data []byte, opts PublishOpts) {
+	tmq := b.Queue(queue)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tmq.Restore(id, data, opts.message(b.clk.Now()))
+	b.record(id, queue, opts)
+}
+
+// record locates id in queue. b.mu must be held.
+func (b *Broker) record(id MessageId, queue string, opts PublishOpts) {
+	b.located[id] = queue
+	if opts.Durable {
+		b.durable[id] = true
+	}
+	if opts.Loggable {
+		b.loggable[id] = true
+	}
+	if opts.Trace.Producer != "" {
+		b.traces[id] = opts.Trace
+	}
+}
+
+// Trace returns the trace context id was published with, and false if it
+// was published without one.
+func (b *Broker) Trace(id MessageId) (TraceContext, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tc, ok := b.traces[id]
+	return tc, ok
+}
+
+// Loggable reports whether id was published as loggable.
+func (b *Broker) Loggable(id MessageId) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loggable[id]
+}
+
+// Resolve finds the queue of the message published under id.
+func (b *Broker) Resolve(id MessageId) (*TimerMQ, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, exists := b.located[id]
+	if !exists {
+		return nil, false
+	}
+	return b.queues[queue], true
+}
+
+// Queue returns the queue called name, creating it if needed.
+func (b *Broker) Queue(name string) *TimerMQ {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tmq, exists := b.queues[name]
+	if !exists {
+		spread, ok := b.spreads[name]
+		if !ok {
+			spread = b.spread
+		}
+		tmq = NewTimerMQ(b.capacity).WithName(name).WithClock(b.clk).WithMetrics(b.queueMetrics(name)).WithOverflow(b.overflow).WithCapacity(b.limit).WithSpread(spread)
+		if b.reclaim {
+			tmq.WithRetention(b.retention)
+		}
+		if b.overflow == OverflowSpill && b.spillDir != "" {
+			tmq.WithSpillFile(filepath.Join(b.spillDir, name+".spill"))
+		}
+		for _, bo := range b.observers {
+			bo.stops = append(bo.stops, tmq.Observe(bo.fn))
+		}
+		b.queues[name] = tmq
+	}
+	return tmq
+}
+
+// Lookup returns the queue called name without creating it.
+func (b *Broker) Lookup(name string) (*TimerMQ, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tmq, exists := b.queues[name]
+	return tmq, exists
+}
+
+// Queues returns the names of all queues in alphabetical order.
+func (b *Broker) Queues() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.queues))
+	for name := range b.queues {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (b *Broker) Close() {
+	for _, name := range b.Queues() {
+		tmq, _ := b.Lookup(name)
+		tmq.Close()
+	}
+}
+
+// Drain waits for the messages due within the given window to be delivered
+// on every queue, or until ctx is done.
+func (b *Broker) Drain(ctx context.Context, within time.Duration) error {
+	for _, name := range b.Queues() {
+		tmq, _ := b.Lookup(name)
+		if err := tmq.Drain(ctx, within); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package core
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock/clocktest"
+	"github.com/BarunKGP/timermq/internal/metrics"
+)
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(4)
+	defer broker.Close()
+
+	id := NewMessageId()
+	broker.Publish(id, "emails", []byte("welcome"), PublishOpts{Delay: time.Hour})
+	broker.Publish(NewMessageId(), DefaultQueue, []byte("ping"), PublishOpts{Delay: time.Hour})
+
+	if queues := broker.Queues(); !slices.Equal(queues, []string{DefaultQueue, "emails"}) {
+		t.Errorf("Unexpected queues %v", queues)
+	}
+
+	tmq, ok := broker.Resolve(id)
+	if !ok || tmq.Name() != "emails" {
+		t.Fatalf("Unable to resolve %s", id)
+	}
+
+	if err := tmq.CancelSend(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmq.Replay(id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if state := tmq.State(id); state != StatePending {
+		t.Errorf("Expected replayed message to be pending, found %s", state)
+	}
+	if _, ok := broker.Resolve(NewMessageId()); ok {
+		t.Error("Resolved an unknown id")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := NewBroker(4).WithClock(clk)
+	durable := NewMessageId()
+	broker.Publish(durable, "emails", []byte("welcome"), PublishOpts{
+		Delay:    time.Hour,
+		Priority: 3,
+		Group:    "signup",
+		Durable:  true,
+		Loggable: true,
+		Trace:    TraceContext{Producer: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	})
+	broker.Publish(NewMessageId(), "emails", []byte("transient"), PublishOpts{Delay: time.Hour})
+
+	snap := broker.Snapshot()
+	broker.Close()
+	if len(snap.Messages) != 1 {
+		t.Fatalf("Expected 1 durable message, found %+v", snap.Messages)
+	}
+	if m := snap.Messages[0]; m.Id != durable || m.Queue != "emails" || string(m.Data) != "welcome" {
+		t.Errorf("Unexpected snapshot message %+v", m)
+	}
+
+	clk.Advance(time.Minute)
+	restored := NewBroker(4).WithClock(clk)
+	defer restored.Close()
+	restored.Restore(snap)
+	tmq, ok := restored.Resolve(durable)
+	if !ok {
+		t.Fatalf("Restored broker does not know %s", durable)
+	}
+	if due, ok := tmq.Due(durable); !ok || !due.Equal(snap.Messages[0].Due) {
+		t.Errorf("Expected due %v, found %v", snap.Messages[0].Due, due)
+	}
+	if p := tmq.Priority(durable); p != 3 {
+		t.Errorf("Expected the priority to be restored, found %d", p)
+	}
+	if g := tmq.Group(durable); g != "signup" {
+		t.Errorf("Expected the group to be restored, found %q", g)
+	}
+	if !restored.Loggable(durable) {
+		t.Error("Expected the restored message to stay loggable")
+	}
+	if tc, ok := restored.Trace(durable); !ok || tc.Producer != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Expected the trace context to be restored, found %+v", tc)
+	}
+}
+
+func TestSnapshotWindow(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := NewBroker(4).WithClock(clk)
+	start := clk.Now().Add(time.Hour)
+	missed, kept := NewMessageId(), NewMessageId()
+	broker.Publish(missed, "reports", []byte("hourly"), PublishOpts{NotBefore: start, NotAfter: start.Add(time.Minute), Durable: true})
+	broker.Publish(kept, "reports", []byte("daily"), PublishOpts{Delay: time.Minute, NotBefore: start, NotAfter: start.Add(time.Hour), Durable: true})
+	tmq, _ := broker.Lookup("reports")
+	if due, _ := tmq.Due(kept); !due.Equal(start) {
+		t.Errorf("Expected the message to be due at the start of its window, found %v", due)
+	}
+
+	snap := broker.Snapshot()
+	broker.Close()
+	clk.Advance(time.Hour + 2*time.Minute)
+	restored := NewBroker(4).WithClock(clk)
+	defer restored.Close()
+	restored.Restore(snap)
+	clk.Advance(0)
+
+	tmq, _ = restored.Lookup("reports")
+	if reason := tmq.Reason(missed); reason != ReasonWindowMissed {
+		t.Errorf("Expected the message whose window passed during the restart to be dead-lettered, found %q", reason)
+	}
+	if err := tmq.MarkDelivered(kept); err != nil {
+		t.Errorf("Expected the message still within its window to be delivered, received %v", err)
+	}
+}
+
+func TestBrokerMetrics(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	broker := NewBroker(4).WithClock(clk).WithMetrics(reg)
+	defer broker.Close()
+
+	id := NewMessageId()
+	broker.Publish(NewMessageId(), "emails", []byte("now"), PublishOpts{})
+	broker.Publish(id, "emails", []byte("later"), PublishOpts{Delay: time.Hour})
+	clk.Advance(time.Second)
+	tmq, _ := broker.Lookup("emails")
+	if err := tmq.CancelSend(id); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	reg.Write(&b)
+	for _, line := range []string{
+		`timermq_pushes_total{queue="emails"} 2`,
+		`timermq_fired_total{queue="emails"} 1`,
+		`timermq_cancels_total{queue="emails"} 1`,
+		`timermq_dlq_moves_total{queue="emails"} 1`,
+		`timermq_firing_lateness_seconds_count{queue="emails"} 1`,
+		`timermq_pending_timers{queue="emails"} 0`,
+		`timermq_store_messages{queue="emails"} 2`,
+		`timermq_delivery_backlog{queue="emails"} 1`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestBrokerSpread(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	broker := NewBroker(4).WithClock(clk).WithSpread(Spread{Window: time.Minute})
+	defer broker.Close()
+	broker.SetSpread("reports", Spread{Rate: 1})
+	if _, ok := broker.Lookup("reports"); ok {
+		t.Fatal("Expected SetSpread not to create the queue")
+	}
+	broker.WithMetrics(reg)
+
+	broker.Publish(NewMessageId(), "reports", []byte("daily"), PublishOpts{})
+	if s := broker.Queue("reports").Spread(); s != (Spread{Rate: 1}) {
+		t.Errorf("Expected the queue to be created with its own spread, found %+v", s)
+	}
+	if s := broker.Queue("emails").Spread(); s != (Spread{Window: time.Minute}) {
+		t.Errorf("Expected other queues to get the default spread, found %+v", s)
+	}
+	var b strings.Builder
+	reg.Write(&b)
+	if line := `timermq_pushes_total{queue="reports"} 1`; !strings.Contains(b.String(), line+"\n") {
+		t.Errorf("Missing %q in:\n%s", line, b.String())
+	}
+}
+
+func TestBrokerObserve(t *testing.T) {
+	broker := NewBroker(4)
+	defer broker.Close()
+
+	events := make(chan Event, 4)
+	stop := broker.Observe(func(e Event) { events <- e })
+
+	id := NewMessageId()
+	broker.Publish(id, "emails", []byte("welcome"), PublishOpts{Delay: time.Hour})
+	select {
+	case e := <-events:
+		if e.Type != EventPublished || e.Id != id || e.Queue != "emails" || string(e.Data) != "welcome" {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the published event")
+	}
+
+	stop()
+	broker.Publish(NewMessageId(), "emails", []byte("unobserved"), PublishOpts{Delay: time.Hour})
+	select {
+	case e := <-events:
+		t.Errorf("Received an event after stopping: %+v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestBrokerCompact(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := NewBroker(4).WithClock(clk).WithRetention(time.Minute)
+	defer broker.Close()
+
+	id := NewMessageId()
+	broker.Publish(id, "emails", []byte("welcome"), PublishOpts{Durable: true, Loggable: true})
+	clk.Advance(0)
+	tmq := broker.Queue("emails")
+	<-tmq.Deliveries()
+	tmq.MarkDelivered(id)
+
+	clk.Advance(time.Minute)
+	broker.Publish(NewMessageId(), "emails", []byte("later"), PublishOpts{Delay: time.Hour, Durable: true})
+	if _, ok := broker.Resolve(id); ok {
+		t.Error("Expected the id of a reclaimed message to be forgotten")
+	}
+	if _, ok := broker.Trace(id); ok || broker.Loggable(id) {
+		t.Error("Expected the metadata of a reclaimed message to be forgotten")
+	}
+	if n := len(broker.Snapshot().Messages); n != 1 {
+		t.Errorf("Expected only the pending message in the snapshot, found %d", n)
+	}
+}
+
+func TestCompactEvery(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := NewBroker(4).WithClock(clk).WithRetention(time.Minute)
+	defer broker.Close()
+	tmq := broker.Queue("emails")
+	deliver := func(data string) MessageId {
+		id, _ := broker.Publish(NewMessageId(), "emails", []byte(data), PublishOpts{})
+		clk.Advance(0)
+		<-tmq.Deliveries()
+		tmq.MarkDelivered(id)
+		return id
+	}
+
+	stop := broker.CompactEvery(time.Minute)
+	reclaimed := deliver("welcome")
+	clk.Advance(2 * time.Minute)
+	if _, ok := broker.Resolve(reclaimed); ok {
+		t.Error("Expected a queue nobody publishes to to be compacted")
+	}
+
+	stop()
+	kept := deliver("reminder")
+	clk.Advance(time.Hour)
+	if _, ok := broker.Resolve(kept); !ok {
+		t.Error("Expected compaction to stop")
+	}
+}
+
+func TestDedup(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	broker := NewBroker(4).WithClock(clk).WithMetrics(reg).WithDedupWindow(time.Minute)
+	defer broker.Close()
+	broker.SetDedupWindow("reports", 0)
+	opts := PublishOpts{Delay: time.Hour, Durable: true, Dedup: "order-42"}
+
+	first, _ := broker.Publish(NewMessageId(), "emails", []byte("welcome"), opts)
+	if id, _ := broker.Publish(NewMessageId(), "emails", []byte("retried"), opts); id != first {
+		t.Errorf("Expected the retry to return %s, found %s", first, id)
+	}
+	if id, _ := broker.Publish(NewMessageId(), "billing", []byte("other"), opts); id == first {
+		t.Error("Expected keys to be scoped to their queue")
+	}
+	retried := NewMessageId()
+	broker.Publish(NewMessageId(), "reports", []byte("report"), opts)
+	if id, _ := broker.Publish(retried, "reports", []byte("report"), opts); id != retried {
+		t.Error("Expected a queue without a window to ignore keys")
+	}
+	if n := broker.Queue("emails").NumActiveTimers(); n != 1 {
+		t.Errorf("Expected a single message to be scheduled, found %d", n)
+	}
+
+	var b strings.Builder
+	reg.Write(&b)
+	if line := `timermq_deduplicated_total{queue="emails"} 1`; !strings.Contains(b.String(), line+"\n") {
+		t.Errorf("Missing %q in:\n%s", line, b.String())
+	}
+
+	snap := broker.Snapshot()
+	if len(snap.Keys) != 2 || snap.Keys[0].Id != first || snap.Keys[0].Key != "order-42" {
+		t.Fatalf("Expected the keys of the emails and billing messages, found %+v", snap.Keys)
+	}
+	clk.Advance(30 * time.Second)
+	restored := NewBroker(4).WithClock(clk).WithDedupWindow(time.Minute)
+	defer restored.Close()
+	restored.Restore(snap)
+	if id, _ := restored.Publish(NewMessageId(), "emails", []byte("retried"), opts); id != first {
+		t.Errorf("Expected the key to survive a restore, found %s", id)
+	}
+
+	clk.Advance(30 * time.Second)
+	if id, _ := restored.Publish(NewMessageId(), "emails", []byte("later"), opts); id == first {
+		t.Error("Expected the key to be forgotten once its window passed")
+	}
+}
+
+func TestDedupOutlivesRetention(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := NewBroker(4).WithClock(clk).WithRetention(time.Second).WithDedupWindow(time.Hour)
+	defer broker.Close()
+	opts := PublishOpts{Durable: true, Dedup: "order-42"}
+
+	first, _ := broker.Publish(NewMessageId(), "emails", []byte("welcome"), opts)
+	clk.Advance(0)
+	tmq := broker.Queue("emails")
+	<-tmq.Deliveries()
+	tmq.MarkDelivered(first)
+	clk.Advance(time.Minute)
+	broker.Compact()
+	if _, ok := broker.Resolve(first); ok {
+		t.Fatal("Expected the message to be reclaimed")
+	}
+
+	if id, _ := broker.Publish(NewMessageId(), "emails", []byte("retried"), opts); id != first {
+		t.Errorf("Expected the key to outlive its reclaimed message, found %s", id)
+	}
+	if keys := broker.Snapshot().Keys; len(keys) != 1 || keys[0].Id != first {
+		t.Errorf("Expected the key of the reclaimed message in the snapshot, found %+v", keys)
+	}
+	if n := tmq.NumActiveTimers(); n != 0 {
+		t.Errorf("Expected nothing to be scheduled, found %d pending", n)
+	}
+}
+
+// BenchmarkChurn publishes, fires, delivers and acks messages on a queue that
+// reclaims them right away. The heap and the store stay flat however many
+// messages go through.
+func BenchmarkChurn(b *testing.B) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := NewBroker(64).WithClock(clk).WithRetention(0)
+	defer broker.Close()
+	tmq := broker.Queue(DefaultQueue)
+	data := make([]byte, 1024)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for range b.N {
+		id := NewMessageId()
+		broker.Publish(id, DefaultQueue, data, PublishOpts{})
+		clk.Advance(0)
+		<-tmq.Deliveries()
+		tmq.MarkDelivered(id)
+		tmq.Ack(id)
+	}
+	b.StopTimer()
+	broker.Compact()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	b.ReportMetric(float64(Len(tmq)), "stored")
+	b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc)), "heap-B")
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"regexp"
+	"time"
+)
+
+var dedupKeyPattern = regexp.MustCompile(`^[!-~]{1,256}$`)
+
+// ValidDedupKey reports whether key can be used as a deduplication key: 1 to
+// 256 printable ASCII characters other than space.
+func ValidDedupKey(key string) bool {
+	return dedupKeyPattern.MatchString(key)
+}
+
+// DedupKey is the deduplication key a message was published with, which
+// keeps further publishes with the same key from scheduling anything until
+// Until.
+type DedupKey struct {
+	Key   string    `json:"key"`
+	Until time.Time `json:"until"`
+}
+
+// dedupSweepEvery is how many keys are added between sweeps of the expired
+// ones.
+const dedupSweepEvery = 1024
+
+type dedupRef struct {
+	queue, key string
+}
+
+type dedupEntry struct {
+	id      MessageId
+	until   time.Time
+	durable bool
+}
+
+// dedupIndex remembers the message first published with each key of each
+// queue, for as long as the deduplication window of the queue, even if the
+// message itself is reclaimed sooner. It is guarded by the mutex of its
+// broker.
+type dedupIndex struct {
+	window  time.Duration
+	windows map[string]time.Duration
+	entries map[dedupRef]dedupEntry
+	added   int
+}
+
+func newDedupIndex() dedupIndex {
+	return dedupIndex{
+		windows: map[string]time.Duration{},
+		entries: map[dedupRef]dedupEntry{},
+	}
+}
+
+// windowOf returns the deduplication window of queue.
+func (d *dedupIndex) windowOf(queue string) time.Duration {
+	if window, ok := d.windows[queue]; ok {
+		return window
+	}
+	return d.window
+}
+
+// lookup returns the message published to queue with key whose window has
+// not passed at now.
+func (d *dedupIndex) lookup(queue, key string, now time.Time) (MessageId, bool) {
+	if key == "" {
+		return MessageId{}, false
+	}
+	e, ok := d.entries[dedupRef{queue, key}]
+	if !ok || !now.Before(e.until) {
+		return MessageId{}, false
+	}
+	return e.id, true
+}
+
+// add remembers id as published to queue with key at now, unless the queue
+// does not deduplicate. The keys of durable messages are kept in snapshots.
+func (d *dedupIndex) add(queue, key string, id MessageId, durable bool, now time.Time) {
+	if window := d.windowOf(queue); key != "" && window > 0 {
+		d.put(queue, DedupKey{Key: key, Until: now.Add(window)}, id, durable, now)
+	}
+}
+
+// put remembers id as published to queue with k, regardless of the window of
+// the queue.
+func (d *dedupIndex) put(queue string, k DedupKey, id MessageId, durable bool, now time.Time) {
+	if !now.Before(k.Until) {
+		return
+	}
+	d.entries[dedupRef{queue, k.Key}] = dedupEntry{id: id, until: k.Until, durable: durable}
+
+	if d.added++; d.added%dedupSweepEvery == 0 {
+		d.sweep(now)
+	}
+}
+
+// durableKeys returns the keys of the durable messages whose window has not
+// passed at now, including those of messages already reclaimed.
+func (d *dedupIndex) durableKeys(now time.Time) []SnapshotKey {
+	var res []SnapshotKey
+	for ref, e := range d.entries {
+		if e.durable && now.Before(e.until) {
+			res = append(res, SnapshotKey{Queue: ref.queue, Id: e.id, DedupKey: DedupKey{Key: ref.key, Until: e.until}})
+		}
+	}
+	return res
+}
+
+// sweep drops the keys whose window has passed at now.
+func (d *dedupIndex) sweep(now time.Time) {
+	for ref, e := range d.entries {
+		if !now.Before(e.until) {
+			delete(d.entries, ref)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// EventType names a transition in the lifecycle of a message.
+type EventType string
+
+const (
+	EventPublished    EventType = "published"
+	EventRescheduled  EventType = "rescheduled"
+	EventFired        EventType = "fired"
+	EventDelivered    EventType = "delivered"
+	EventAcked        EventType = "acked"
+	EventCancelled    EventType = "cancelled"
+	EventExpired      EventType = "expired"
+	EventDeadLettered EventType = "dead-lettered"
+	EventReplayed     EventType = "replayed"
+)
+
+// Event describes a message at the time of a lifecycle transition.
+type Event struct {
+	Type  EventType
+	Id    MessageId
+	Queue string
+	Data  []byte
+	// Due is when the message is, or was, due to fire.
+	Due time.Time
+	// At is when the transition happened.
+	At time.Time
+}
+
+// observer hands events to a callback on its own goroutine, so that emitting
+// an event never blocks the queue. Events are buffered without bound until
+// the callback catches up.
+type observer struct {
+	fn      func(Event)
+	mu      sync.Mutex
+	events  []Event
+	stopped bool
+	wake    chan struct{}
+}
+
+func newObserver(fn func(Event)) *observer {
+	o := &observer{fn: fn, wake: make(chan struct{}, 1)}
+	go o.run()
+	return o
+}
+
+func (o *observer) push(e Event) {
+	o.mu.Lock()
+	if o.stopped {
+		o.mu.Unlock()
+		return
+	}
+	o.events = append(o.events, e)
+	o.mu.Unlock()
+	o.signal()
+}
+
+func (o *observer) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop drops later events. Events already pushed are still handed over.
+func (o *observer) stop() {
+	o.mu.Lock()
+	o.stopped = true
+	o.mu.Unlock()
+	o.signal()
+}
+
+func (o *observer) run() {
+	for {
+		o.mu.Lock()
+		batch, stopped := o.events, o.stopped
+		o.events = nil
+		o.mu.Unlock()
+
+		for _, e := range batch {
+			o.fn(e)
+		}
+		if len(batch) == 0 {
+			if stopped {
+				return
+			}
+			<-o.wake
+		}
+	}
+}
+
+// Observe calls fn with every lifecycle event of the queue, in the order they
+// happen. fn runs on a goroutine dedicated to it, so a slow callback delays
+// later events but never the queue itself. Closing the queue stops the
+// observer once the events emitted so far have been handed over; the
+// returned function stops it earlier.
+func (t *TimerMQ) Observe(fn func(Event)) (stop func()) {
+	o := newObserver(fn)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.chClosed {
+		o.stop()
+		return func() {}
+	}
+	t.observers = append(t.observers, o)
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.observers = slices.DeleteFunc(t.observers, func(other *observer) bool { return other == o })
+		o.stop()
+	}
+}
+
+// emit sends an event to every observer. t.mu must be held.
+func (t *TimerMQ) emit(typ EventType, id MessageId, data []byte, due time.Time) {
+	if len(t.observers) == 0 {
+		return
+	}
+	e := Event{Type: typ, Id: id, Queue: t.name, Data: data, Due: due, At: t.clk.Now()}
+	for _, o := range t.observers {
+		o.push(e)
+	}
+}
+
+// Observe calls fn with the lifecycle events of every queue of the broker,
+// current and future. Events of a queue are handed
+// over in order; there is no ordering across queues.
+func (b *Broker) Observe(fn func(Event)) (stop func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bo := &brokerObserver{fn: fn}
+	for _, tmq := range b.queues {
+		bo.stops = append(bo.stops, tmq.Observe(fn))
+	}
+	b.observers = append(b.observers, bo)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.observers = slices.DeleteFunc(b.observers, func(other *brokerObserver) bool { return other == bo })
+		for _, stop := range bo.stops {
+			stop()
+		}
+	}
+}
+
+type brokerObserver struct {
+	fn    func(Event)
+	stops []func()
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Messages published to a group are handed to consumers one at a time, in
+// the order they were due. A fired message of a group is held until the
+// message of its group in flight, released to the delivery channel earlier,
+// is acked. Messages that leave without an ack, moved to the DLQ or
+// reclaimed, release the next one too, so that a group never stalls.
+
+// ValidGroup reports whether group can be used as a group key, under the
+// same rules as deduplication keys.
+func ValidGroup(group string) bool {
+	return ValidDedupKey(group)
+}
+
+// hold queues d behind the other fired messages of its group, by due time.
+// tmq.mu must be held.
+func (tmq *TimerMQ) hold(d Delivery) {
+	held := tmq.held[d.Group]
+	i, _ := slices.BinarySearchFunc(held, d, func(e, d Delivery) int {
+		return cmp.Or(tmq.settled[e.Id].due.Compare(tmq.settled[d.Id].due), compareIds(e.Id, d.Id))
+	})
+	tmq.held[d.Group] = slices.Insert(held, i, d)
+	tmq.heldCount++
+}
+
+// release hands the next held message of group to the delivery channel,
+// unless a message of group is in flight. tmq.mu must be held.
+func (tmq *TimerMQ) release(group string) {
+	held := tmq.held[group]
+	if _, busy := tmq.inflight[group]; busy || len(held) == 0 {
+		return
+	}
+	d := held[0]
+	if len(held) == 1 {
+		delete(tmq.held, group)
+	} else {
+		tmq.held[group] = held[1:]
+	}
+	tmq.heldCount--
+	tmq.inflight[group] = d.Id
+	tmq.deliver(d)
+}
+
+// advance releases the next message of the group of id, if id was in
+// flight. tmq.mu must be held.
+func (tmq *TimerMQ) advance(id MessageId) {
+	group, grouped := tmq.groups[id]
+	if !grouped || tmq.inflight[group] != id {
+		return
+	}
+	delete(tmq.inflight, group)
+	tmq.release(group)
+}
+
+// Group returns the group id was published to, or "" if none.
+func (tmq *TimerMQ) Group(id MessageId) string {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return tmq.groups[id]
+}
+
+// Held returns how many fired messages wait for the previous message of their
+// group to be acked.
+func (tmq *TimerMQ) Held() int {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return tmq.heldCount
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"github.com/BarunKGP/timermq/internal/metrics"
+)
+
+// QueueMetrics are the metrics updated by a TimerMQ. The zero value records
+// nothing.
+type QueueMetrics struct {
+	Pushes      *metrics.Counter
+	Fired       *metrics.Counter
+	Cancels     *metrics.Counter
+	DeadLetters *metrics.Counter
+	// Overflows counts the fired messages that found the delivery channel
+	// full.
+	Overflows *metrics.Counter
+	// Reclaimed counts the messages freed by Compact.
+	Reclaimed *metrics.Counter
+	// Expirations counts the messages moved to the DLQ because their
+	// delivery window passed.
+	Expirations *metrics.Counter
+	// Lateness observes how long after its due time each message fired, in
+	// seconds.
+	Lateness *metrics.Histogram
+}
+
+type brokerMetrics struct {
+	registry     *metrics.Registry
+	pushes       *metrics.CounterVec
+	fired        *metrics.CounterVec
+	cancels      *metrics.CounterVec
+	deadLetters  *metrics.CounterVec
+	overflows    *metrics.CounterVec
+	reclaimed    *metrics.CounterVec
+	expirations  *metrics.CounterVec
+	deduplicated *metrics.CounterVec
+	lateness     *metrics.HistogramVec
+}
+
+// WithMetrics registers the broker's metrics on reg: per queue counters,
+// firing lateness and gauges sampled on every scrape. It must be called
+// before any queue is created.
+func (b *Broker) WithMetrics(reg *metrics.Registry) *Broker {
+	b.metrics = &brokerMetrics{
+		registry:     reg,
+		pushes:       reg.Counter("timermq_pushes_total", "Messages published.", "queue"),
+		fired:        reg.Counter("timermq_fired_total", "Messages whose timer fired.", "queue"),
+		cancels:      reg.Counter("timermq_cancels_total", "Pending messages cancelled.", "queue"),
+		deadLetters:  reg.Counter("timermq_dlq_moves_total", "Messages moved to the dead-letter queue.", "queue"),
+		overflows:    reg.Counter("timermq_overflows_total", "Fired messages that found the delivery channel full.", "queue", "policy"),
+		reclaimed:    reg.Counter("timermq_reclaimed_total", "Delivered and dead-lettered messages freed once their retention passed.", "queue"),
+		expirations:  reg.Counter("timermq_expirations_total", "Messages moved to the dead-letter queue because their delivery window passed.", "queue"),
+		deduplicated: reg.Counter("timermq_deduplicated_total", "Publishes that repeated the deduplication key of an earlier message and scheduled nothing.", "queue"),
+		lateness:     reg.Histogram("timermq_firing_lateness_seconds", "Time between a message's due time and its timer firing.", metrics.DefBuckets, "queue"),
+	}
+
+	b.gauge(reg, "timermq_pending_timers", "Messages waiting for their timer to fire.", (*TimerMQ).NumActiveTimers)
+	b.gauge(reg, "timermq_store_messages", "Messages held in the store until they are reclaimed, whatever their state.", Len)
+	b.gauge(reg, "timermq_delivery_backlog", "Fired messages buffered for subscribers.", (*TimerMQ).Buffered)
+	b.gauge(reg, "timermq_group_held", "Fired messages held until the previous message of their group is acked.", (*TimerMQ).Held)
+	return b
+}
+
+// Metrics returns the registry passed to WithMetrics, or nil.
+func (b *Broker) Metrics() *metrics.Registry {
+	if b.metrics == nil {
+		return nil
+	}
+	return b.metrics.registry
+}
+
+func (b *Broker) gauge(reg *metrics.Registry, name, help string, sample func(*TimerMQ) int) {
+	reg.GaugeFunc(name, help, []string{"queue"}, func(emit func(float64, ...string)) {
+		for _, queue := range b.Queues() {
+			if tmq, ok := b.Lookup(queue); ok {
+				emit(float64(sample(tmq)), queue)
+			}
+		}
+	})
+}
+
+func (b *Broker) queueMetrics(queue string) QueueMetrics {
+	if b.metrics == nil {
+		return QueueMetrics{}
+	}
+	return QueueMetrics{
+		Pushes:      b.metrics.pushes.With(queue),
+		Fired:       b.metrics.fired.With(queue),
+		Cancels:     b.metrics.cancels.With(queue),
+		DeadLetters: b.metrics.deadLetters.With(queue),
+		Overflows:   b.metrics.overflows.With(queue, string(b.overflow)),
+		Reclaimed:   b.metrics.reclaimed.With(queue),
+		Expirations: b.metrics.expirations.With(queue),
+		Lateness:    b.metrics.lateness.With(queue),
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"slices"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock"
+)
+
+// SnapshotMessage is a durable message that had not been delivered when the
+// snapshot was taken.
+type SnapshotMessage struct {
+	Id    MessageId `json:"id"`
+	Queue string    `json:"queue"`
+	Data  []byte    `json:"data"`
+	Due   time.Time `json:"due"`
+	// Priority is omitted for messages of the lowest priority.
+	Priority int    `json:"priority,omitempty"`
+	Group    string `json:"group,omitempty"`
+	// Deadline is the end of the delivery window of the message, if any.
+	Deadline time.Time `json:"deadline,omitzero"`
+	// Loggable is kept so that a restored message is still audited.
+	Loggable bool          `json:"loggable,omitempty"`
+	Trace    *TraceContext `json:"trace,omitempty"`
+}
+
+// SnapshotKey is the deduplication key of a durable message. Keys are kept
+// whether or not their message was delivered or reclaimed, so that publishes
+// retried across a restart are still deduplicated.
+type SnapshotKey struct {
+	Queue string    `json:"queue"`
+	Id    MessageId `json:"id"`
+	DedupKey
+}
+
+type Snapshot struct {
+	TakenAt  time.Time         `json:"takenAt"`
+	Messages []SnapshotMessage `json:"messages"`
+	Keys     []SnapshotKey     `json:"keys,omitempty"`
+}
+
+// Snapshot freezes every queue and returns the durable messages that were
+// not delivered yet. Non-durable ones are dropped. The broker can only be
+// closed afterwards.
+func (b *Broker) Snapshot() Snapshot {
+	snap := Snapshot{TakenAt: b.clk.Now(), Messages: []SnapshotMessage{}}
+	for _, name := range b.Queues() {
+		tmq, _ := b.Lookup(name)
+		for _, p := range tmq.Freeze() {
+			b.mu.Lock()
+			durable, loggable := b.durable[p.Id], b.loggable[p.Id]
+			tc, traced := b.traces[p.Id]
+			b.mu.Unlock()
+			if !durable {
+				continue
+			}
+			m := SnapshotMessage{Id: p.Id, Queue: name, Data: p.Data, Due: p.Due, Priority: p.Priority, Group: p.Group, Deadline: p.Deadline, Loggable: loggable}
+			if traced {
+				m.Trace = &tc
+			}
+			snap.Messages = append(snap.Messages, m)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snap.Keys = b.dedup.durableKeys(snap.TakenAt)
+	slices.SortFunc(snap.Keys, func(x, y SnapshotKey) int { return compareIds(x.Id, y.Id) })
+	return snap
+}
+
+// Restore republishes the messages of snap under their original ids, and
+// remembers their deduplication keys until the end of their window.
+// Messages that became due while the broker was down fire immediately, in
+// the order they were due, unless their delivery window passed meanwhile, in
+// which case they are moved to the DLQ. Queues take them back whatever their
+// capacity.
+func (b *Broker) Restore(snap Snapshot) {
+	for _, m := range snap.Messages {
+		opts := PublishOpts{
+			Delay:    clock.Until(b.clk, m.Due),
+			Priority: m.Priority,
+			Group:    m.Group,
+			NotAfter: m.Deadline,
+			Durable:  true,
+			Loggable: m.Loggable,
+		}
+		if m.Trace != nil {
+			opts.Trace = *m.Trace
+		}
+		b.restore(m.Id, m.Queue, m.Data, opts)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range snap.Keys {
+		b.dedup.put(k.Queue, k.DedupKey, k.Id, true, b.clk.Now())
+	}
+}
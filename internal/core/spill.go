@@ -0,0 +1,96 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// spillFile is a queue of deliveries kept on disk, for the fired messages
+// that overflow the delivery channel under OverflowSpill. The file is created
+// when the first delivery spills and removed once it has been read back.
+// Unlike the in-memory backlog, it is read back in the order the deliveries
+// spilled, whatever their priority, which only travels with them.
+type spillFile struct {
+	path string
+	w    *os.File
+	r    *os.File
+	rd   *bufio.Reader
+	n    int
+}
+
+type spilledDelivery struct {
+	Id       MessageId `json:"id"`
+	Data     []byte    `json:"data"`
+	Priority int       `json:"priority,omitempty"`
+	Group    string    `json:"group,omitempty"`
+}
+
+func newSpillFile(path string) *spillFile {
+	return &spillFile{path: path}
+}
+
+func (s *spillFile) len() int {
+	if s == nil {
+		return 0
+	}
+	return s.n
+}
+
+func (s *spillFile) push(d Delivery) error {
+	if s.w == nil {
+		w, err := os.OpenFile(s.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		r, err := os.Open(s.path)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		s.w, s.r, s.rd = w, r, bufio.NewReader(r)
+	}
+	line, err := json.Marshal(spilledDelivery{Id: d.Id, Data: d.Data, Priority: d.Priority, Group: d.Group})
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.n++
+	return nil
+}
+
+// pop reads back the oldest spilled delivery. It reports false once the file
+// is empty.
+func (s *spillFile) pop() (Delivery, bool, error) {
+	if s.len() == 0 {
+		return Delivery{}, false, nil
+	}
+	s.n--
+	defer func() {
+		if s.n == 0 {
+			s.remove()
+		}
+	}()
+	line, err := s.rd.ReadBytes('\n')
+	if err != nil {
+		return Delivery{}, false, err
+	}
+	var sd spilledDelivery
+	if err := json.Unmarshal(line, &sd); err != nil {
+		return Delivery{}, false, err
+	}
+	return Delivery{Id: sd.Id, Data: sd.Data, Priority: sd.Priority, Group: sd.Group}, true, nil
+}
+
+// remove closes and deletes the file, dropping whatever is left in it.
+func (s *spillFile) remove() error {
+	if s == nil || s.w == nil {
+		return nil
+	}
+	err := errors.Join(s.w.Close(), s.r.Close(), os.Remove(s.path))
+	s.w, s.r, s.rd, s.n = nil, nil, nil, 0
+	return err
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Spread smooths the firing of messages that fall due together, such as a
+// batch published with the same delay. Window delays every message by a
+// random amount below it, unless the message was published with a jitter of
+// its own. Rate lets at most Rate messages fire per second, moving the others
+// back to the next free slot. Zero disables either.
+type Spread struct {
+	Window time.Duration `json:"window"`
+	Rate   float64       `json:"rate"`
+}
+
+// pacerSweepEvery is how many bookings are made between sweeps of the slots
+// that have passed.
+const pacerSweepEvery = 1024
+
+// pacer books the due times of a queue limited to a fire rate. Time is cut
+// into slots of span, each of which fires at most perSlot messages, spaced
+// evenly. full links every full slot to a later one that may not be, so that
+// booking a burst does not probe every full slot on the way.
+type pacer struct {
+	span    time.Duration
+	perSlot int
+	booked  map[int64]int
+	full    map[int64]int64
+	sweeps  int
+}
+
+func newPacer(rate float64) *pacer {
+	if rate <= 0 {
+		return nil
+	}
+	// Slots last at least a second, so that fractional rates still let a
+	// whole message through.
+	span := max(time.Duration(float64(time.Second)/rate), time.Second)
+	return &pacer{
+		span:    span,
+		perSlot: max(int(math.Round(rate*span.Seconds())), 1),
+		booked:  map[int64]int{},
+		full:    map[int64]int64{},
+	}
+}
+
+// book returns when a message due at due fires under the rate: its due time
+// if its slot has room, else the next free place in a later slot. Booking
+// the past starts from now.
+func (p *pacer) book(due, now time.Time) time.Time {
+	if p == nil {
+		return due
+	}
+	if p.sweeps++; p.sweeps%pacerSweepEvery == 0 {
+		p.sweep(now)
+	}
+
+	from := due
+	if from.Before(now) {
+		from = now
+	}
+	slot := p.free(p.slotOf(from))
+	n := p.booked[slot]
+	p.booked[slot] = n + 1
+	if n+1 == p.perSlot {
+		p.full[slot] = slot + 1
+	}
+	at := time.Unix(0, slot*int64(p.span)).Add(time.Duration(n) * p.span / time.Duration(p.perSlot))
+	if at.Before(due) {
+		return due
+	}
+	return at
+}
+
+func (p *pacer) slotOf(t time.Time) int64 {
+	return t.UnixNano() / int64(p.span)
+}
+
+// free returns the first slot from slot on with room left.
+func (p *pacer) free(slot int64) int64 {
+	start := slot
+	for {
+		next, full := p.full[slot]
+		if !full {
+			break
+		}
+		slot = next
+	}
+	if start != slot {
+		p.full[start] = slot
+	}
+	return slot
+}
+
+// sweep forgets the slots that have passed at now.
+func (p *pacer) sweep(now time.Time) {
+	current := p.slotOf(now)
+	for slot := range p.booked {
+		if slot < current {
+			delete(p.booked, slot)
+		}
+	}
+	for slot := range p.full {
+		if slot < current {
+			delete(p.full, slot)
+		}
+	}
+}
+
+// jitter returns a random duration below limit, or 0 if limit is not
+// positive.
+func jitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
@@ -5,36 +5,75 @@ import (
 	"sync"
 )
 
-type StoreIndex = int
-
+// Store holds items by message id.
 type Store[T any] struct {
-	data []T
+	data map[MessageId]T
 	mu   sync.Mutex
+
+	// size, if set, reports the bytes used by an item.
+	size  func(T) int
+	bytes int
+}
+
+// WithSizer makes the store account for the bytes used by its items, as
+// reported by size. It must be called before anything is stored.
+func (s *Store[T]) WithSizer(size func(T) int) *Store[T] {
+	s.size = size
+	return s
+}
+
+// Bytes returns the bytes used by the stored items, or 0 without a sizer.
+func (s *Store[T]) Bytes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
 }
 
+// Len returns how many items are stored, not counting deleted ones.
 func (s *Store[T]) Len() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	return len(s.data)
 }
 
-func (s *Store[T]) Consume(data T) int {
+// Put stores data under id, replacing what was stored there.
+func (s *Store[T]) Put(id MessageId, data T) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data = append(s.data, data)
-	return len(s.data) - 1
+	if old, exists := s.data[id]; exists && s.size != nil {
+		s.bytes -= s.size(old)
+	}
+	s.data[id] = data
+	if s.size != nil {
+		s.bytes += s.size(data)
+	}
+}
+
+func (s *Store[T]) Get(id MessageId) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, exists := s.data[id]
+	if !exists {
+		return data, fmt.Errorf("No item stored under %s", id)
+	}
+	return data, nil
 }
 
-func (s *Store[T]) Get(index StoreIndex) (T, error) {
+// Delete frees the item stored under id. It reports false if there was none.
+func (s *Store[T]) Delete(id MessageId) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if index < 0 || index >= len(s.data) {
-		var res T
-		return res, fmt.Errorf("Invalid index %d", index)
+	data, exists := s.data[id]
+	if !exists {
+		return false
+	}
+	delete(s.data, id)
+	if s.size != nil {
+		s.bytes -= s.size(data)
 	}
-	return s.data[index], nil
+	return true
 }
 
 func NewStore[T any]() *Store[T] {
-	return &Store[T]{}
+	return &Store[T]{data: map[MessageId]T{}}
 }
@@ -5,15 +5,18 @@ import "testing"
 func TestStore(t *testing.T) {
 	store := NewStore[string]()
 
+	var ids []MessageId
 	for _, msg := range []string{"terrier", "dalmatian", "retriever"} {
-		_ = store.Consume(msg)
+		id := NewMessageId()
+		store.Put(id, msg)
+		ids = append(ids, id)
 	}
 
 	if store.Len() != 3 {
 		t.Errorf("Unexpected items in store. Expected 3, found %d", store.Len())
 	}
 
-	msg2, err := store.Get(2)
+	msg2, err := store.Get(ids[2])
 	if err != nil {
 		t.Error(err)
 	}
@@ -21,4 +24,32 @@ func TestStore(t *testing.T) {
 	if msg2 != "retriever" {
 		t.Errorf("Unexpected message retrieved. Expected \"retriever\", found %s", msg2)
 	}
+	if _, err := store.Get(NewMessageId()); err == nil {
+		t.Error("Expected an unknown id not to be found")
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	store := NewStore[string]().WithSizer(func(s string) int { return len(s) })
+	first, second, third := NewMessageId(), NewMessageId(), NewMessageId()
+	store.Put(first, "terrier")
+	store.Put(second, "dalmatian")
+
+	if !store.Delete(first) || store.Delete(first) {
+		t.Error("Expected the first item to be deleted exactly once")
+	}
+	if _, err := store.Get(first); err == nil {
+		t.Error("Expected a deleted item not to be found")
+	}
+	store.Put(third, "retriever")
+	if msg, err := store.Get(second); err != nil || msg != "dalmatian" {
+		t.Errorf("Expected ids to survive deletes, found %q, %v", msg, err)
+	}
+	if store.Len() != 2 || store.Bytes() != len("dalmatian")+len("retriever") {
+		t.Errorf("Expected 2 items of 18 bytes, found %d of %d", store.Len(), store.Bytes())
+	}
+	store.Put(third, "beagle")
+	if store.Bytes() != len("dalmatian")+len("beagle") {
+		t.Errorf("Expected a replaced item to be accounted once, found %d bytes", store.Bytes())
+	}
 }
@@ -1,49 +1,533 @@
 package core
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock"
+	"github.com/google/uuid"
+)
+
+// MessageId identifies a message across the broker, its clients and its
+// snapshots. Ids are UUIDv7, so sorting them sorts messages in the order
+// they were published.
+type MessageId = uuid.UUID
+
+// NewMessageId returns a fresh id, greater than every id returned before by
+// this process.
+func NewMessageId() MessageId {
+	return uuid.Must(uuid.NewV7())
+}
+
+// compareIds orders ids by publish time.
+func compareIds(a, b MessageId) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+var (
+	ErrNotPending   = errors.New("Message is not pending")
+	ErrNotArchived  = errors.New("Message is not in the DLQ")
+	ErrNotDelivered = errors.New("Message is not awaiting an ack")
+	ErrOverflow     = errors.New("Delivery buffer is full")
+	ErrQueueFull    = errors.New("Queue is full")
+	ErrWindowMissed = errors.New("Delivery window missed")
+)
+
+// Capacity bounds what a queue admits: how many Messages may wait for their
+// timer to fire, and how many Bytes of payload they may add up to. Zero
+// means no bound.
+type Capacity struct {
+	Messages int `json:"messages"`
+	Bytes    int `json:"bytes"`
+}
+
+// MaxPriority is the highest priority a message can have. Messages default
+// to the lowest, 0.
+const MaxPriority = 9
+
+func clampPriority(p int) int {
+	return min(max(p, 0), MaxPriority)
+}
+
+// MessageOpts modify how a queue schedules a message.
+type MessageOpts struct {
+	Delay time.Duration
+	// Priority orders the messages that fire together, highest first, and
+	// the fired messages waiting for room on the delivery channel. It is
+	// clamped to 0 to MaxPriority.
+	Priority int
+	// Group, if set, makes the message wait for the messages of its group
+	// due before it to be acked before it is delivered.
+	Group string
+	// Jitter, if set, delays the message by a random amount below it, but not
+	// past its Deadline. It defaults to the spread window of the queue.
+	Jitter time.Duration
+	// Deadline, if set, is the end of the delivery window of the message: if
+	// it is not handed to a consumer by then, it is moved to the DLQ instead.
+	Deadline time.Time
+}
+
+// DeadLetterReason says why a message was moved to the DLQ.
+type DeadLetterReason string
+
+const (
+	ReasonCancelled    DeadLetterReason = "cancelled"
+	ReasonOverflow     DeadLetterReason = "overflow"
+	ReasonWindowMissed DeadLetterReason = "window missed"
 )
 
-type MessageIndex = int
+// OverflowPolicy decides what happens when a timer fires while the delivery
+// channel is full. Fired messages never block the timer that fired them.
+type OverflowPolicy string
+
+const (
+	// OverflowBlock keeps the overflowing messages in memory until readers
+	// make room, and blocks producers in Admit meanwhile.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowReject keeps the overflowing messages in memory until readers
+	// make room, and rejects producers in Admit meanwhile.
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowSpill writes the overflowing messages to the spill file until
+	// readers make room, without holding producers back.
+	OverflowSpill OverflowPolicy = "spill"
+	// OverflowDropOldest moves the oldest message waiting on the channel to
+	// the DLQ to make room for each overflowing one.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+)
+
+// Valid reports whether p is one of the known policies.
+func (p OverflowPolicy) Valid() bool {
+	switch p {
+	case OverflowBlock, OverflowReject, OverflowSpill, OverflowDropOldest:
+		return true
+	}
+	return false
+}
+
+// MessageState describes where a published message is in its lifecycle.
+type MessageState string
+
+const (
+	StatePending   MessageState = "pending"
+	StateFired     MessageState = "fired"
+	StateDelivered MessageState = "delivered"
+	StateAcked     MessageState = "acked"
+	// StateDeadLettered is the state of a message held in the DLQ, whether
+	// it was cancelled, overflowed or missed its window. Reason says which.
+	StateDeadLettered MessageState = "dead-lettered"
+)
+
+// Delivery is a message whose timer has fired, ready to be handed to a consumer.
+type Delivery struct {
+	Id       MessageId
+	Data     []byte
+	Priority int
+	Group    string
+}
 
 type TimerMQ struct {
+	name     string
 	store    *Store[[]byte]
-	dlq      map[MessageIndex][]byte
+	dlq      map[MessageId][]byte
 	capacity int
+	limit    Capacity
+	overflow OverflowPolicy
+	clk      clock.Clock
+	metrics  QueueMetrics
+
+	mCh       chan Delivery
+	timers    map[MessageId]*pendingTimer
+	byDue     timerHeap
+	fired     int
+	cancelled int
+	// lag is how late the last timer fired.
+	lag time.Duration
+	// pendingBytes is the payload size of the messages in timers.
+	pendingBytes int
+	// priorities keeps the priority of every message above 0.
+	priorities map[MessageId]int
+	// groups keeps the group of every grouped message. Fired messages of a
+	// group wait in held, by due time, while the message in inflight is not
+	// acked.
+	groups    map[MessageId]string
+	held      map[string][]Delivery
+	heldCount int
+	inflight  map[string]MessageId
+	// deadlines keeps the end of the delivery window of every message
+	// published with one.
+	deadlines map[MessageId]time.Time
+	// reasons keeps why each message of the DLQ was moved there.
+	reasons map[MessageId]DeadLetterReason
+	// spread smooths the firing of messages due together, pacing them
+	// through pacer if it sets a rate.
+	spread Spread
+	pacer  *pacer
+	// settled keeps the state and due time of fired messages.
+	settled   map[MessageId]settledMessage
+	observers []*observer
+	mu        sync.Mutex
+
+	// Overflow state. Fired messages that find mCh full wait in backlog,
+	// by priority, or in spill under OverflowSpill, oldest first, and are
+	// moved onto mCh by a single pump goroutine. drained is closed once the
+	// backlog empties.
+	backlog backlog
+	spill   *spillFile
+	pumping bool
+	drained chan struct{}
+	// changed is closed, and replaced, whenever a message stops being
+	// pending, is moved out of the backlog or is marked delivered, to wake
+	// Drain.
+	changed chan struct{}
+
+	// Reclamation state. Messages are finished once delivered or moved to
+	// the DLQ, at the time kept in finished, and listed in finishing in that
+	// order. Compact reclaims them once retention has passed, if reclaim is
+	// set. Entries of finishing that no longer match finished, because the
+	// message was replayed, are skipped.
+	reclaim   bool
+	retention time.Duration
+	finished  map[MessageId]time.Time
+	finishing []finishedMessage
+
+	// Shutdown state. The pump sends on mCh outside of mu, so sending
+	// tracks it and done releases it once the queue is frozen or closed;
+	// the delivery it held is kept in undelivered.
+	closed      bool
+	chClosed    bool
+	done        chan struct{}
+	sending     sync.WaitGroup
+	undelivered []Delivery
+}
+
+// Pending is a message that has not been handed to a consumer yet, as
+// returned by Freeze.
+type Pending struct {
+	Id       MessageId
+	Data     []byte
+	Due      time.Time
+	Priority int
+	Group    string
+	Deadline time.Time
+}
+
+type settledMessage struct {
+	state MessageState
+	due   time.Time
+}
+
+type finishedMessage struct {
+	id MessageId
+	at time.Time
+}
 
-	mCh    chan []byte
-	timers map[MessageIndex]*time.Timer
-	mu     sync.Mutex
+type pendingTimer struct {
+	id    MessageId
+	timer clock.Timer
+	due   time.Time
+	// index is the position of the timer in the byDue heap, or -1 once it
+	// was popped.
+	index int
+}
+
+// Timer describes a message waiting for its timer to fire.
+type Timer struct {
+	Id  MessageId
+	Due time.Time
+}
+
+// Counts is a snapshot of how many messages are in each state. Cancelled
+// counts every cancel, while DeadLettered only counts the messages still in
+// the DLQ.
+type Counts struct {
+	Pending      int
+	Fired        int
+	Cancelled    int
+	DeadLettered int
 }
 
 func NewTimerMQ(cap int) *TimerMQ {
 	return &TimerMQ{
-		store:    NewStore[[]byte](),
-		dlq:      map[MessageIndex][]byte{},
+		store:    NewStore[[]byte]().WithSizer(func(data []byte) int { return len(data) }),
+		dlq:      map[MessageId][]byte{},
 		capacity: cap,
+		overflow: OverflowBlock,
+		clk:      clock.Real,
 
-		mCh:    make(chan []byte, cap),
-		timers: map[int]*time.Timer{},
-		mu:     sync.Mutex{},
+		mCh:        make(chan Delivery, cap),
+		timers:     map[MessageId]*pendingTimer{},
+		priorities: map[MessageId]int{},
+		groups:     map[MessageId]string{},
+		held:       map[string][]Delivery{},
+		inflight:   map[string]MessageId{},
+		deadlines:  map[MessageId]time.Time{},
+		reasons:    map[MessageId]DeadLetterReason{},
+		settled:    map[MessageId]settledMessage{},
+		finished:   map[MessageId]time.Time{},
+		mu:         sync.Mutex{},
+		done:       make(chan struct{}),
+		changed:    make(chan struct{}),
 	}
 }
 
+// WithName sets the queue name reported in events.
+func (t *TimerMQ) WithName(name string) *TimerMQ {
+	t.name = name
+	return t
+}
+
+// Name returns the queue name set with WithName.
+func (t *TimerMQ) Name() string {
+	return t.name
+}
+
+// WithClock makes the queue schedule its timers on clk. It must be called
+// before anything is published.
+func (t *TimerMQ) WithClock(clk clock.Clock) *TimerMQ {
+	t.clk = clk
+	return t
+}
+
+// WithCapacity makes Publish refuse messages beyond c. Without it, a queue
+// admits every message.
+func (t *TimerMQ) WithCapacity(c Capacity) *TimerMQ {
+	t.SetCapacity(c)
+	return t
+}
+
+// SetCapacity changes what the queue admits from now on. Messages already
+// admitted beyond a lowered capacity are kept.
+func (t *TimerMQ) SetCapacity(c Capacity) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit = c
+}
+
+// Capacity returns what the queue admits.
+func (t *TimerMQ) Capacity() Capacity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit
+}
+
+// PendingBytes returns the payload size of the messages waiting to fire.
+func (t *TimerMQ) PendingBytes() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pendingBytes
+}
+
+// WithRetention makes Compact reclaim the messages that were delivered or
+// moved to the DLQ more than retention ago. Without it, every message is kept
+// for the lifetime of the queue.
+func (t *TimerMQ) WithRetention(retention time.Duration) *TimerMQ {
+	t.reclaim, t.retention = true, retention
+	return t
+}
+
+// WithOverflow sets what happens to fired messages that find the delivery
+// channel full. It must be called before anything is published.
+func (t *TimerMQ) WithOverflow(p OverflowPolicy) *TimerMQ {
+	t.overflow = p
+	return t
+}
+
+// WithSpillFile sets the file messages overflow to under OverflowSpill.
+// Without one, they are kept in memory instead.
+func (t *TimerMQ) WithSpillFile(path string) *TimerMQ {
+	t.spill = newSpillFile(path)
+	return t
+}
+
+// WithSpread smooths the firing of messages due together by s. Without it,
+// messages fire at exactly their due time.
+func (t *TimerMQ) WithSpread(s Spread) *TimerMQ {
+	t.SetSpread(s)
+	return t
+}
+
+// SetSpread changes how the queue smooths the firing of messages due
+// together. Messages already scheduled keep their due time.
+func (t *TimerMQ) SetSpread(s Spread) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spread = s
+	t.pacer = newPacer(s.Rate)
+}
+
+// Spread returns how the queue smooths the firing of messages due together.
+func (t *TimerMQ) Spread() Spread {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spread
+}
+
+// WithMetrics makes the queue record its activity in m.
+func (t *TimerMQ) WithMetrics(m QueueMetrics) *TimerMQ {
+	t.metrics = m
+	return t
+}
+
 func Len(tmq *TimerMQ) int {
 	return tmq.store.Len()
 }
 
+// Close stops every pending timer and closes the delivery channel once no
+// fired message is being sent on it. Messages already buffered on the channel
+// can still be received. Calling Close more than once is a no-op.
 func (t *TimerMQ) Close() {
+	t.mu.Lock()
+	if t.chClosed {
+		t.mu.Unlock()
+		return
+	}
+	t.halt()
+	t.mu.Unlock()
+
 	slog.Info("Closing TimerMQ")
+	t.sending.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.chClosed = true
 	close(t.mCh)
+	t.backlog = backlog{}
+	if err := t.spill.remove(); err != nil {
+		slog.Error("Unable to remove spill file", "queue", t.name, "error", err)
+	}
+	for _, o := range t.observers {
+		o.stop()
+	}
+	t.observers = nil
+}
+
+// halt stops every pending timer and releases the pump. Timers that are
+// already running see closed and do not fire, so they stay in t.timers. t.mu
+// must be held.
+func (t *TimerMQ) halt() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for _, pt := range t.timers {
+		pt.timer.Stop()
+	}
+	close(t.done)
+}
+
+// Freeze halts the queue and returns every message that was not handed to a
+// consumer, with its due time: pending timers, and fired messages still
+// waiting for a reader, on the channel or in the backlog, or for the previous
+// message of their group to be acked. It is meant for persisting the queue on
+// shutdown and must be followed by Close. Calling Freeze on a halted queue
+// returns nil.
+func (t *TimerMQ) Freeze() []Pending {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.halt()
+	res := []Pending{}
+	for id, pt := range t.timers {
+		if data, err := t.store.Get(id); err == nil {
+			res = append(res, t.pending(id, data, pt.due))
+		}
+	}
+	t.mu.Unlock()
+
+	t.sending.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.undelivered {
+		res = append(res, t.pending(d.Id, d.Data, t.settled[d.Id].due))
+	}
+	t.undelivered = nil
+	for _, held := range t.held {
+		for _, d := range held {
+			res = append(res, t.pending(d.Id, d.Data, t.settled[d.Id].due))
+		}
+	}
+	for {
+		d, ok := t.popBacklog()
+		if !ok {
+			break
+		}
+		res = append(res, t.pending(d.Id, d.Data, t.settled[d.Id].due))
+	}
+	for {
+		select {
+		case d := <-t.mCh:
+			res = append(res, t.pending(d.Id, d.Data, t.settled[d.Id].due))
+		default:
+			slices.SortFunc(res, func(a, b Pending) int {
+				return cmp.Or(a.Due.Compare(b.Due), compareIds(a.Id, b.Id))
+			})
+			return res
+		}
+	}
 }
 
-func (tmq *TimerMQ) IsArchived(index MessageIndex) bool {
+// pending describes a message returned by Freeze. t.mu must be held.
+func (t *TimerMQ) pending(id MessageId, data []byte, due time.Time) Pending {
+	return Pending{Id: id, Data: data, Due: due, Priority: t.priorities[id], Group: t.groups[id], Deadline: t.deadlines[id]}
+}
+
+// drainPoll is how often Drain looks at the delivery channel, which readers
+// take messages off without telling the queue.
+const drainPoll = 10 * time.Millisecond
+
+// Drain waits until every message due within the given window has fired and
+// been received from the delivery channel, or until ctx is done.
+func (t *TimerMQ) Drain(ctx context.Context, within time.Duration) error {
+	cutoff := t.clk.Now().Add(within)
+	for {
+		busy, changed := t.busyUntil(cutoff)
+		if !busy {
+			return nil
+		}
+		poll := make(chan struct{})
+		timer := t.clk.AfterFunc(drainPoll, func() { close(poll) })
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+		case <-poll:
+		}
+		timer.Stop()
+	}
+}
+
+// busyUntil reports whether messages due by cutoff are still pending or
+// waiting for a reader, along with a channel closed on the next change.
+func (t *TimerMQ) busyUntil(cutoff time.Time) (bool, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pumping || (!t.chClosed && len(t.mCh) > 0) {
+		return true, t.changed
+	}
+	return len(t.byDue) > 0 && !t.byDue[0].due.After(cutoff), t.changed
+}
+
+// notify wakes Drain. t.mu must be held.
+func (t *TimerMQ) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (tmq *TimerMQ) IsArchived(id MessageId) bool {
 	tmq.mu.Lock()
 	defer tmq.mu.Unlock()
-	_, exists := tmq.dlq[index]
+	_, exists := tmq.dlq[id]
 	return exists
 }
 
@@ -51,33 +535,37 @@ func (tmq *TimerMQ) Ping() string {
 	return "pong"
 }
 
-func (tmq *TimerMQ) Archive(index MessageIndex) error {
+func (tmq *TimerMQ) Archive(id MessageId) error {
 	tmq.mu.Lock()
 	defer tmq.mu.Unlock()
-	return tmq.archive(index)
+	return tmq.archive(id)
 }
 
-func (tmq *TimerMQ) archive(index MessageIndex) error {
-	if _, exists := tmq.dlq[index]; exists {
+func (tmq *TimerMQ) archive(id MessageId) error {
+	if _, exists := tmq.dlq[id]; exists {
 		return nil
 	}
 
-	data, err := tmq.store.Get(StoreIndex(index))
+	data, err := tmq.store.Get(id)
 	if err != nil {
 		return err
 	}
 
-	tmq.dlq[index] = data
+	tmq.dlq[id] = data
 	return nil
 }
 
-func (tmq *TimerMQ) AddTimer(index MessageIndex, timer *time.Timer) {
+func (tmq *TimerMQ) AddTimer(id MessageId, timer clock.Timer, due time.Time) {
 	tmq.mu.Lock()
 	defer tmq.mu.Unlock()
-	if _, exists := tmq.timers[index]; exists {
+	tmq.addTimer(id, timer, due)
+}
+
+func (tmq *TimerMQ) addTimer(id MessageId, timer clock.Timer, due time.Time) {
+	if _, exists := tmq.timers[id]; exists {
 		return
 	}
-	tmq.timers[index] = timer
+	tmq.setTimer(id, &pendingTimer{timer: timer, due: due})
 }
 
 func (tmq *TimerMQ) NumActiveTimers() int {
@@ -87,55 +575,600 @@ func (tmq *TimerMQ) NumActiveTimers() int {
 	return len(tmq.timers)
 }
 
-func (tmq *TimerMQ) ActiveTimerKeys() []MessageIndex {
-	keys := []int{}
+func (tmq *TimerMQ) ActiveTimerKeys() []MessageId {
+	keys := []MessageId{}
 	for k := range tmq.timers {
 		keys = append(keys, k)
 	}
 	return keys
 }
 
-func (tmq *TimerMQ) Publish(data []byte, delay time.Duration) MessageIndex {
-	newIndex := tmq.store.Consume(data)
+// PendingTimers lists the messages waiting to fire, soonest first.
+func (tmq *TimerMQ) PendingTimers() []Timer {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	res := make([]Timer, 0, len(tmq.timers))
+	for id, pt := range tmq.timers {
+		res = append(res, Timer{Id: id, Due: pt.due})
+	}
+	slices.SortFunc(res, func(a, b Timer) int {
+		return a.Due.Compare(b.Due)
+	})
+	return res
+}
+
+func (tmq *TimerMQ) Counts() Counts {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return Counts{Pending: len(tmq.timers), Fired: tmq.fired, Cancelled: tmq.cancelled, DeadLettered: len(tmq.dlq)}
+}
+
+// StoreBytes returns the bytes used by the payloads of the messages held by
+// the queue, until they are reclaimed.
+func (tmq *TimerMQ) StoreBytes() int {
+	return tmq.store.Bytes()
+}
+
+// Lag returns how late the last timer fired compared to its due time.
+func (tmq *TimerMQ) Lag() time.Duration {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return tmq.lag
+}
+
+// Overdue returns how long the most overdue pending timer has been due
+// without firing, which grows when the scheduler cannot keep up.
+func (tmq *TimerMQ) Overdue() time.Duration {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	if len(tmq.byDue) == 0 {
+		return 0
+	}
+	return max(clock.Since(tmq.clk, tmq.byDue[0].due), 0)
+}
+
+// Publish schedules data to fire after delay under a new id. It fails with
+// ErrQueueFull if the queue is at its capacity.
+func (tmq *TimerMQ) Publish(data []byte, delay time.Duration) (MessageId, error) {
+	id := NewMessageId()
+	if err := tmq.PublishAs(id, data, MessageOpts{Delay: delay}); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
 
-	timer := time.AfterFunc(delay, func() {
-		if tmq.IsArchived(newIndex) {
+// PublishAs schedules data like Publish, under an id obtained from
+// NewMessageId and with opts.
+func (tmq *TimerMQ) PublishAs(id MessageId, data []byte, opts MessageOpts) error {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	if err := tmq.admit(len(data)); err != nil {
+		return err
+	}
+	if opts.Jitter == 0 {
+		opts.Jitter = tmq.spread.Window
+	}
+	tmq.publish(id, data, opts)
+	return nil
+}
+
+// Restore schedules data under id like PublishAs, regardless of the capacity
+// of the queue, for messages that were admitted before a restart. They are
+// not jittered again by the spread window of the queue.
+func (tmq *TimerMQ) Restore(id MessageId, data []byte, opts MessageOpts) {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	tmq.publish(id, data, opts)
+}
+
+// admit checks that a message of size bytes fits the capacity of the queue.
+// tmq.mu must be held.
+func (tmq *TimerMQ) admit(size int) error {
+	if n := len(tmq.timers); tmq.limit.Messages > 0 && n >= tmq.limit.Messages {
+		return fmt.Errorf("%w: queue %s holds %d of %d messages", ErrQueueFull, tmq.name, n, tmq.limit.Messages)
+	}
+	if tmq.limit.Bytes > 0 && tmq.pendingBytes+size > tmq.limit.Bytes {
+		return fmt.Errorf("%w: queue %s holds %d of %d bytes", ErrQueueFull, tmq.name, tmq.pendingBytes, tmq.limit.Bytes)
+	}
+	return nil
+}
+
+func (tmq *TimerMQ) publish(id MessageId, data []byte, opts MessageOpts) {
+	tmq.store.Put(id, data)
+	tmq.metrics.Pushes.Inc()
+	if p := clampPriority(opts.Priority); p > 0 {
+		tmq.priorities[id] = p
+	}
+	if opts.Group != "" {
+		tmq.groups[id] = opts.Group
+	}
+	if !opts.Deadline.IsZero() {
+		tmq.deadlines[id] = opts.Deadline
+	}
+	delay := opts.Delay + jitter(opts.Jitter)
+	if !opts.Deadline.IsZero() {
+		delay = min(delay, max(opts.Delay, clock.Until(tmq.clk, opts.Deadline)))
+	}
+	due := tmq.schedule(id, data, delay)
+	tmq.emit(EventPublished, id, data, due)
+}
+
+// schedule sets a timer firing id after delay, or later if the fire rate of
+// the queue is reached then, and returns when it is due. tmq.mu must be held.
+func (tmq *TimerMQ) schedule(id MessageId, data []byte, delay time.Duration) time.Time {
+	now := tmq.clk.Now()
+	if tmq.closed {
+		return now.Add(delay)
+	}
+	pt := &pendingTimer{due: tmq.pacer.book(now.Add(delay), now)}
+	pt.timer = tmq.clk.AfterFunc(pt.due.Sub(now), func() { tmq.fire(id, pt) })
+
+	if _, exists := tmq.timers[id]; !exists {
+		tmq.pendingBytes += len(data)
+	}
+	tmq.setTimer(id, pt)
+	return pt.due
+}
+
+// fire delivers the message whose timer pt fired, along with every other
+// message already due, by priority then due time. Timers due together race
+// for tmq.mu, so whichever wins delivers the whole batch in order and the
+// others find nothing left to do. Grouped messages are all held before any
+// is released, so that each group starts with the one due first.
+func (tmq *TimerMQ) fire(id MessageId, pt *pendingTimer) {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	if tmq.timers[id] != pt || tmq.closed {
+		return
+	}
+
+	now := tmq.clk.Now()
+	batch := []MessageId{id}
+	for _, opt := range tmq.popDue(now) {
+		if opt != pt {
+			opt.timer.Stop()
+			batch = append(batch, opt.id)
+		}
+	}
+	slices.SortFunc(batch, func(a, b MessageId) int {
+		return cmp.Or(
+			cmp.Compare(tmq.priorities[b], tmq.priorities[a]),
+			tmq.timers[a].due.Compare(tmq.timers[b].due),
+			compareIds(a, b),
+		)
+	})
+	deliveries := make([]Delivery, 0, len(batch))
+	for _, id := range batch {
+		d := tmq.fireOne(id, now)
+		if tmq.missed(id, now) {
+			tmq.expire(d)
+			continue
+		}
+		if d.Group != "" {
+			tmq.hold(d)
+		}
+		deliveries = append(deliveries, d)
+	}
+	for _, d := range deliveries {
+		if d.Group == "" {
+			tmq.deliver(d)
+		} else {
+			tmq.release(d.Group)
+		}
+	}
+}
+
+// fireOne settles a pending message as fired and returns it for delivery.
+// tmq.mu must be held.
+func (tmq *TimerMQ) fireOne(id MessageId, now time.Time) Delivery {
+	pt := tmq.timers[id]
+	data, _ := tmq.store.Get(id)
+	tmq.lag = now.Sub(pt.due)
+	tmq.metrics.Lateness.Observe(tmq.lag.Seconds())
+	tmq.pendingBytes -= len(data)
+	tmq.dropTimer(id)
+	tmq.settled[id] = settledMessage{state: StateFired, due: pt.due}
+	tmq.emit(EventFired, id, data, pt.due)
+	tmq.fired++
+	tmq.metrics.Fired.Inc()
+	slog.Debug("Pushing to mCh", "data", data)
+	return Delivery{Id: id, Data: data, Priority: tmq.priorities[id], Group: tmq.groups[id]}
+}
+
+// deliver hands a fired message to the delivery channel without blocking.
+// If the channel is full, or older messages are still waiting for room, the
+// overflow policy decides what happens to it. tmq.mu must be held.
+func (tmq *TimerMQ) deliver(d Delivery) {
+	if !tmq.pumping {
+		select {
+		case tmq.mCh <- d:
+			return
+		default:
+		}
+	}
+	tmq.metrics.Overflows.Inc()
+	if tmq.overflow == OverflowDropOldest {
+		tmq.dropOldest(d)
+		return
+	}
+
+	if tmq.overflow != OverflowSpill || tmq.spill == nil {
+		tmq.backlog.push(d)
+	} else if err := tmq.spill.push(d); err != nil {
+		slog.Error("Unable to spill delivery, keeping it in memory", "queue", tmq.name, "error", err)
+		tmq.backlog.push(d)
+	}
+	if !tmq.pumping {
+		slog.Warn("Delivery channel is full", "queue", tmq.name, "capacity", tmq.capacity, "policy", tmq.overflow)
+		tmq.pumping = true
+		tmq.drained = make(chan struct{})
+		tmq.sending.Add(1)
+		go tmq.pump()
+	}
+}
+
+// dropOldest makes room for d by moving the oldest message waiting on the
+// delivery channel to the DLQ. tmq.mu must be held.
+func (tmq *TimerMQ) dropOldest(d Delivery) {
+	var old Delivery
+	var dropped bool
+	select {
+	case old = <-tmq.mCh:
+		dropped = true
+	default:
+	}
+	select {
+	case tmq.mCh <- d:
+	default:
+		// A reader took the room first, or there is none at all.
+		tmq.deadLetter(d, ReasonOverflow)
+	}
+	// Only now, as it may release the next message of its group.
+	if dropped {
+		tmq.deadLetter(old, ReasonOverflow)
+	}
+}
+
+// deadLetter moves a fired message to the DLQ. tmq.mu must be held.
+func (tmq *TimerMQ) deadLetter(d Delivery, reason DeadLetterReason) {
+	tmq.dlq[d.Id] = d.Data
+	tmq.reasons[d.Id] = reason
+	tmq.finish(d.Id)
+	tmq.advance(d.Id)
+	tmq.metrics.DeadLetters.Inc()
+	due := tmq.settled[d.Id].due
+	tmq.emit(EventDeadLettered, d.Id, d.Data, due)
+	slog.Warn("Moved undelivered message to the DLQ", "queue", tmq.name, "messageId", d.Id, "reason", reason)
+}
+
+// popBacklog takes the next message out of the spill file, oldest first, or
+// else out of the backlog. tmq.mu must be held.
+func (tmq *TimerMQ) popBacklog() (Delivery, bool) {
+	for tmq.spill.len() > 0 {
+		d, ok, err := tmq.spill.pop()
+		if err != nil {
+			slog.Error("Unable to read spilled delivery, dropping it", "queue", tmq.name, "error", err)
+			continue
+		}
+		if ok {
+			return d, true
+		}
+	}
+	return tmq.backlog.pop()
+}
+
+// pump moves the backlog onto the delivery channel as readers make room,
+// then wakes the producers waiting in Admit.
+func (tmq *TimerMQ) pump() {
+	defer tmq.sending.Done()
+	for {
+		tmq.mu.Lock()
+		d, ok := tmq.popBacklog()
+		if !ok {
+			tmq.pumping = false
+			close(tmq.drained)
+			tmq.notify()
+			tmq.mu.Unlock()
 			return
 		}
+		tmq.mu.Unlock()
+
+		select {
+		case tmq.mCh <- d:
+		case <-tmq.done:
+			// The rest of the backlog is left for Freeze.
+			tmq.mu.Lock()
+			tmq.undelivered = append(tmq.undelivered, d)
+			tmq.pumping = false
+			close(tmq.drained)
+			tmq.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Admit waits until the queue may take a new message under its overflow
+// policy. While fired messages overflow the delivery channel, OverflowBlock
+// waits for them to drain or for ctx to be done, and OverflowReject returns
+// ErrOverflow. Other policies admit every message.
+func (tmq *TimerMQ) Admit(ctx context.Context) error {
+	for {
 		tmq.mu.Lock()
-		delete(tmq.timers, newIndex)
+		if !tmq.pumping || tmq.closed || tmq.overflow != OverflowBlock && tmq.overflow != OverflowReject {
+			tmq.mu.Unlock()
+			return nil
+		}
+		if tmq.overflow == OverflowReject {
+			tmq.mu.Unlock()
+			return fmt.Errorf("%w: queue %s", ErrOverflow, tmq.name)
+		}
+		drained := tmq.drained
 		tmq.mu.Unlock()
-		slog.Debug("Pushing to mCh", "data", data)
-		tmq.mCh <- data
-		// close(tmq.mCh)
-	})
 
-	tmq.AddTimer(newIndex, timer)
-	return newIndex
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
 func (tmq *TimerMQ) Listen() [][]byte {
 	res := [][]byte{}
-	for data := range tmq.mCh {
-		res = append(res, data)
-		slog.Debug("Reading from mCh", "data", data)
+	for d := range tmq.mCh {
+		res = append(res, d.Data)
+		slog.Debug("Reading from mCh", "data", d.Data)
 	}
 	slog.Debug("Created output", "output", res)
 	return res
 }
 
-func (tmq *TimerMQ) CancelSend(index MessageIndex) error {
+func (tmq *TimerMQ) CancelSend(id MessageId) error {
 	tmq.mu.Lock()
 	defer tmq.mu.Unlock()
 
-	timer, exists := tmq.timers[index]
+	timer, exists := tmq.timers[id]
 	if !exists {
-		return fmt.Errorf("index %+v does not exist", index)
+		return fmt.Errorf("%w: message %s", ErrNotPending, id)
 	}
 
-	slog.Debug("Found timer", "index", index, "timer", timer)
-	tmq.archive(index)
-	delete(tmq.timers, index)
-	timer.Stop()
+	slog.Debug("Found timer", "id", id, "timer", timer)
+	tmq.archive(id)
+	tmq.reasons[id] = ReasonCancelled
+	tmq.cancelled++
+	tmq.metrics.Cancels.Inc()
+	tmq.metrics.DeadLetters.Inc()
+	tmq.dropTimer(id)
+	timer.timer.Stop()
+
+	data := tmq.dlq[id]
+	tmq.pendingBytes -= len(data)
+	tmq.finish(id)
+	tmq.emit(EventCancelled, id, data, timer.due)
+	tmq.emit(EventDeadLettered, id, data, timer.due)
 	return nil
 }
+
+// MarkDelivered records that a fired message was handed to a consumer, which
+// is now expected to ack it. If the delivery window of the message has
+// passed, it is moved to the DLQ instead and ErrWindowMissed is returned.
+func (tmq *TimerMQ) MarkDelivered(id MessageId) error {
+	return tmq.settle(id, StateFired, StateDelivered, EventDelivered)
+}
+
+// Ack records that the consumer of a delivered message is done with it.
+func (tmq *TimerMQ) Ack(id MessageId) error {
+	return tmq.settle(id, StateDelivered, StateAcked, EventAcked)
+}
+
+// Redeliver hands a delivered message whose consumer went away before
+// receiving it back to the delivery channel, for the next reader to take. It
+// stays the message in flight of its group, so the group keeps its order.
+func (tmq *TimerMQ) Redeliver(id MessageId) error {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	m, exists := tmq.settled[id]
+	if !exists || m.state != StateDelivered || tmq.closed {
+		return fmt.Errorf("%w: message %s", ErrNotDelivered, id)
+	}
+	data, err := tmq.store.Get(id)
+	if err != nil {
+		return err
+	}
+	m.state = StateFired
+	tmq.settled[id] = m
+	delete(tmq.finished, id)
+	tmq.deliver(Delivery{Id: id, Data: data, Priority: tmq.priorities[id], Group: tmq.groups[id]})
+	return nil
+}
+
+func (tmq *TimerMQ) settle(id MessageId, from, to MessageState, typ EventType) error {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	m, exists := tmq.settled[id]
+	if !exists || m.state != from {
+		return fmt.Errorf("%w: message %s", ErrNotDelivered, id)
+	}
+	data, _ := tmq.store.Get(id)
+	if to == StateDelivered && tmq.missed(id, tmq.clk.Now()) {
+		tmq.expire(Delivery{Id: id, Data: data})
+		return fmt.Errorf("%w: message %s", ErrWindowMissed, id)
+	}
+	m.state = to
+	tmq.settled[id] = m
+	if to == StateDelivered {
+		tmq.finish(id)
+		tmq.notify()
+	}
+	if to == StateAcked {
+		tmq.advance(id)
+	}
+	tmq.emit(typ, id, data, m.due)
+	return nil
+}
+
+// Buffered returns how many fired messages are waiting for a reader, on the
+// delivery channel or in the backlog.
+func (tmq *TimerMQ) Buffered() int {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return len(tmq.mCh) + tmq.backlog.len() + tmq.spill.len()
+}
+
+// BufferSize returns how many fired messages the delivery channel buffers
+// before the overflow policy applies.
+func (tmq *TimerMQ) BufferSize() int {
+	return tmq.capacity
+}
+
+// Deliveries returns the channel that fired messages are sent on. Each
+// delivery is received by exactly one reader.
+func (tmq *TimerMQ) Deliveries() <-chan Delivery {
+	return tmq.mCh
+}
+
+func (tmq *TimerMQ) Get(id MessageId) ([]byte, error) {
+	return tmq.store.Get(id)
+}
+
+func (tmq *TimerMQ) State(id MessageId) MessageState {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	if _, exists := tmq.timers[id]; exists {
+		return StatePending
+	}
+	if _, exists := tmq.dlq[id]; exists {
+		return StateDeadLettered
+	}
+	if m, exists := tmq.settled[id]; exists {
+		return m.state
+	}
+	return StateFired
+}
+
+// Priority returns the priority id was published with.
+func (tmq *TimerMQ) Priority(id MessageId) int {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return tmq.priorities[id]
+}
+
+// Due returns when a pending message is scheduled to fire.
+func (tmq *TimerMQ) Due(id MessageId) (time.Time, bool) {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	pt, exists := tmq.timers[id]
+	if !exists {
+		return time.Time{}, false
+	}
+	return pt.due, true
+}
+
+// Reschedule moves the timer of a pending message so that it fires delay from
+// now, or later if the fire rate of the queue is reached then. Like a message
+// published with that delay, one moved past the end of its delivery window is
+// moved to the DLQ when it fires.
+func (tmq *TimerMQ) Reschedule(id MessageId, delay time.Duration) error {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	pt, exists := tmq.timers[id]
+	if !exists || !pt.timer.Stop() {
+		return fmt.Errorf("%w: message %s", ErrNotPending, id)
+	}
+
+	slog.Debug("Rescheduling timer", "id", id, "delayMs", delay.Milliseconds())
+	data, _ := tmq.store.Get(id)
+	due := tmq.schedule(id, data, delay)
+	tmq.emit(EventRescheduled, id, data, due)
+	return nil
+}
+
+// DeadLetters lists the messages held in the DLQ, oldest first.
+func (tmq *TimerMQ) DeadLetters() []MessageId {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	res := make([]MessageId, 0, len(tmq.dlq))
+	for id := range tmq.dlq {
+		res = append(res, id)
+	}
+	slices.SortFunc(res, compareIds)
+	return res
+}
+
+// Reason returns why id was moved to the DLQ, or "" if it is not in the DLQ.
+func (tmq *TimerMQ) Reason(id MessageId) DeadLetterReason {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	return tmq.reasons[id]
+}
+
+// Replay takes a message out of the DLQ and schedules it to fire after delay,
+// without the delivery window it was published with.
+func (tmq *TimerMQ) Replay(id MessageId, delay time.Duration) error {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+
+	data, exists := tmq.dlq[id]
+	if !exists {
+		return fmt.Errorf("%w: message %s", ErrNotArchived, id)
+	}
+
+	delete(tmq.dlq, id)
+	delete(tmq.reasons, id)
+	delete(tmq.deadlines, id)
+	delete(tmq.settled, id)
+	delete(tmq.finished, id)
+	due := tmq.schedule(id, data, delay)
+	tmq.emit(EventReplayed, id, data, due)
+	return nil
+}
+
+// finish starts the retention of a message that was delivered or moved to the
+// DLQ. tmq.mu must be held.
+func (tmq *TimerMQ) finish(id MessageId) {
+	if !tmq.reclaim {
+		return
+	}
+	now := tmq.clk.Now()
+	tmq.finished[id] = now
+	tmq.finishing = append(tmq.finishing, finishedMessage{id: id, at: now})
+}
+
+// Compact reclaims the messages whose retention has passed, freeing their
+// payload and state, and returns their ids. Reclaimed messages are no
+// longer found by Get, acked or replayed. Compact does nothing unless a
+// retention was set with WithRetention.
+func (tmq *TimerMQ) Compact() []MessageId {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	if !tmq.reclaim {
+		return nil
+	}
+	cutoff := tmq.clk.Now().Add(-tmq.retention)
+	var res []MessageId
+	for len(tmq.finishing) > 0 && !tmq.finishing[0].at.After(cutoff) {
+		f := tmq.finishing[0]
+		tmq.finishing = tmq.finishing[1:]
+		if at, exists := tmq.finished[f.id]; !exists || !at.Equal(f.at) {
+			continue
+		}
+		delete(tmq.finished, f.id)
+		delete(tmq.settled, f.id)
+		delete(tmq.dlq, f.id)
+		delete(tmq.reasons, f.id)
+		delete(tmq.deadlines, f.id)
+		delete(tmq.priorities, f.id)
+		tmq.advance(f.id)
+		delete(tmq.groups, f.id)
+		tmq.store.Delete(f.id)
+		tmq.metrics.Reclaimed.Inc()
+		res = append(res, f.id)
+	}
+	return res
+}
@@ -2,11 +2,15 @@ package core
 
 import (
 	"bytes"
-	"log/slog"
+	"context"
+	"errors"
 	"os"
-	"runtime"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock/clocktest"
 )
 
 func TestLen(t *testing.T) {
@@ -35,85 +39,696 @@ func contains(store [][]byte, item []byte) bool {
 	return false
 }
 
+func newFakeTimerMQ(cap int) (*TimerMQ, *clocktest.Fake) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewTimerMQ(cap).WithClock(clk), clk
+}
+
 func TestPublish(t *testing.T) {
 	t.Log("TimerMQ: Testing publish and listen")
-	tmq := NewTimerMQ(2)
+	tmq, clk := newFakeTimerMQ(2)
 	msg1, msg2 := []byte("test message 1"), []byte("test message 2")
 
-	// var rcv [][]byte
-	go func() {
-		id1 := tmq.Publish(msg1, time.Second)
-		t.Logf("Published message with id: %d", id1)
-
-		id2 := tmq.Publish(msg2, 0)
-		t.Logf("Published message with id: %d", id2)
-
-	}()
+	id1, _ := tmq.Publish(msg1, time.Second)
+	t.Logf("Published message with id: %s", id1)
+	id2, _ := tmq.Publish(msg2, 0)
+	t.Logf("Published message with id: %s", id2)
 
-	// Wait for all messages to be sent
-	time.Sleep(time.Second + 500*time.Millisecond)
+	clk.Advance(999 * time.Millisecond)
+	if state := tmq.State(id1); state != StatePending {
+		t.Errorf("Expected %s to still be pending, found %s", msg1, state)
+	}
+	clk.Advance(time.Millisecond)
 	tmq.Close()
 
 	rcv := tmq.Listen()
-	// t.Logf("Received: %X", rcv)
 	if len(rcv) != 2 {
-		t.Errorf("Unexpected number of items returned: %d -> %s", len(rcv), rcv)
+		t.Fatalf("Unexpected number of items returned: %d -> %s", len(rcv), rcv)
+	}
+	if !bytes.Equal(rcv[0], msg2) || !bytes.Equal(rcv[1], msg1) {
+		t.Errorf("Expected messages in due order, received %s", rcv)
 	}
+}
+
+func TestCancel(t *testing.T) {
+	t.Log("TimerMQ: Testing CancelSend for messages")
+	tmq, clk := newFakeTimerMQ(3)
+	delays := []time.Duration{5 * time.Second, 200 * time.Millisecond, 10 * time.Millisecond}
 
-	if exists := contains(rcv, msg1); !exists {
-		t.Errorf("msg: %s missing in received slice", msg1)
+	ids := []MessageId{}
+	for _, d := range delays {
+		id, _ := tmq.Publish([]byte(d.String()), d)
+		ids = append(ids, id)
 	}
 
-	if exists := contains(rcv, msg2); !exists {
-		t.Errorf("msg: %s missing in received slice", msg2)
+	clk.Advance(time.Second)
+	if n := tmq.NumActiveTimers(); n != 1 {
+		t.Fatalf("Expected 1 active timer, found %d: %v", n, tmq.ActiveTimerKeys())
+	}
+	if err := tmq.CancelSend(ids[0]); err != nil {
+		t.Errorf("Failed to cancel send: %+v", err)
+	}
+	if state, reason := tmq.State(ids[0]), tmq.Reason(ids[0]); state != StateDeadLettered || reason != ReasonCancelled {
+		t.Errorf("Expected a cancelled message to be dead-lettered, found %s (%s)", state, reason)
+	}
+	if err := tmq.CancelSend(ids[1]); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Expected ErrNotPending cancelling a fired message, received %v", err)
 	}
 
-	t.Logf("Currently running %d goroutines", runtime.NumGoroutine())
+	clk.Advance(10 * time.Second)
+	tmq.Close()
+	if rcv := tmq.Listen(); len(rcv) != 2 {
+		t.Errorf("Unexpected amount of messages received. Expected 2, received %d", len(rcv))
+	}
+	if dlq := tmq.DeadLetters(); len(dlq) != 1 || dlq[0] != ids[0] {
+		t.Errorf("Expected the cancelled message in the DLQ, found %v", dlq)
+	}
 }
 
-func TestCancel(t *testing.T) {
-	l := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
-	slog.SetDefault(l)
+func TestReschedule(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(1)
+	id, _ := tmq.Publish([]byte("msg"), time.Second)
 
-	t.Log("TimerMQ: Testing CancelSend for messages")
-	tmq := NewTimerMQ(3)
-	msgs := map[time.Duration][]byte{
-		5 * time.Second:        []byte("msg 1: 5s delay"),
-		200 * time.Millisecond: []byte("msg 2: 200ms delay"),
-		10 * time.Millisecond:  []byte("msg 3: 10ms delay"),
+	clk.Advance(500 * time.Millisecond)
+	if err := tmq.Reschedule(id, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := tmq.Due(id); !due.Equal(clk.Now().Add(time.Second)) {
+		t.Errorf("Unexpected due time %v", due)
 	}
 
-	ids := []MessageIndex{}
-	go func() {
-		for d, m := range msgs {
-			ids = append(ids, tmq.Publish(m, d))
-		}
+	clk.Advance(999 * time.Millisecond)
+	if state := tmq.State(id); state != StatePending {
+		t.Fatalf("Expected the message to be pending, found %s", state)
+	}
+	clk.Advance(time.Millisecond)
+	if state := tmq.State(id); state != StateFired {
+		t.Errorf("Expected the message to have fired, found %s", state)
+	}
+	if err := tmq.Reschedule(id, time.Second); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Expected ErrNotPending, received %v", err)
+	}
+	tmq.Close()
+}
+
+func TestFireOrder(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(4)
+	defer tmq.Close()
+	moved, _ := tmq.Publish([]byte("moved"), 3*time.Second)
+	tmq.Publish([]byte("kept"), time.Second)
+	cancelled, _ := tmq.Publish([]byte("cancelled"), 500*time.Millisecond)
+	tmq.Publish([]byte("later"), 2*time.Second)
+
+	if err := tmq.CancelSend(cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmq.Reschedule(moved, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Second)
+	var got []string
+	for range 2 {
+		got = append(got, string((<-tmq.Deliveries()).Data))
+	}
+	if want := []string{"moved", "kept"}; !slices.Equal(got, want) {
+		t.Errorf("Expected %v to fire, received %v", want, got)
+	}
+	if timers := tmq.PendingTimers(); len(timers) != 1 || tmq.Overdue() != 0 {
+		t.Errorf("Expected only the later message to be pending, found %v", timers)
+	}
+}
+
+func TestCloseWhileFiring(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(1)
+	tmq.Publish([]byte("msg"), 0)
+	clk.Advance(0)
 
+	// Nothing reads the deliveries, so the next messages overflow into the
+	// backlog, whose pump blocks on the full channel until Close releases
+	// it.
+	for range 9 {
+		tmq.Publish([]byte("msg"), 0)
+	}
+	advanced := make(chan struct{})
+	go func() {
+		clk.Advance(0)
+		close(advanced)
 	}()
-	time.Sleep(1 * time.Second)
+	tmq.Close()
+	tmq.Close()
+	<-advanced
 
-	slog.Debug("Current ids", "numIds", len(ids), "ids", ids)
-	slog.Debug("Active timers after all messages published",
-		"numActiveTimers", tmq.NumActiveTimers(),
-		"activeTimerKeys", tmq.ActiveTimerKeys(),
-	)
+	if got := len(tmq.Listen()); got != 1 {
+		t.Errorf("Expected 1 buffered delivery after Close, found %d", got)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(1)
+	tmq.Publish([]byte("fired"), 0)
+	tmq.Publish([]byte("blocked"), 0)
+	tmq.Publish([]byte("later"), time.Hour)
 
+	// Whether the second message is in the backlog or still waiting for its
+	// timer when the queue freezes, it must be returned.
+	advanced := make(chan struct{})
 	go func() {
-		if err := tmq.CancelSend(ids[0]); err != nil {
-			t.Errorf("Failed to cancel send: %+v", err)
-		}
-		return
+		clk.Advance(0)
+		close(advanced)
 	}()
+	pending := tmq.Freeze()
+	<-advanced
+	tmq.Close()
+
+	if len(pending) != 3 {
+		t.Fatalf("Expected 3 undelivered messages, found %+v", pending)
+	}
+	if string(pending[2].Data) != "later" || !pending[2].Due.Equal(clk.Now().Add(time.Hour)) {
+		t.Errorf("Expected the pending timer last, found %+v", pending[2])
+	}
+	if got := len(tmq.Listen()); got != 0 {
+		t.Errorf("Expected frozen messages to be taken off the channel, found %d", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(4)
+	defer tmq.Close()
+	tmq.Publish([]byte("soon"), time.Second)
+	tmq.Publish([]byte("later"), time.Hour)
+
+	done := make(chan error, 1)
+	go func() { done <- tmq.Drain(context.Background(), time.Minute) }()
+	clk.Advance(time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Expected Drain to wait for the fired message to be received, returned %v", err)
+	default:
+	}
+	d := <-tmq.Deliveries()
+	if err := tmq.MarkDelivered(d.Id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Drain to succeed, received %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Drain to return once the message was delivered")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- tmq.Drain(ctx, 2*time.Hour) }()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Drain to stop with its context, received %v", err)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	receive := func(t *testing.T, tmq *TimerMQ) []string {
+		t.Helper()
+		var got []string
+		for range 3 - len(tmq.DeadLetters()) {
+			select {
+			case d := <-tmq.Deliveries():
+				got = append(got, string(d.Data))
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for a delivery, received %v", got)
+			}
+		}
+		return got
+	}
+	fill := func(tmq *TimerMQ, clk *clocktest.Fake) {
+		for _, data := range []string{"first", "second", "third"} {
+			tmq.Publish([]byte(data), 0)
+		}
+		clk.Advance(0)
+	}
+
+	t.Run("block", func(t *testing.T) {
+		tmq, clk := newFakeTimerMQ(1)
+		defer tmq.Close()
+		fill(tmq, clk)
+		if n := tmq.Buffered(); n != 3 {
+			t.Errorf("Expected 3 buffered messages, found %d", n)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := tmq.Admit(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected producers to block, received %v", err)
+		}
+		if got := receive(t, tmq); !slices.Equal(got, []string{"first", "second", "third"}) {
+			t.Errorf("Expected every message in order, received %v", got)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := tmq.Admit(ctx); err != nil {
+			t.Errorf("Expected producers to resume once drained, received %v", err)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		tmq, clk := newFakeTimerMQ(1)
+		defer tmq.Close()
+		tmq.WithOverflow(OverflowReject)
+		fill(tmq, clk)
+		if err := tmq.Admit(context.Background()); !errors.Is(err, ErrOverflow) {
+			t.Errorf("Expected ErrOverflow, received %v", err)
+		}
+		receive(t, tmq)
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "default.spill")
+		tmq, clk := newFakeTimerMQ(1)
+		defer tmq.Close()
+		tmq.WithOverflow(OverflowSpill).WithSpillFile(path)
+		fill(tmq, clk)
+		if err := tmq.Admit(context.Background()); err != nil {
+			t.Errorf("Expected producers not to be held back, received %v", err)
+		}
+		if got := receive(t, tmq); !slices.Equal(got, []string{"first", "second", "third"}) {
+			t.Errorf("Expected every message in order, received %v", got)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the spill file to be removed once read back, found %v", err)
+		}
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		tmq, clk := newFakeTimerMQ(1)
+		defer tmq.Close()
+		tmq.WithOverflow(OverflowDropOldest)
+		fill(tmq, clk)
+		if dlq := tmq.DeadLetters(); len(dlq) != 2 || tmq.State(dlq[0]) != StateDeadLettered || tmq.Reason(dlq[0]) != ReasonOverflow {
+			t.Errorf("Expected the two oldest messages in the DLQ, found %v", dlq)
+		}
+		if got := receive(t, tmq); !slices.Equal(got, []string{"third"}) {
+			t.Errorf("Expected the newest message to be delivered, received %v", got)
+		}
+	})
+}
+
+func TestPriority(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(8)
+	defer tmq.Close()
+	for _, m := range []struct {
+		data     string
+		delay    time.Duration
+		priority int
+	}{
+		{"low", time.Second, 0},
+		{"high", time.Second, 9},
+		{"later", 2 * time.Second, 9},
+		{"mid", time.Second, 5},
+		{"clamped", time.Second, 42},
+	} {
+		tmq.PublishAs(NewMessageId(), []byte(m.data), MessageOpts{Delay: m.delay, Priority: m.priority})
+	}
+	clk.Advance(2 * time.Second)
+
+	var got []string
+	for range 5 {
+		d := <-tmq.Deliveries()
+		got = append(got, string(d.Data))
+		if string(d.Data) == "clamped" && (d.Priority != MaxPriority || tmq.Priority(d.Id) != MaxPriority) {
+			t.Errorf("Expected the priority to be clamped to %d, found %d", MaxPriority, d.Priority)
+		}
+	}
+	if want := []string{"high", "clamped", "mid", "low", "later"}; !slices.Equal(got, want) {
+		t.Errorf("Expected messages due together by priority, received %v", got)
+	}
+}
+
+func TestBacklogFairness(t *testing.T) {
+	var b backlog
+	b.push(Delivery{Data: []byte("low")})
+	for range 2 * fairEvery {
+		b.push(Delivery{Data: []byte("high"), Priority: MaxPriority})
+	}
+
+	for i := 1; b.len() > 0; i++ {
+		d, _ := b.pop()
+		if string(d.Data) == "low" {
+			if i != fairEvery {
+				t.Errorf("Expected the low priority message to be taken at %d, found %d", fairEvery, i)
+			}
+			return
+		}
+	}
+	t.Error("Expected the low priority message to be taken")
+}
+
+func TestGroups(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(8)
+	defer tmq.Close()
+	ids := map[string]MessageId{}
+	for _, m := range []struct {
+		data     string
+		delay    time.Duration
+		priority int
+		group    string
+	}{
+		{"a1", time.Second, 0, "a"},
+		{"a2", 2 * time.Second, 9, "a"},
+		{"b1", 2 * time.Second, 0, "b"},
+		{"b2", 2 * time.Second, 9, "b"},
+		{"free", 2 * time.Second, 0, ""},
+	} {
+		ids[m.data] = NewMessageId()
+		tmq.PublishAs(ids[m.data], []byte(m.data), MessageOpts{Delay: m.delay, Priority: m.priority, Group: m.group})
+	}
+	clk.Advance(2 * time.Second)
+
+	received := func() []string {
+		var got []string
+		for {
+			select {
+			case d := <-tmq.Deliveries():
+				got = append(got, string(d.Data))
+			default:
+				return got
+			}
+		}
+	}
+	if got, want := received(), []string{"a1", "b1", "free"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the first message of each group and the ungrouped one, received %v", got)
+	}
+	if got := tmq.Held(); got != 2 {
+		t.Errorf("Expected 2 held messages, found %d", got)
+	}
+	if got := tmq.Group(ids["b2"]); got != "b" {
+		t.Errorf("Expected group b, found %q", got)
+	}
+
+	for _, id := range []MessageId{ids["a1"], ids["b1"]} {
+		if err := tmq.MarkDelivered(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := received(); len(got) != 0 {
+		t.Errorf("Expected nothing released before an ack, received %v", got)
+	}
+	if err := tmq.Ack(ids["b1"]); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := received(), []string{"b2"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the ack to release the next message of its group, received %v", got)
+	}
+	if err := tmq.Ack(ids["a1"]); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := received(), []string{"a2"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the ack to release the next message of its group, received %v", got)
+	}
+	if got := tmq.Held(); got != 0 {
+		t.Errorf("Expected no held messages, found %d", got)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(8)
+	defer tmq.Close()
+	now := clk.Now()
+	publish := func(data string, delay, window time.Duration) MessageId {
+		id := NewMessageId()
+		tmq.PublishAs(id, []byte(data), MessageOpts{Delay: delay, Deadline: now.Add(window)})
+		return id
+	}
+	late := publish("late", 2*time.Second, time.Second)
+	backlogged := publish("backlogged", time.Second, 2*time.Second)
+	onTime := publish("on time", time.Second, 5*time.Second)
+
+	clk.Advance(2 * time.Second)
+	if reason := tmq.Reason(late); reason != ReasonWindowMissed {
+		t.Errorf("Expected a message firing after its window to be dead-lettered, found %q", reason)
+	}
+	if err := tmq.MarkDelivered(onTime); err != nil {
+		t.Errorf("Expected a message within its window to be delivered, received %v", err)
+	}
+	clk.Advance(time.Second)
+	if err := tmq.MarkDelivered(backlogged); !errors.Is(err, ErrWindowMissed) {
+		t.Errorf("Expected ErrWindowMissed delivering past the window, received %v", err)
+	}
+	if got := tmq.DeadLetters(); !slices.Equal(got, []MessageId{late, backlogged}) {
+		t.Errorf("Expected the missed messages in the DLQ, found %v", got)
+	}
+
+	if err := tmq.Replay(late, 0); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(0)
+	if _, ok := tmq.Deadline(late); ok {
+		t.Error("Expected the window to be dropped on replay")
+	}
+	if reason := tmq.Reason(late); reason != "" {
+		t.Errorf("Expected the replayed message to leave the DLQ, found %q", reason)
+	}
+
+	moved := publish("moved", time.Second, 5*time.Second)
+	if err := tmq.Reschedule(moved, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(5 * time.Second)
+	if reason := tmq.Reason(moved); reason != ReasonWindowMissed {
+		t.Errorf("Expected a message rescheduled past its window to be dead-lettered, found %q", reason)
+	}
+}
+
+func TestSpread(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(8)
+	defer tmq.Close()
+	now := clk.Now()
+	dues := func() map[MessageId]time.Time {
+		res := map[MessageId]time.Time{}
+		for _, timer := range tmq.PendingTimers() {
+			res[timer.Id] = timer.Due
+		}
+		return res
+	}
+
+	jittered := map[time.Time]bool{}
+	for range 20 {
+		id := NewMessageId()
+		tmq.PublishAs(id, []byte("jittered"), MessageOpts{Delay: time.Second, Jitter: time.Hour})
+		due := dues()[id]
+		if due.Before(now.Add(time.Second)) || !due.Before(now.Add(time.Second+time.Hour)) {
+			t.Errorf("Expected the jitter to delay the message by less than an hour, found %v", due.Sub(now))
+		}
+		jittered[due] = true
+	}
+	if len(jittered) == 1 {
+		t.Error("Expected jittered messages to be spread")
+	}
+	bounded := NewMessageId()
+	tmq.PublishAs(bounded, []byte("bounded"), MessageOpts{Jitter: time.Hour, Deadline: now.Add(time.Minute)})
+	if due := dues()[bounded]; due.After(now.Add(time.Minute)) {
+		t.Errorf("Expected the jitter to stay within the window, found %v", due.Sub(now))
+	}
+
+	tmq.SetSpread(Spread{Window: time.Minute})
+	restored := NewMessageId()
+	tmq.Restore(restored, []byte("restored"), MessageOpts{Delay: time.Second})
+	if due := dues()[restored]; !due.Equal(now.Add(time.Second)) {
+		t.Errorf("Expected a restored message not to be jittered again, found %v", due.Sub(now))
+	}
+
+	tmq.SetSpread(Spread{Rate: 10})
+	var paced []MessageId
+	for range 25 {
+		id := NewMessageId()
+		tmq.PublishAs(id, []byte("paced"), MessageOpts{Delay: time.Second})
+		paced = append(paced, id)
+	}
+	perSecond := map[time.Duration]int{}
+	all := dues()
+	for i, id := range paced {
+		if want := now.Add(time.Second + time.Duration(i)*100*time.Millisecond); !all[id].Equal(want) {
+			t.Errorf("Expected message %d paced to %v, found %v", i, want.Sub(now), all[id].Sub(now))
+		}
+		perSecond[all[id].Sub(now).Truncate(time.Second)]++
+	}
+	if perSecond[time.Second] != 10 || perSecond[3*time.Second] != 5 {
+		t.Errorf("Expected at most 10 messages to fire per second, found %v", perSecond)
+	}
+	if err := tmq.Reschedule(paced[0], time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if due, want := dues()[paced[0]], now.Add(3500*time.Millisecond); !due.Equal(want) {
+		t.Errorf("Expected a rescheduled message to be paced to %v, found %v", want.Sub(now), due.Sub(now))
+	}
+}
+
+func TestPacer(t *testing.T) {
+	p := newPacer(0.5)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, want := range []time.Duration{0, 2 * time.Second, 4 * time.Second} {
+		if got := p.book(now.Add(-time.Minute), now); !got.Equal(now.Add(want)) {
+			t.Errorf("Expected booking %d at %v, found %v", i, want, got.Sub(now))
+		}
+	}
+
+	p.sweep(now.Add(time.Minute))
+	if len(p.booked) != 0 || len(p.full) != 0 {
+		t.Errorf("Expected passed slots to be swept, found %v and %v", p.booked, p.full)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(4)
+	defer tmq.Close()
+	tmq.WithCapacity(Capacity{Messages: 2, Bytes: 10})
+
+	first, err := tmq.Publish([]byte("abcd"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmq.Publish([]byte("abcdefg"), time.Second); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected the byte budget to be hit, received %v", err)
+	}
+	cancelled, err := tmq.Publish([]byte("abcdef"), time.Hour)
+	if err != nil {
+		t.Fatalf("Expected the queue to fill up exactly, received %v", err)
+	}
+	if _, err := tmq.Publish(nil, time.Second); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected the message count to be hit, received %v", err)
+	}
+	if n := tmq.NumActiveTimers(); n != 2 {
+		t.Errorf("Expected refused messages not to be scheduled, found %d pending", n)
+	}
+
+	// Fired and delivered messages keep their payload in the store, but no
+	// longer count against the capacity.
+	clk.Advance(time.Second)
+	d := <-tmq.Deliveries()
+	if err := tmq.MarkDelivered(d.Id); err != nil {
+		t.Fatal(err)
+	}
+	if n := tmq.PendingBytes(); n != 6 {
+		t.Errorf("Expected fired messages to free their bytes, found %d pending bytes", n)
+	}
+	if _, err := tmq.Publish([]byte("abcd"), time.Hour); err != nil {
+		t.Errorf("Expected room once a message fired, received %v", err)
+	}
+	if err := tmq.CancelSend(cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if n := tmq.PendingBytes(); n != 4 {
+		t.Errorf("Expected cancelled messages to free their bytes, found %d pending bytes", n)
+	}
+
+	tmq.SetCapacity(Capacity{Messages: 1})
+	if _, err := tmq.Publish(nil, time.Hour); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected a lowered capacity to apply, received %v", err)
+	}
+	if n := tmq.NumActiveTimers(); n != 1 {
+		t.Errorf("Expected admitted messages to be kept, found %d pending", n)
+	}
+	if tmq.State(first) != StateDelivered {
+		t.Errorf("Expected the first message to have been delivered, found %s", tmq.State(first))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(4)
+	defer tmq.Close()
+	tmq.WithRetention(time.Minute)
+
+	delivered, _ := tmq.Publish([]byte("delivered"), 0)
+	cancelled, _ := tmq.Publish([]byte("cancelled"), time.Hour)
+	replayed, _ := tmq.Publish([]byte("replayed"), time.Hour)
+	unread, _ := tmq.Publish([]byte("unread"), time.Second)
+	clk.Advance(0)
+	<-tmq.Deliveries()
+	tmq.MarkDelivered(delivered)
+	tmq.CancelSend(cancelled)
+	tmq.CancelSend(replayed)
+	tmq.Replay(replayed, time.Hour)
 
-	time.Sleep(1 * time.Second)
-	slog.Debug("Active timers after cancelling send",
-		"numActiveTimers", tmq.NumActiveTimers(),
-		"activeTimerKeys", tmq.ActiveTimerKeys(),
-	)
+	if reclaimed := tmq.Compact(); len(reclaimed) != 0 {
+		t.Errorf("Expected nothing to be reclaimed within the retention, found %v", reclaimed)
+	}
+	if err := tmq.Ack(delivered); err != nil {
+		t.Errorf("Expected an ack within the retention, received %v", err)
+	}
+
+	clk.Advance(time.Minute)
+	if reclaimed := tmq.Compact(); !slices.Equal(reclaimed, []MessageId{delivered, cancelled}) {
+		t.Errorf("Expected the delivered and cancelled messages to be reclaimed, found %v", reclaimed)
+	}
+	if _, err := tmq.Get(delivered); err == nil {
+		t.Error("Expected a reclaimed message not to be found")
+	}
+	if dlq := tmq.DeadLetters(); len(dlq) != 0 {
+		t.Errorf("Expected the DLQ to be reclaimed, found %v", dlq)
+	}
+	for _, id := range []MessageId{replayed, unread} {
+		if _, err := tmq.Get(id); err != nil {
+			t.Errorf("Expected message %s to be kept, received %v", id, err)
+		}
+	}
+	if n := Len(tmq); n != 2 || tmq.StoreBytes() != len("replayed")+len("unread") {
+		t.Errorf("Expected 2 messages of 14 bytes to be stored, found %d of %d", n, tmq.StoreBytes())
+	}
+	if id, _ := tmq.Publish([]byte("next"), time.Hour); compareIds(id, unread) <= 0 {
+		t.Errorf("Expected ids to sort in publish order, found %s after %s", id, unread)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	tmq, clk := newFakeTimerMQ(4)
+	events := make(chan Event, 16)
+	tmq.Observe(func(e Event) { events <- e })
+
+	fired, _ := tmq.Publish([]byte("fired"), time.Second)
+	cancelled, _ := tmq.Publish([]byte("cancelled"), time.Hour)
+	if err := tmq.Reschedule(fired, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(2 * time.Second)
+	if err := tmq.CancelSend(cancelled); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmq.Ack(fired); !errors.Is(err, ErrNotDelivered) {
+		t.Errorf("Expected ErrNotDelivered acking an undelivered message, received %v", err)
+	}
+	if err := tmq.MarkDelivered(fired); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmq.Ack(fired); err != nil {
+		t.Fatal(err)
+	}
+	if state := tmq.State(fired); state != StateAcked {
+		t.Errorf("Expected the message to be acked, found %s", state)
+	}
 	tmq.Close()
-	rcv := tmq.Listen()
 
-	if len(rcv) != 2 {
-		t.Errorf("Unexpected amount of messages received. Expected 2, received %d", len(rcv))
+	want := []struct {
+		typ EventType
+		id  MessageId
+	}{
+		{EventPublished, fired},
+		{EventPublished, cancelled},
+		{EventRescheduled, fired},
+		{EventFired, fired},
+		{EventCancelled, cancelled},
+		{EventDeadLettered, cancelled},
+		{EventDelivered, fired},
+		{EventAcked, fired},
+	}
+	for _, w := range want {
+		select {
+		case e := <-events:
+			if e.Type != w.typ || e.Id != w.id {
+				t.Fatalf("Expected %s of %s, received %s of %s", w.typ, w.id, e.Type, e.Id)
+			}
+			if e.Id == fired && !e.Due.Equal(time.Date(2024, 1, 1, 0, 0, 2, 0, time.UTC)) && e.Type != EventPublished {
+				t.Errorf("Unexpected due time in %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s", w.typ)
+		}
 	}
 }
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"container/heap"
+	"time"
+)
+
+// timerHeap orders the pending timers of a queue by due time, so that the
+// messages due when a timer fires are found without going through every
+// pending one.
+type timerHeap []*pendingTimer
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].due.Before(h[j].due)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *timerHeap) Push(x any) {
+	pt := x.(*pendingTimer)
+	pt.index = len(*h)
+	*h = append(*h, pt)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	pt := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	pt.index = -1
+	return pt
+}
+
+// setTimer makes pt the pending timer of id, replacing the one it had.
+// tmq.mu must be held.
+func (tmq *TimerMQ) setTimer(id MessageId, pt *pendingTimer) {
+	tmq.dropTimer(id)
+	pt.id = id
+	tmq.timers[id] = pt
+	heap.Push(&tmq.byDue, pt)
+}
+
+// dropTimer forgets the pending timer of id, if it has one, without stopping
+// it, and wakes Drain. tmq.mu must be held.
+func (tmq *TimerMQ) dropTimer(id MessageId) {
+	pt, exists := tmq.timers[id]
+	if !exists {
+		return
+	}
+	if pt.index >= 0 {
+		heap.Remove(&tmq.byDue, pt.index)
+	}
+	delete(tmq.timers, id)
+	tmq.notify()
+}
+
+// popDue removes the pending timers due by now from the heap, soonest first.
+// They stay in tmq.timers until dropTimer. tmq.mu must be held.
+func (tmq *TimerMQ) popDue(now time.Time) []*pendingTimer {
+	var res []*pendingTimer
+	for len(tmq.byDue) > 0 && !tmq.byDue[0].due.After(now) {
+		res = append(res, heap.Pop(&tmq.byDue).(*pendingTimer))
+	}
+	return res
+}
@@ -0,0 +1,33 @@
+package core
+
+import "time"
+
+// A message published with a deadline must be handed to a consumer by then.
+// One that is not, because the broker was down when it was due or because
+// consumers fell behind, is moved to the DLQ as ReasonWindowMissed rather
+// than delivered stale. Deadlines are checked when the message fires, and
+// again when a consumer takes it off the delivery channel.
+
+// missed reports whether the delivery window of id passed at now. tmq.mu
+// must be held.
+func (tmq *TimerMQ) missed(id MessageId, now time.Time) bool {
+	deadline, ok := tmq.deadlines[id]
+	return ok && now.After(deadline)
+}
+
+// expire moves a fired message whose delivery window passed to the DLQ.
+// tmq.mu must be held.
+func (tmq *TimerMQ) expire(d Delivery) {
+	tmq.metrics.Expirations.Inc()
+	tmq.emit(EventExpired, d.Id, d.Data, tmq.settled[d.Id].due)
+	tmq.deadLetter(d, ReasonWindowMissed)
+}
+
+// Deadline returns the end of the delivery window id was published with, if
+// any.
+func (tmq *TimerMQ) Deadline(id MessageId) (time.Time, bool) {
+	tmq.mu.Lock()
+	defer tmq.mu.Unlock()
+	deadline, ok := tmq.deadlines[id]
+	return deadline, ok
+}
@@ -5,18 +5,36 @@ import (
 	"time"
 
 	"github.com/BarunKGP/timermq/internal/values"
-	"github.com/google/uuid"
 )
 
 type OptionalArgs struct {
-	Delay    time.Duration
-	Ttl      time.Duration
+	Queue string
+	Delay time.Duration
+	Ttl   time.Duration
+	// Jitter bounds the random delay added to a pushed message.
+	Jitter time.Duration
+	// Priority is the priority of a pushed message, from 0 to 9.
+	Priority int
 	Durable  bool
 	Loggable bool
+	// Dedup is the deduplication key of a pushed message.
+	Dedup string
+	// Group is the group of a pushed message, delivered one at a time.
+	Group string
+	// NotBefore and NotAfter bound the delivery window of a pushed message.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Traceparent and Tracestate are the W3C trace context of the producer.
+	Traceparent string
+	Tracestate  string
+	// Secret is the credential sent with AUTH.
+	Secret string
+	// MaxMessages and MaxBytes are the capacity sent with RESIZE.
+	MaxMessages int
+	MaxBytes    int
 }
 
 type Message struct {
-	id        uuid.UUID
 	rawstring string
 
 	cmd  values.CommandMethod
@@ -24,12 +42,8 @@ type Message struct {
 	args OptionalArgs
 }
 
-func (m Message) GetId() uuid.UUID {
-	return m.id
-}
-
 func NewMessage(data string) *Message {
-	return &Message{id: uuid.New(), rawstring: data}
+	return &Message{rawstring: data}
 }
 
 func NewMessageFromTokens(tokens []string) *Message {
@@ -57,6 +71,71 @@ func (m *Message) WithCancel() (*Message, error) {
 	return m, nil
 }
 
+func (m *Message) WithDelay() (*Message, error) {
+	m.cmd = values.Delay
+	return m, nil
+}
+
+func (m *Message) WithSubscribe() (*Message, error) {
+	m.cmd = values.Subscribe
+	return m, nil
+}
+
+func (m *Message) WithObserve() (*Message, error) {
+	m.cmd = values.Observe
+	return m, nil
+}
+
+func (m *Message) WithQueues() (*Message, error) {
+	m.cmd = values.Queues
+	return m, nil
+}
+
+func (m *Message) WithAck() (*Message, error) {
+	m.cmd = values.Ack
+	return m, nil
+}
+
+func (m *Message) WithInfo() (*Message, error) {
+	m.cmd = values.Info
+	return m, nil
+}
+
+func (m *Message) WithHealth() (*Message, error) {
+	m.cmd = values.Health
+	return m, nil
+}
+
+func (m *Message) WithAuth() (*Message, error) {
+	m.cmd = values.Auth
+	return m, nil
+}
+
+func (m *Message) WithResize() (*Message, error) {
+	m.cmd = values.Resize
+	return m, nil
+}
+
+func (m *Message) WithStats() (*Message, error) {
+	m.cmd = values.Stats
+	return m, nil
+}
+
+func (m *Message) WithTimers() (*Message, error) {
+	m.cmd = values.Timers
+	return m, nil
+}
+
+func (m *Message) WithDeadLetters() (*Message, error) {
+	m.cmd = values.DeadLetters
+	return m, nil
+}
+
+func (m *Message) WithReplay() (*Message, error) {
+	m.cmd = values.Replay
+	return m, nil
+}
+
 func (m *Message) SetValue(val string) {
 	m.val = val
 }
@@ -80,3 +159,11 @@ func (m *Message) GetValueBytes() []byte {
 func (m *Message) GetDelay() time.Duration {
 	return m.args.Delay
 }
+
+func (m *Message) GetQueue() string {
+	return m.args.Queue
+}
+
+func (m *Message) GetArgs() OptionalArgs {
+	return m.args
+}
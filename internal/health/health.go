@@ -0,0 +1,168 @@
+// Package health reports whether the broker is alive and ready to take
+// traffic, for orchestrators probing /healthz and /readyz or the HEALTH
+// command.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/persistence"
+)
+
+// DefaultMaxLag is how far behind its timers the scheduler may fall before
+// the broker stops being ready.
+const DefaultMaxLag = 5 * time.Second
+
+// probeInterval bounds how often the persistence probe touches the disk.
+const probeInterval = 10 * time.Second
+
+type Check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Detail explains a failed check.
+	Detail string `json:"detail,omitempty"`
+}
+
+type Report struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+// Checker evaluates the readiness of a broker.
+type Checker struct {
+	broker   *core.Broker
+	maxLag   time.Duration
+	snapshot string
+
+	mu           sync.Mutex
+	restoring    bool
+	shuttingDown bool
+	probedAt     time.Time
+	probeErr     error
+}
+
+func NewChecker(broker *core.Broker) *Checker {
+	return &Checker{broker: broker, maxLag: DefaultMaxLag}
+}
+
+// WithMaxLag sets how long a timer may be overdue before the broker stops
+// being ready.
+func (c *Checker) WithMaxLag(d time.Duration) *Checker {
+	c.maxLag = d
+	return c
+}
+
+// WithSnapshot makes readiness depend on snapshots being writable to path.
+func (c *Checker) WithSnapshot(path string) *Checker {
+	c.snapshot = path
+	return c
+}
+
+// SetRestoring marks the broker as restoring a snapshot, which it is not
+// ready to serve traffic during.
+func (c *Checker) SetRestoring(restoring bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.restoring = restoring
+}
+
+// SetShuttingDown marks the broker as shutting down for good.
+func (c *Checker) SetShuttingDown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shuttingDown = true
+}
+
+// Ready runs every readiness check.
+func (c *Checker) Ready() Report {
+	c.mu.Lock()
+	restoring, shuttingDown := c.restoring, c.shuttingDown
+	c.mu.Unlock()
+
+	checks := []Check{
+		check("recovery", !restoring, "restoring snapshot"),
+		check("shutdown", !shuttingDown, "shutting down"),
+		c.persistence(),
+		c.scheduler(),
+		c.capacity(),
+	}
+	report := Report{Ready: true, Checks: checks}
+	for _, ch := range checks {
+		report.Ready = report.Ready && ch.OK
+	}
+	return report
+}
+
+func check(name string, ok bool, detail string) Check {
+	if ok {
+		return Check{Name: name, OK: true}
+	}
+	return Check{Name: name, Detail: detail}
+}
+
+// persistence probes the snapshot directory, at most once per
+// probeInterval, so that a disk that cannot be synced is noticed before
+// durable messages are lost on shutdown.
+func (c *Checker) persistence() Check {
+	if c.snapshot == "" {
+		return Check{Name: "persistence", OK: true}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.probedAt) >= probeInterval {
+		c.probeErr = persistence.Probe(c.snapshot)
+		c.probedAt = time.Now()
+	}
+	if c.probeErr != nil {
+		return Check{Name: "persistence", Detail: c.probeErr.Error()}
+	}
+	return Check{Name: "persistence", OK: true}
+}
+
+func (c *Checker) scheduler() Check {
+	for _, name := range c.broker.Queues() {
+		tmq, _ := c.broker.Lookup(name)
+		if overdue := tmq.Overdue(); overdue > c.maxLag {
+			return Check{Name: "scheduler", Detail: fmt.Sprintf("queue %s has timers %s overdue", name, overdue.Round(time.Millisecond))}
+		}
+	}
+	return Check{Name: "scheduler", OK: true}
+}
+
+func (c *Checker) capacity() Check {
+	for _, name := range c.broker.Queues() {
+		tmq, _ := c.broker.Lookup(name)
+		if tmq.BufferSize() > 0 && tmq.Buffered() >= tmq.BufferSize() {
+			return Check{Name: "capacity", Detail: fmt.Sprintf("queue %s has %d undelivered messages, its capacity", name, tmq.Buffered())}
+		}
+	}
+	return Check{Name: "capacity", OK: true}
+}
+
+// Register serves /healthz, which succeeds as long as the process answers,
+// and /readyz, which fails with 503 while a readiness check fails. Both
+// reply with JSON.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := c.Ready()
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock/clocktest"
+	"github.com/BarunKGP/timermq/internal/core"
+)
+
+func failing(r Report) []string {
+	var names []string
+	for _, c := range r.Checks {
+		if !c.OK {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+func TestReady(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := core.NewBroker(1).WithClock(clk)
+	defer broker.Close()
+	checker := NewChecker(broker).WithSnapshot(filepath.Join(t.TempDir(), "snapshot.json"))
+
+	if r := checker.Ready(); !r.Ready || len(r.Checks) != 5 {
+		t.Fatalf("Expected a fresh broker to be ready, found %+v", r)
+	}
+
+	checker.SetRestoring(true)
+	if f := failing(checker.Ready()); len(f) != 1 || f[0] != "recovery" {
+		t.Errorf("Expected only recovery to fail while restoring, found %v", f)
+	}
+	checker.SetRestoring(false)
+
+	// Fill the delivery buffer of a queue nobody reads.
+	broker.Publish(core.NewMessageId(), "emails", []byte("unread"), core.PublishOpts{})
+	clk.Advance(0)
+	if f := failing(checker.Ready()); len(f) != 1 || f[0] != "capacity" {
+		t.Errorf("Expected only capacity to fail with a full buffer, found %v", f)
+	}
+
+	tmq := broker.Queue("reports")
+	tmq.AddTimer(core.NewMessageId(), clk.AfterFunc(time.Hour, func() {}), clk.Now().Add(-time.Minute))
+	if f := failing(checker.Ready()); len(f) != 2 || f[0] != "scheduler" {
+		t.Errorf("Expected the scheduler to fail with an overdue timer, found %v", f)
+	}
+
+	checker.SetShuttingDown()
+	if f := failing(checker.Ready()); len(f) != 3 || f[0] != "shutdown" {
+		t.Errorf("Expected shutdown to fail, found %v", f)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	broker := core.NewBroker(1)
+	defer broker.Close()
+	checker := NewChecker(broker).WithSnapshot(filepath.Join(t.TempDir(), "missing", "snapshot.json"))
+
+	r := checker.Ready()
+	if f := failing(r); len(f) != 1 || f[0] != "persistence" {
+		t.Errorf("Expected persistence to fail without a snapshot directory, found %v", f)
+	}
+	if r.Checks[2].Detail == "" {
+		t.Errorf("Expected the failing check to explain itself, found %+v", r.Checks[2])
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	broker := core.NewBroker(1)
+	defer broker.Close()
+	checker := NewChecker(broker)
+	mux := http.NewServeMux()
+	checker.Register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) (int, Report) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var r Report
+		json.NewDecoder(resp.Body).Decode(&r)
+		return resp.StatusCode, r
+	}
+
+	if code, r := get("/readyz"); code != http.StatusOK || !r.Ready {
+		t.Errorf("Expected /readyz to succeed, found %d %+v", code, r)
+	}
+	checker.SetShuttingDown()
+	if code, r := get("/readyz"); code != http.StatusServiceUnavailable || r.Ready {
+		t.Errorf("Expected /readyz to fail while shutting down, found %d %+v", code, r)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to succeed while shutting down, found %d", code)
+	}
+}
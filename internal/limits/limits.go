@@ -0,0 +1,239 @@
+// Package limits keeps a single client or queue from flooding the broker,
+// with token buckets on pushes and deliveries. What a queue may hold is
+// bounded by its capacity, which the queue enforces itself.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock"
+)
+
+var ErrThrottled = errors.New("Throttled")
+
+// Rate lets Burst operations through at once, refilled at PerSecond. A zero
+// PerSecond means no limit; a zero Burst defaults to one second's worth.
+type Rate struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     int     `json:"burst"`
+}
+
+func (r Rate) limited() bool {
+	return r.PerSecond > 0
+}
+
+func (r Rate) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return max(1, math.Ceil(r.PerSecond))
+}
+
+func (r Rate) Validate() error {
+	if r.PerSecond < 0 || r.Burst < 0 {
+		return fmt.Errorf("perSecond and burst must not be negative, found %g and %d", r.PerSecond, r.Burst)
+	}
+	return nil
+}
+
+// Limits are applied to every client, identified by the user it
+// authenticated as or else its remote host, and to every queue.
+type Limits struct {
+	ClientPush    Rate `json:"clientPush"`
+	ClientConsume Rate `json:"clientConsume"`
+	QueuePush     Rate `json:"queuePush"`
+	QueueConsume  Rate `json:"queueConsume"`
+}
+
+// Enabled reports whether any limit is set.
+func (l Limits) Enabled() bool {
+	return l != Limits{}
+}
+
+func (l Limits) Validate() error {
+	var errs []error
+	for name, r := range map[string]Rate{
+		"clientPush":    l.ClientPush,
+		"clientConsume": l.ClientConsume,
+		"queuePush":     l.QueuePush,
+		"queueConsume":  l.QueueConsume,
+	} {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ThrottledError is returned when a limit is hit. The operation had no
+// effect and may be retried after RetryAfter.
+type ThrottledError struct {
+	// Limit names the limit that was hit, such as "clientPush" or
+	// "queuePush".
+	Limit      string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Reason, e.RetryAfter)
+}
+
+func (e *ThrottledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// throttled rounds retryAfter up to the millisecond, so that retrying after
+// the hint is never too early.
+func throttled(limit, reason string, retryAfter time.Duration) error {
+	retryAfter = (retryAfter + time.Millisecond - 1).Truncate(time.Millisecond)
+	return &ThrottledError{Limit: limit, Reason: reason, RetryAfter: max(retryAfter, time.Millisecond)}
+}
+
+type bucket struct {
+	rate   Rate
+	tokens float64
+	at     time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = min(b.rate.burst(), b.tokens+now.Sub(b.at).Seconds()*b.rate.PerSecond)
+	b.at = now
+}
+
+// sweepEvery is how many buckets are created between sweeps of the idle
+// ones.
+const sweepEvery = 1024
+
+// Limiter enforces Limits. A nil Limiter allows everything.
+type Limiter struct {
+	limits Limits
+	clk    clock.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	created int
+}
+
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{limits: limits, clk: clock.Real, buckets: map[string]*bucket{}}
+}
+
+// WithClock makes the buckets refill on clk.
+func (l *Limiter) WithClock(clk clock.Clock) *Limiter {
+	l.clk = clk
+	return l
+}
+
+// refill returns the bucket of key topped up to now, creating it full.
+func (l *Limiter) refill(key string, r Rate, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		l.created++
+		if l.created%sweepEvery == 0 {
+			l.sweep(now)
+		}
+		b = &bucket{rate: r, tokens: r.burst(), at: now}
+		l.buckets[key] = b
+		return b
+	}
+	b.refill(now)
+	return b
+}
+
+// sweep drops the buckets that have been idle long enough to be full again,
+// which the next operation recreates identically.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.rate.burst() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type limited struct {
+	name string
+	key  string
+	rate Rate
+	who  string
+}
+
+// take spends a token from every bucket if all of them have one, or returns
+// the error of the one that runs dry the longest.
+func (l *Limiter) take(op string, checks ...limited) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clk.Now()
+	var worst error
+	var wait time.Duration
+	buckets := make([]*bucket, 0, len(checks))
+	for _, c := range checks {
+		if !c.rate.limited() {
+			continue
+		}
+		b := l.refill(c.key, c.rate, now)
+		buckets = append(buckets, b)
+		if b.tokens >= 1 {
+			continue
+		}
+		if w := time.Duration((1 - b.tokens) / c.rate.PerSecond * float64(time.Second)); w > wait {
+			wait = w
+			worst = throttled(c.name, fmt.Sprintf("%s rate of %s exceeded", op, c.who), w)
+		}
+	}
+	if worst != nil {
+		return worst
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil
+}
+
+// reserve spends a token from every bucket, going into debt if needed, and
+// returns how long to wait before acting on it.
+func (l *Limiter) reserve(checks ...limited) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clk.Now()
+	var wait time.Duration
+	for _, c := range checks {
+		if !c.rate.limited() {
+			continue
+		}
+		b := l.refill(c.key, c.rate, now)
+		b.tokens--
+		if b.tokens < 0 {
+			wait = max(wait, time.Duration(-b.tokens/c.rate.PerSecond*float64(time.Second)))
+		}
+	}
+	return wait
+}
+
+// Push admits a message pushed by client on queue, or returns a
+// ThrottledError if either of them is over its push rate.
+func (l *Limiter) Push(client, queue string) error {
+	if l == nil {
+		return nil
+	}
+	return l.take("Push",
+		limited{name: "clientPush", key: "push/client/" + client, rate: l.limits.ClientPush, who: "client " + client},
+		limited{name: "queuePush", key: "push/queue/" + queue, rate: l.limits.QueuePush, who: "queue " + queue},
+	)
+}
+
+// Consume reserves the next delivery from queue to client and returns how
+// long the subscriber must wait before taking it.
+func (l *Limiter) Consume(client, queue string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.reserve(
+		limited{key: "consume/client/" + client, rate: l.limits.ClientConsume},
+		limited{key: "consume/queue/" + queue, rate: l.limits.QueueConsume},
+	)
+}
@@ -0,0 +1,77 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock/clocktest"
+)
+
+func TestPush(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewLimiter(Limits{
+		ClientPush: Rate{PerSecond: 10, Burst: 2},
+		QueuePush:  Rate{PerSecond: 1, Burst: 3},
+	}).WithClock(clk)
+
+	for i := range 2 {
+		if err := l.Push("alice", "orders"); err != nil {
+			t.Fatalf("Expected push %d to fit the burst, received %v", i, err)
+		}
+	}
+	var throttled *ThrottledError
+	err := l.Push("alice", "orders")
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrThrottled) {
+		t.Fatalf("Expected the client to be throttled, received %v", err)
+	}
+	if throttled.Limit != "clientPush" || throttled.RetryAfter != 100*time.Millisecond {
+		t.Errorf("Expected clientPush with a 100ms hint, found %s and %s", throttled.Limit, throttled.RetryAfter)
+	}
+
+	// The third push of bob uses the last token of the queue, so the one
+	// refused to alice must not have spent it.
+	if err := l.Push("bob", "orders"); err != nil {
+		t.Fatalf("Expected another client to push, received %v", err)
+	}
+	err = l.Push("bob", "orders")
+	if !errors.As(err, &throttled) || throttled.Limit != "queuePush" || throttled.RetryAfter != time.Second {
+		t.Fatalf("Expected the queue to be throttled for 1s, received %v", err)
+	}
+	if err := l.Push("bob", "payments"); err != nil {
+		t.Errorf("Expected another queue to take pushes, received %v", err)
+	}
+
+	clk.Advance(time.Second)
+	if err := l.Push("alice", "orders"); err != nil {
+		t.Errorf("Expected the buckets to refill, received %v", err)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewLimiter(Limits{QueueConsume: Rate{PerSecond: 4}}).WithClock(clk)
+
+	var waits []time.Duration
+	for range 6 {
+		waits = append(waits, l.Consume("alice", "orders"))
+	}
+	want := []time.Duration{0, 0, 0, 0, 250 * time.Millisecond, 500 * time.Millisecond}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("Expected delivery %d to wait %s, found %s", i, want[i], waits[i])
+		}
+	}
+	if wait := (*Limiter)(nil).Consume("alice", "orders"); wait != 0 {
+		t.Errorf("Expected a nil limiter not to wait, found %s", wait)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Limits{ClientPush: Rate{PerSecond: -1}, QueueConsume: Rate{Burst: -1}}).Validate(); err == nil {
+		t.Error("Expected negative limits to be rejected")
+	}
+	if err := (Limits{QueuePush: Rate{PerSecond: 0.5}}).Validate(); err != nil {
+		t.Errorf("Expected fractional rates to be accepted, received %v", err)
+	}
+}
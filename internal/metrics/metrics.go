@@ -0,0 +1,307 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in
+// the Prometheus text format.
+//
+// Every method on a nil *Counter or *Histogram is a no-op, so instrumented
+// code does not need to check whether metrics are enabled.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry holds the metrics exposed by a process. Asking for a metric that is
+// already registered returns the existing one, so that several components can
+// share it.
+type Registry struct {
+	mu     sync.Mutex
+	names  []string
+	byName map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]collector{}}
+}
+
+func (r *Registry) register(name string, create func() collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, exists := r.byName[name]; exists {
+		return c
+	}
+	c := create()
+	r.byName[name] = c
+	r.names = append(r.names, name)
+	slices.Sort(r.names)
+	return c
+}
+
+// Counter returns the counter vector called name, partitioned by labels.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return r.register(name, func() collector {
+		return &CounterVec{desc: desc{name: name, help: help, labels: labels}, series: map[string]*Counter{}}
+	}).(*CounterVec)
+}
+
+// Histogram returns the histogram vector called name with the given upper
+// bucket bounds, partitioned by labels.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return r.register(name, func() collector {
+		return &HistogramVec{desc: desc{name: name, help: help, labels: labels}, buckets: slices.Sorted(slices.Values(buckets)), series: map[string]*Histogram{}}
+	}).(*HistogramVec)
+}
+
+// GaugeFunc registers collect under the gauge called name. collect is called
+// on every scrape and reports each value with its label values through emit.
+// Several functions can be registered under the same name as long as they
+// emit different label values.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	g := r.register(name, func() collector {
+		return &gaugeFunc{desc: desc{name: name, help: help, labels: labels}}
+	}).(*gaugeFunc)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.collect = append(g.collect, collect)
+}
+
+// Write writes every metric in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := make([]collector, len(r.names))
+	for i, name := range r.names {
+		collectors[i] = r.byName[name]
+	}
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the registry to Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, kind)
+}
+
+// key joins label values into a map key.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs renders {a="x",b="y"} for the label values in key, with extra
+// pairs appended.
+func (d desc) labelPairs(key string, extra ...string) string {
+	var values []string
+	if len(d.labels) > 0 {
+		values = strings.Split(key, "\xff")
+	}
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, label := range d.labels {
+		pairs = append(pairs, label+"="+strconv.Quote(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*Counter
+}
+
+// With returns the counter for the given label values, creating it at zero.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	if v == nil {
+		return nil
+	}
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, exists := v.series[key]
+	if !exists {
+		c = &Counter{}
+		v.series[key] = c
+	}
+	return c
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	v.header(w, "counter")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.series) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(key), formatFloat(v.series[key].Value()))
+	}
+}
+
+type Counter struct {
+	mu  sync.Mutex
+	val float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.val += delta
+}
+
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.val
+}
+
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*Histogram
+}
+
+// With returns the histogram for the given label values, creating it empty.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	if v == nil {
+		return nil
+	}
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, exists := v.series[key]
+	if !exists {
+		h = &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+		v.series[key] = h
+	}
+	return h
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+	v.header(w, "histogram")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.series) {
+		h := v.series[key]
+		h.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.labelPairs(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.labelPairs(key, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, v.labelPairs(key), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, v.labelPairs(key), h.count)
+		h.mu.Unlock()
+	}
+}
+
+type Histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns how many values were observed.
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+type gaugeFunc struct {
+	desc
+	mu      sync.Mutex
+	collect []func(emit func(value float64, labelValues ...string))
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w, "gauge")
+	g.mu.Lock()
+	collect := slices.Clone(g.collect)
+	g.mu.Unlock()
+
+	for _, fn := range collect {
+		fn(func(value float64, labelValues ...string) {
+			fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(g.key(labelValues)), formatFloat(value))
+		})
+	}
+}
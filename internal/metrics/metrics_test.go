@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	pushes := r.Counter("pushes_total", "Messages pushed.", "queue")
+	pushes.With("default").Inc()
+	pushes.With("emails").Add(2)
+	if r.Counter("pushes_total", "Messages pushed.", "queue") != pushes {
+		t.Error("Expected registering a counter twice to return the same one")
+	}
+
+	lateness := r.Histogram("lateness_seconds", "Firing lateness.", []float64{0.1, 1}, "queue")
+	lateness.With("default").Observe(0.05)
+	lateness.With("default").Observe(0.5)
+	lateness.With("default").Observe(2)
+
+	r.GaugeFunc("connections", "Open connections.", []string{"listener"}, func(emit func(float64, ...string)) {
+		emit(3, "tcp")
+	})
+
+	var b strings.Builder
+	r.Write(&b)
+	want := `# HELP connections Open connections.
+# TYPE connections gauge
+connections{listener="tcp"} 3
+# HELP lateness_seconds Firing lateness.
+# TYPE lateness_seconds histogram
+lateness_seconds_bucket{queue="default",le="0.1"} 1
+lateness_seconds_bucket{queue="default",le="1"} 2
+lateness_seconds_bucket{queue="default",le="+Inf"} 3
+lateness_seconds_sum{queue="default"} 2.55
+lateness_seconds_count{queue="default"} 3
+# HELP pushes_total Messages pushed.
+# TYPE pushes_total counter
+pushes_total{queue="default"} 1
+pushes_total{queue="emails"} 2
+`
+	if b.String() != want {
+		t.Errorf("Unexpected exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var counters *CounterVec
+	var histograms *HistogramVec
+	counters.With("x").Inc()
+	histograms.With("x").Observe(1)
+	if counters.With("x").Value() != 0 || histograms.With("x").Count() != 0 {
+		t.Error("Expected nil metrics to stay empty")
+	}
+}
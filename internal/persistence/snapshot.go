@@ -0,0 +1,74 @@
+// Package persistence stores broker snapshots on disk so that durable
+// messages survive a restart.
+package persistence
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BarunKGP/timermq/internal/core"
+)
+
+// Save writes snap to path atomically: it is written to a temporary file in
+// the same directory, synced and renamed over path.
+func Save(path string, snap core.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the snapshot at path. A missing file is reported with an error
+// matching os.ErrNotExist.
+func Load(path string) (core.Snapshot, error) {
+	var snap core.Snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("Unable to parse snapshot %s: %w", path, err)
+	}
+	return snap, nil
+}
+
+// Probe checks that a snapshot could be saved to path by writing and syncing
+// a small file next to it.
+func Probe(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.probe")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write([]byte("probe")); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	return tmp.Close()
+}
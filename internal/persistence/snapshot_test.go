@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/google/uuid"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if _, err := Load(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a missing snapshot, found %v", err)
+	}
+
+	snap := core.Snapshot{
+		TakenAt: time.Now().UTC().Truncate(time.Millisecond),
+		Messages: []core.SnapshotMessage{
+			{Id: uuid.New(), Queue: "emails", Data: []byte("welcome"), Due: time.Now().Add(time.Hour).UTC()},
+		},
+	}
+	if err := Save(path, snap); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.TakenAt.Equal(snap.TakenAt) || len(got.Messages) != 1 {
+		t.Fatalf("Unexpected snapshot %+v", got)
+	}
+	m := got.Messages[0]
+	if m.Id != snap.Messages[0].Id || m.Queue != "emails" || string(m.Data) != "welcome" || !m.Due.Equal(snap.Messages[0].Due) {
+		t.Errorf("Unexpected message %+v", m)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the snapshot file, found %d entries", len(entries))
+	}
+}
+
+func TestProbe(t *testing.T) {
+	dir := t.TempDir()
+	if err := Probe(filepath.Join(dir, "snapshot.json")); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the probe to clean up, found %d entries", len(entries))
+	}
+	if err := Probe(filepath.Join(dir, "missing", "snapshot.json")); err == nil {
+		t.Error("Expected probing a missing directory to fail")
+	}
+}
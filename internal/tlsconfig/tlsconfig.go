@@ -0,0 +1,254 @@
+// Package tlsconfig builds the TLS settings shared by every listener, and
+// reloads certificates when their files change so that they can be rotated
+// without restarting the broker.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidOptions = errors.New("Invalid TLS options")
+	ErrNoCertificates = errors.New("No certificates found")
+)
+
+// ReloadInterval is how often Watch checks whether the files changed.
+const ReloadInterval = 10 * time.Second
+
+// Client certificate policies.
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+type Options struct {
+	// Cert and Key are the PEM files of the server certificate.
+	Cert string
+	Key  string
+	// ClientCA is the PEM bundle client certificates are verified against.
+	ClientCA string
+	// ClientAuth is "none", "request" (verify a certificate if the client
+	// sends one) or "require".
+	ClientAuth string
+	// MinVersion is "1.2" or "1.3".
+	MinVersion string
+	// CipherSuites restricts the TLS 1.2 cipher suites, by name. TLS 1.3
+	// suites are not configurable.
+	CipherSuites []string
+}
+
+// Validate reports every problem with opts that can be found without
+// reading the files.
+func (o Options) Validate() error {
+	var errs []error
+	if o.Cert == "" || o.Key == "" {
+		errs = append(errs, errors.New("cert and key are required"))
+	}
+	switch o.ClientAuth {
+	case "", ClientAuthNone:
+	case ClientAuthRequest, ClientAuthRequire:
+		if o.ClientCA == "" {
+			errs = append(errs, fmt.Errorf("clientCA is required to verify client certificates"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("clientAuth must be none, request or require, found %q", o.ClientAuth))
+	}
+	if _, err := minVersion(o.MinVersion); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cipherSuites(o.CipherSuites); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOptions, err)
+	}
+	return nil
+}
+
+func minVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("minVersion must be 1.2 or 1.3, found %q", v)
+	}
+}
+
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byName := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		byName[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	var errs []error
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown or insecure cipher suite %q", name))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Join(errs...)
+}
+
+// Reloader serves the certificates of its Options, reloading them when
+// asked to or when Watch notices that the files changed.
+type Reloader struct {
+	opts       Options
+	minVersion uint16
+	suites     []uint16
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// Load reads the files of opts.
+func Load(opts Options) (*Reloader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	r := &Reloader{opts: opts}
+	r.minVersion, _ = minVersion(opts.MinVersion)
+	r.suites, _ = cipherSuites(opts.CipherSuites)
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. The certificates in use are kept if any of
+// them is invalid.
+func (r *Reloader) Reload() error {
+	modTimes := r.stat()
+	cert, err := tls.LoadX509KeyPair(r.opts.Cert, r.opts.Key)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.opts.ClientCA != "" {
+		data, err := os.ReadFile(r.opts.ClientCA)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%w in %s", ErrNoCertificates, r.opts.ClientCA)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCA, r.modTimes = &cert, pool, modTimes
+	return nil
+}
+
+func (r *Reloader) stat() map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, path := range []string{r.opts.Cert, r.opts.Key, r.opts.ClientCA} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for path, t := range r.stat() {
+		if !t.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the certificates every interval in which their files
+// changed, until the returned function is called.
+func (r *Reloader) Watch(interval time.Duration) func() {
+	if r == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.Reload(); err != nil {
+					slog.Error("Unable to reload TLS certificates, keeping the current ones", "error", err)
+					continue
+				}
+				slog.Info("Reloaded TLS certificates", "cert", r.opts.Cert)
+			}
+		}
+	}()
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Config returns a server config that always uses the latest certificates.
+// A nil Reloader returns nil, for listeners without TLS.
+func (r *Reloader) Config() *tls.Config {
+	if r == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current(), nil
+		},
+	}
+}
+
+func (r *Reloader) current() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{*r.cert},
+		MinVersion:   r.minVersion,
+		CipherSuites: r.suites,
+		ClientCAs:    r.clientCA,
+	}
+	switch r.opts.ClientAuth {
+	case ClientAuthRequest:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// Identity returns the common name of the verified client certificate of a
+// connection, or "" if the client did not present one.
+func Identity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
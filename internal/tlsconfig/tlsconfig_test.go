@@ -0,0 +1,144 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/tlsconfig/tlstest"
+)
+
+// serve accepts TLS connections with cfg and reports the identity of each
+// client, or "error" if its handshake failed.
+func serve(t *testing.T, cfg *tls.Config) (string, <-chan string) {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	identities := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tc := conn.(*tls.Conn)
+			if err := tc.Handshake(); err != nil {
+				identities <- "error"
+			} else {
+				identities <- Identity(tc.ConnectionState())
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), identities
+}
+
+func dial(addr string, cfg *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// The server verifies client certificates after the client considers
+	// the handshake done under TLS 1.3, so wait for it to hang up.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.Read(make([]byte, 1))
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestReload(t *testing.T) {
+	ca := tlstest.NewCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.Issue(t, "first").Write(t, dir, "server")
+
+	r, err := Load(Options{Cert: certFile, Key: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := serve(t, r.Config())
+	client := &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"}
+	if cn, err := dial(addr, client); err != nil || cn != "first" {
+		t.Fatalf("Expected the first certificate, found %q, %v", cn, err)
+	}
+
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	if err := r.Reload(); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+	if cn, _ := dial(addr, client); cn != "first" {
+		t.Errorf("Expected the first certificate to be kept, found %q", cn)
+	}
+
+	ca.Issue(t, "second").Write(t, dir, "server")
+	// Make the change visible on filesystems with coarse timestamps.
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	defer r.Watch(10 * time.Millisecond)()
+	deadline := time.Now().Add(time.Second)
+	cn := ""
+	for cn != "second" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		cn, _ = dial(addr, client)
+	}
+	if cn != "second" {
+		t.Errorf("Expected Watch to pick up the second certificate, found %q", cn)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	ca := tlstest.NewCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.Issue(t, "server").Write(t, dir, "server")
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, ca.PEM, 0o600)
+
+	r, err := Load(Options{Cert: certFile, Key: keyFile, ClientCA: caFile, ClientAuth: ClientAuthRequire, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, identities := serve(t, r.Config())
+
+	client := &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"}
+	dial(addr, client)
+	if id := <-identities; id != "error" {
+		t.Errorf("Expected a client without a certificate to be rejected, found %q", id)
+	}
+
+	client.Certificates = []tls.Certificate{ca.Issue(t, "orders").TLS(t)}
+	if _, err := dial(addr, client); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-identities; id != "orders" {
+		t.Errorf("Expected the client to be identified as orders, found %q", id)
+	}
+
+	stranger := tlstest.NewCA(t).Issue(t, "orders").TLS(t)
+	client.Certificates = []tls.Certificate{stranger}
+	dial(addr, client)
+	if id := <-identities; id != "error" {
+		t.Errorf("Expected a certificate of another CA to be rejected, found %q", id)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := Options{
+		ClientAuth:   ClientAuthRequire,
+		MinVersion:   "1.0",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"},
+	}.Validate()
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Fatalf("Expected ErrInvalidOptions, received %v", err)
+	}
+	for _, want := range []string{"cert and key", "clientCA", "minVersion", "TLS_RSA_WITH_RC4_128_SHA"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q to be reported, found %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidTraceparent = errors.New("Invalid traceparent")
+	ErrInvalidTracestate  = errors.New("Invalid tracestate")
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+const flagSampled = 0x01
+
+// SpanContext identifies a span, as carried by the W3C traceparent and
+// tracestate headers.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header. Versions after 00 are
+// accepted as long as they start with the fields of version 00.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') {
+		return sc, fmt.Errorf("%w %q", ErrInvalidTraceparent, s)
+	}
+	version, traceID, spanID, flags := s[0:2], s[3:35], s[36:52], s[53:55]
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' || !lowerHex(s[:55]) {
+		return sc, fmt.Errorf("%w %q", ErrInvalidTraceparent, s)
+	}
+	if version == "ff" || (version == "00" && len(s) != 55) {
+		return sc, fmt.Errorf("%w %q: unsupported version", ErrInvalidTraceparent, s)
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+	var f [1]byte
+	hex.Decode(f[:], []byte(flags))
+	sc.Flags = f[0]
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return sc, fmt.Errorf("%w %q: ids must not be all zeroes", ErrInvalidTraceparent, s)
+	}
+	return sc, nil
+}
+
+// lowerHex reports whether s holds only lower-case hex digits and dashes.
+func lowerHex(s string) bool {
+	for _, c := range s {
+		if c != '-' && (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidTracestate checks the shape of a W3C tracestate header: at most 32
+// comma-separated key=value members and 512 characters. Members are not
+// interpreted, only passed along.
+func ValidTracestate(s string) error {
+	if len(s) > 512 {
+		return fmt.Errorf("%w: longer than 512 characters", ErrInvalidTracestate)
+	}
+	members := strings.Split(s, ",")
+	if len(members) > 32 {
+		return fmt.Errorf("%w: more than 32 members", ErrInvalidTracestate)
+	}
+	for _, m := range members {
+		key, value, ok := strings.Cut(strings.TrimSpace(m), "=")
+		if !ok || key == "" || value == "" {
+			return fmt.Errorf("%w: malformed member %q", ErrInvalidTracestate, m)
+		}
+	}
+	return nil
+}
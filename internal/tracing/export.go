@@ -0,0 +1,200 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrUnknownExporter = errors.New("Unknown trace exporter")
+
+// Options describe where spans are exported.
+type Options struct {
+	// Exporter is "stdout" or "otlp-file".
+	Exporter string
+	// File is the path spans are appended to by the otlp-file exporter.
+	File string
+}
+
+// Open builds the exporter described by opts.
+func Open(opts Options) (Exporter, error) {
+	switch opts.Exporter {
+	case "stdout":
+		return NewWriterExporter(nopCloser{os.Stdout}), nil
+	case "otlp-file":
+		if opts.File == "" {
+			return nil, errors.New("The otlp-file exporter needs a file")
+		}
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return NewOTLPExporter(f), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownExporter, opts.Exporter)
+	}
+}
+
+// WriterExporter writes each span as a line of JSON.
+type WriterExporter struct {
+	w io.WriteCloser
+}
+
+func NewWriterExporter(w io.WriteCloser) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+func (e *WriterExporter) Export(s *Span) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+func (e *WriterExporter) Close() error {
+	return e.w.Close()
+}
+
+// OTLPExporter writes each span as a line holding an OTLP/JSON
+// ExportTraceServiceRequest, the format read by the OpenTelemetry
+// Collector's file receiver.
+type OTLPExporter struct {
+	w io.WriteCloser
+}
+
+func NewOTLPExporter(w io.WriteCloser) *OTLPExporter {
+	return &OTLPExporter{w: w}
+}
+
+// OTLP span kinds.
+var otlpKinds = map[Kind]int{
+	KindInternal: 1,
+	KindProducer: 4,
+	KindConsumer: 5,
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	res := make([]otlpAttribute, len(attrs))
+	for i, a := range attrs {
+		res[i] = otlpAttribute{Key: a.Key, Value: otlpValue{StringValue: a.Value}}
+	}
+	return res
+}
+
+type otlpEvent struct {
+	Name         string          `json:"name"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID    TraceID `json:"traceId"`
+	SpanID     SpanID  `json:"spanId"`
+	TraceState string  `json:"traceState,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           TraceID         `json:"traceId"`
+	SpanID            SpanID          `json:"spanId"`
+	ParentSpanID      SpanID          `json:"parentSpanId"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+	Links             []otlpLink      `json:"links,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (e *OTLPExporter) Export(s *Span) error {
+	span := otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		TraceState:        s.ctx.State,
+		Name:              s.Name,
+		Kind:              otlpKinds[s.Kind],
+		StartTimeUnixNano: unixNano(s.Start),
+		EndTimeUnixNano:   unixNano(s.End),
+		Attributes:        otlpAttributes(s.Attributes),
+	}
+	for _, ev := range s.Events {
+		span.Events = append(span.Events, otlpEvent{Name: ev.Name, TimeUnixNano: unixNano(ev.Time), Attributes: otlpAttributes(ev.Attributes)})
+	}
+	for _, l := range s.Links {
+		span.Links = append(span.Links, otlpLink{TraceID: l.TraceID, SpanID: l.SpanID, TraceState: l.State})
+	}
+	if s.Error != "" {
+		// STATUS_CODE_ERROR
+		span.Status = &otlpStatus{Code: 2, Message: s.Error}
+	}
+
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{{Key: "service.name", Value: "timermq"}})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/BarunKGP/timermq"}, Spans: []otlpSpan{span}}},
+	}}}
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+func (e *OTLPExporter) Close() error {
+	return e.w.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,255 @@
+// Package tracing records spans for messages published with a W3C trace
+// context, so that a trace follows a message from its producer through the
+// wait for its timer to its consumer.
+//
+// Every method on a nil *Tracer or *Span is a no-op, so callers do not need
+// to check whether tracing is enabled.
+package tracing
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/google/uuid"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindProducer
+	KindConsumer
+)
+
+var kindNames = map[Kind]string{
+	KindInternal: "internal",
+	KindProducer: "producer",
+	KindConsumer: "consumer",
+}
+
+func (k Kind) String() string { return kindNames[k] }
+
+func (k Kind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+type Attribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type Event struct {
+	Name       string      `json:"name"`
+	Time       time.Time   `json:"time"`
+	Attributes []Attribute `json:"attributes,omitempty"`
+}
+
+type Link struct {
+	TraceID TraceID `json:"traceId"`
+	SpanID  SpanID  `json:"spanId"`
+	State   string  `json:"traceState,omitempty"`
+}
+
+// Span is a finished or running operation on a message.
+type Span struct {
+	Name         string      `json:"name"`
+	Kind         Kind        `json:"kind"`
+	TraceID      TraceID     `json:"traceId"`
+	SpanID       SpanID      `json:"spanId"`
+	ParentSpanID SpanID      `json:"parentSpanId"`
+	Start        time.Time   `json:"start"`
+	End          time.Time   `json:"end"`
+	Attributes   []Attribute `json:"attributes,omitempty"`
+	Events       []Event     `json:"events,omitempty"`
+	Links        []Link      `json:"links,omitempty"`
+	// Error describes why the operation failed, if it did.
+	Error string `json:"error,omitempty"`
+
+	ctx    SpanContext
+	tracer *Tracer
+}
+
+// Context returns the span context children of s descend from.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// Traceparent returns the traceparent header identifying s, or "" for a nil
+// span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return s.ctx.Traceparent()
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+func (s *Span) AddEvent(name string, at time.Time, attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.Events = append(s.Events, Event{Name: name, Time: at, Attributes: attrs})
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// Finish ends s at the given time and exports it if its trace is sampled.
+func (s *Span) Finish(at time.Time) {
+	if s == nil {
+		return
+	}
+	s.End = at
+	if s.ctx.Sampled() {
+		s.tracer.export(s)
+	}
+}
+
+// Exporter ships finished spans. Export is never called concurrently.
+type Exporter interface {
+	Export(s *Span) error
+	Close() error
+}
+
+// Tracer records the spans of traced messages and hands them to an exporter.
+// Messages published without a trace context are not traced.
+type Tracer struct {
+	mu       sync.Mutex
+	exporter Exporter
+	closed   bool
+
+	// waits holds the running wait span of each pending traced message.
+	waits   map[core.MessageId]*Span
+	waitsMu sync.Mutex
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, waits: map[core.MessageId]*Span{}}
+}
+
+// start begins a span descending from the traceparent parent, or returns nil
+// if parent cannot be parsed.
+func (t *Tracer) start(name string, kind Kind, parent, state string, at time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	pc, err := ParseTraceparent(parent)
+	if err != nil {
+		return nil
+	}
+	ctx := SpanContext{TraceID: pc.TraceID, SpanID: newSpanID(), Flags: pc.Flags, State: state}
+	return &Span{
+		Name:         name,
+		Kind:         kind,
+		TraceID:      ctx.TraceID,
+		SpanID:       ctx.SpanID,
+		ParentSpanID: pc.SpanID,
+		Start:        at,
+		ctx:          ctx,
+		tracer:       t,
+	}
+}
+
+func messageSpan(s *Span, id uuid.UUID, queue string) *Span {
+	s.SetAttribute("messaging.system", "timermq")
+	s.SetAttribute("messaging.destination.name", queue)
+	s.SetAttribute("messaging.message.id", id.String())
+	return s
+}
+
+// StartPublish begins the span recording the publish of a message whose
+// producer sent tc. The message should be published with the span's
+// traceparent as tc.Publish.
+func (t *Tracer) StartPublish(tc core.TraceContext, id uuid.UUID, queue string) *Span {
+	s := t.start("publish "+queue, KindProducer, tc.Producer, tc.State, time.Now())
+	if s == nil {
+		return nil
+	}
+	return messageSpan(s, id, queue)
+}
+
+// StartDelivery begins the span recording the delivery of a message to a
+// subscriber. It descends from the publish and links back to the producer.
+func (t *Tracer) StartDelivery(tc core.TraceContext, id uuid.UUID, queue string) *Span {
+	s := t.start("deliver "+queue, KindConsumer, tc.Parent(), tc.State, time.Now())
+	if s == nil {
+		return nil
+	}
+	if producer, err := ParseTraceparent(tc.Producer); err == nil {
+		s.Links = append(s.Links, Link{TraceID: producer.TraceID, SpanID: producer.SpanID, State: tc.State})
+	}
+	return messageSpan(s, id, queue)
+}
+
+// Watch records a wait span for every traced message of broker, from its
+// publish until its timer fires or it is cancelled or expires.
+func (t *Tracer) Watch(broker *core.Broker) (stop func()) {
+	if t == nil {
+		return func() {}
+	}
+	return broker.Observe(func(e core.Event) {
+		t.waitsMu.Lock()
+		defer t.waitsMu.Unlock()
+
+		switch e.Type {
+		case core.EventPublished, core.EventReplayed:
+			tc, ok := broker.Trace(e.Id)
+			if !ok {
+				return
+			}
+			s := t.start("wait "+e.Queue, KindInternal, tc.Parent(), tc.State, e.At)
+			if s == nil {
+				return
+			}
+			messageSpan(s, e.Id, e.Queue).SetAttribute("timermq.due", e.Due.Format(time.RFC3339Nano))
+			t.waits[e.Id] = s
+		case core.EventRescheduled:
+			t.waits[e.Id].AddEvent("rescheduled", e.At, Attribute{Key: "timermq.due", Value: e.Due.Format(time.RFC3339Nano)})
+		case core.EventFired, core.EventCancelled, core.EventExpired:
+			s, ok := t.waits[e.Id]
+			if !ok {
+				return
+			}
+			delete(t.waits, e.Id)
+			s.SetAttribute("timermq.outcome", string(e.Type))
+			s.Finish(e.At)
+		}
+	})
+}
+
+func (t *Tracer) export(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if err := t.exporter.Export(s); err != nil {
+		slog.Error("Unable to export span", "error", err, "span", s.Name, "traceId", s.TraceID)
+	}
+}
+
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	return t.exporter.Close()
+}
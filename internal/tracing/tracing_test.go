@@ -0,0 +1,180 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/clock/clocktest"
+	"github.com/BarunKGP/timermq/internal/core"
+)
+
+const producer = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memoryExporter) Export(s *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+func (e *memoryExporter) Close() error { return nil }
+
+func (e *memoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (*buffer) Close() error { return nil }
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent(producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled() {
+		t.Errorf("Unexpected span context %+v", sc)
+	}
+	if sc.Traceparent() != producer {
+		t.Errorf("Expected %s to format back to itself, found %s", producer, sc.Traceparent())
+	}
+
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"); err != nil {
+		t.Errorf("Expected later versions to be accepted, received %v", err)
+	}
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestValidTracestate(t *testing.T) {
+	if err := ValidTracestate("rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"); err != nil {
+		t.Errorf("Expected a valid tracestate, received %v", err)
+	}
+	for _, invalid := range []string{"rojo", "=1", "rojo=", strings.Repeat("a=b,", 33) + "a=b"} {
+		if err := ValidTracestate(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestMessageSpans(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	broker := core.NewBroker(4).WithClock(clk)
+	defer broker.Close()
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	defer tracer.Watch(broker)()
+
+	id := core.NewMessageId()
+	tc := core.TraceContext{Producer: producer, State: "rojo=1"}
+	publish := tracer.StartPublish(tc, id, "emails")
+	tc.Publish = publish.Traceparent()
+	broker.Publish(id, "emails", []byte("traced"), core.PublishOpts{Delay: time.Second, Trace: tc})
+	publish.Finish(time.Now())
+	broker.Publish(core.NewMessageId(), "emails", []byte("untraced"), core.PublishOpts{Delay: time.Second})
+	tmq, _ := broker.Lookup("emails")
+	if err := tmq.Reschedule(id, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(2 * time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for len(exporter.Spans()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stored, _ := broker.Trace(id)
+	tracer.StartDelivery(stored, id, "emails").Finish(time.Now())
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected publish, wait and deliver spans, found %+v", spans)
+	}
+	pub, wait, deliver := spans[0], spans[1], spans[2]
+	want, _ := ParseTraceparent(producer)
+	for _, s := range spans {
+		if s.TraceID != want.TraceID {
+			t.Errorf("Expected span %s in trace %s, found %s", s.Name, want.TraceID, s.TraceID)
+		}
+	}
+	if pub.Kind != KindProducer || pub.ParentSpanID != want.SpanID {
+		t.Errorf("Expected the publish span to be a child of the producer, found %+v", pub)
+	}
+	if wait.Name != "wait emails" || wait.ParentSpanID != pub.SpanID || !wait.End.Equal(clk.Now()) || len(wait.Events) != 1 {
+		t.Errorf("Unexpected wait span %+v", wait)
+	}
+	if deliver.Kind != KindConsumer || deliver.ParentSpanID != pub.SpanID {
+		t.Errorf("Expected the deliver span to be a child of the publish span, found %+v", deliver)
+	}
+	if len(deliver.Links) != 1 || deliver.Links[0].SpanID != want.SpanID || deliver.Links[0].State != "rojo=1" {
+		t.Errorf("Expected the deliver span to link to the producer, found %+v", deliver.Links)
+	}
+}
+
+func TestUnsampled(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	span := tracer.StartPublish(core.TraceContext{Producer: strings.TrimSuffix(producer, "01") + "00"}, core.NewMessageId(), "emails")
+	span.Finish(time.Now())
+	if n := len(exporter.Spans()); n != 0 {
+		t.Errorf("Expected spans of unsampled traces not to be exported, found %d", n)
+	}
+	if !strings.HasSuffix(span.Traceparent(), "-00") {
+		t.Errorf("Expected the unsampled flag to be propagated, found %s", span.Traceparent())
+	}
+
+	var disabled *Tracer
+	if s := disabled.StartPublish(core.TraceContext{Producer: producer}, core.NewMessageId(), "emails"); s != nil || s.Traceparent() != "" {
+		t.Errorf("Expected a nil tracer to start nil spans, found %+v", s)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var out buffer
+	tracer := NewTracer(NewOTLPExporter(&out))
+	span := tracer.StartPublish(core.TraceContext{Producer: producer}, core.NewMessageId(), "emails")
+	span.Finish(span.Start.Add(time.Millisecond))
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string `json:"traceId"`
+					ParentSpanID      string `json:"parentSpanId"`
+					Kind              int    `json:"kind"`
+					StartTimeUnixNano string `json:"startTimeUnixNano"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &req); err != nil {
+		t.Fatalf("Invalid OTLP line %q: %v", out.String(), err)
+	}
+	s := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != "00f067aa0ba902b7" || s.Kind != 4 || s.StartTimeUnixNano == "" {
+		t.Errorf("Unexpected OTLP span %+v", s)
+	}
+}
@@ -3,46 +3,93 @@ package values
 import (
 	"errors"
 	"fmt"
-
-	"github.com/google/uuid"
+	"slices"
 )
 
 type CommandMethod string
-type MessageIndex uuid.UUID
 
 const (
-	Push   CommandMethod = "PUSH"
-	Get                  = "GET"
-	Delay                = "DELAY"
-	Cancel               = "CANCEL"
-	Ping                 = "PING"
+	Push        CommandMethod = "PUSH"
+	Get                       = "GET"
+	Delay                     = "DELAY"
+	Cancel                    = "CANCEL"
+	Ping                      = "PING"
+	Subscribe                 = "SUBSCRIBE"
+	Observe                   = "OBSERVE"
+	Queues                    = "QUEUES"
+	Timers                    = "TIMERS"
+	DeadLetters               = "DLQ"
+	Replay                    = "REPLAY"
+	Info                      = "INFO"
+	Stats                     = "STATS"
+	Ack                       = "ACK"
+	Health                    = "HEALTH"
+	Auth                      = "AUTH"
+	Resize                    = "RESIZE"
 )
 
 var MinimumRequiredArgs = map[CommandMethod]int{
-	Push:   1,
-	Get:    1,
-	Cancel: 1,
-	Delay:  2,
-	Ping:   0,
+	Push:        1,
+	Get:         1,
+	Cancel:      1,
+	Delay:       2,
+	Ping:        0,
+	Subscribe:   0,
+	Observe:     0,
+	Queues:      0,
+	Timers:      0,
+	DeadLetters: 0,
+	Replay:      1,
+	Info:        0,
+	Stats:       0,
+	Ack:         1,
+	Health:      0,
+	Auth:        2,
+	Resize:      3,
 }
 var (
-	ErrMsgTooShort = errors.New("Invalid message: message missing essential parameters")
+	ErrMsgTooShort    = errors.New("Invalid message: message missing essential parameters")
+	ErrUnknownCommand = errors.New("Unrecognized command")
 )
 
 var commandMap = map[string]CommandMethod{
-	"PUSH":   Push,
-	"GET":    Get,
-	"CANCEL": Cancel,
+	"PUSH":      Push,
+	"GET":       Get,
+	"CANCEL":    Cancel,
+	"DELAY":     Delay,
+	"PING":      Ping,
+	"SUBSCRIBE": Subscribe,
+	"OBSERVE":   Observe,
+	"QUEUES":    Queues,
+	"TIMERS":    Timers,
+	"DLQ":       DeadLetters,
+	"REPLAY":    Replay,
+	"INFO":      Info,
+	"STATS":     Stats,
+	"ACK":       Ack,
+	"HEALTH":    Health,
+	"AUTH":      Auth,
+	"RESIZE":    Resize,
 }
 
 func CmdFromString(s string) (CommandMethod, error) {
 	c, ok := commandMap[s]
 	if !ok {
-		return CommandMethod(""), fmt.Errorf("Unrecognized command %s", s)
+		return CommandMethod(""), fmt.Errorf("%w %s", ErrUnknownCommand, s)
 	}
 	return c, nil
 }
 
+// Commands lists the commands the server understands, sorted by name.
+func Commands() []CommandMethod {
+	cmds := make([]CommandMethod, 0, len(commandMap))
+	for _, c := range commandMap {
+		cmds = append(cmds, c)
+	}
+	slices.Sort(cmds)
+	return cmds
+}
+
 func ParseValidateCommand(words []string) (CommandMethod, error) {
 	if len(words) < 1 {
 		return CommandMethod(""), ErrMsgTooShort
@@ -0,0 +1,5 @@
+package values
+
+// Version of the server, reported by INFO. Release builds set it with
+// -ldflags "-X github.com/BarunKGP/timermq/internal/values.Version=<version>".
+var Version = "dev"
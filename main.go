@@ -2,11 +2,41 @@ package main
 
 import (
 	"fmt"
+	"os"
 )
 
+const usage = `timermq - a timer-based message queue, written in Golang
+
+Usage:
+  timermq serve [flags]    start the broker
+  timermq hash-secret      hash a secret read from stdin for a credentials file
+                           (-token generates a bearer token instead)
+  timermq help             show this message
+
+Run "timermq serve -h" for the serve flags.
+`
+
 func main() {
-	fmt.Println("Hello from timermq - a timer-based message queue, written in Golang")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
 
-	ports := []string{"tcp", "http", "amqp"}
-	_ = ports[1]
+	switch os.Args[1] {
+	case "serve":
+		if err := serve(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "timermq:", err)
+			os.Exit(1)
+		}
+	case "hash-secret":
+		if err := hashSecret(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "timermq:", err)
+			os.Exit(1)
+		}
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "timermq: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BarunKGP/timermq/internal/auth"
+)
+
+// hashSecret prints the hash of a secret read from stdin, for the users of a
+// credentials file, or with -token generates a bearer token and prints it
+// along with its hash.
+func hashSecret(args []string) error {
+	fs := flag.NewFlagSet("hash-secret", flag.ContinueOnError)
+	token := fs.Bool("token", false, "generate a bearer token for the HTTP listener instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("the secret is read from stdin, not from arguments")
+	}
+
+	if *token {
+		t, err := auth.NewToken()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("token: %s\nhash:  %s\n", t, auth.HashToken(t))
+		return nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		if err != nil {
+			return fmt.Errorf("unable to read the secret from stdin: %w", err)
+		}
+		return errors.New("the secret must not be empty")
+	}
+	if strings.ContainsAny(secret, " \t") {
+		return errors.New("the secret must not contain whitespace")
+	}
+	hash, err := auth.HashSecret(secret)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
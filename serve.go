@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/adapters/servers"
+	"github.com/BarunKGP/timermq/internal/auth"
+	"github.com/BarunKGP/timermq/internal/config"
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/metrics"
+	"github.com/BarunKGP/timermq/internal/persistence"
+	"github.com/BarunKGP/timermq/internal/tlsconfig"
+)
+
+// loadConfig resolves the config from defaults, the config file, the
+// environment and the parsed flags, in that order.
+func loadConfig(fs *flag.FlagSet, path string) (config.Config, error) {
+	if path == "" {
+		path = os.Getenv(config.EnvName("config"))
+	}
+
+	cfg := config.Default()
+	if path != "" {
+		var err error
+		if cfg, err = config.Load(path); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	var errs []error
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := config.Settings[f.Name]; ok {
+			if err := cfg.Set(f.Name, f.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	path := fs.String("config", "", "path to a JSON config file (env "+config.EnvName("config")+")")
+
+	settings := make([]string, 0, len(config.Settings))
+	for name := range config.Settings {
+		settings = append(settings, name)
+	}
+	sort.Strings(settings)
+	for _, name := range settings {
+		fs.String(name, "", config.Settings[name]+" (env "+config.EnvName(name)+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(fs, *path)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	logger, logOut, err := cfg.Logger()
+	if err != nil {
+		return err
+	}
+	defer logOut.Close()
+	slog.SetDefault(logger)
+
+	store, err := cfg.Authenticator()
+	if err != nil {
+		return err
+	}
+	certs, err := cfg.Certificates()
+	if err != nil {
+		return err
+	}
+	defer certs.Watch(tlsconfig.ReloadInterval)()
+
+	if cfg.Queue.Overflow == core.OverflowSpill {
+		if err := os.MkdirAll(cfg.Queue.SpillDir, 0o700); err != nil {
+			return err
+		}
+	}
+	broker := core.NewBroker(cfg.Queue.Capacity).
+		WithOverflow(cfg.Queue.Overflow, cfg.Queue.SpillDir).
+		WithCapacity(cfg.Queue.Admit).
+		WithRetention(time.Duration(cfg.Queue.Retention)).
+		WithDedupWindow(time.Duration(cfg.Queue.Dedup)).
+		WithSpread(cfg.Queue.Spread.Spread())
+	for queue, window := range cfg.Queue.DedupWindows {
+		broker.SetDedupWindow(queue, time.Duration(window))
+	}
+	for queue, spread := range cfg.Queue.Spreads {
+		broker.SetSpread(queue, spread.Spread())
+	}
+	defer broker.Close()
+	// Publishing compacts the queue published to, and the ticker the others.
+	// With a zero retention, the ticker runs every second.
+	defer broker.CompactEvery(max(time.Duration(cfg.Queue.Retention), time.Second))()
+	checker := cfg.Checker(broker)
+	stopped := make(chan struct{}, len(cfg.Listeners)+2)
+
+	// The HTTP listeners start first, so that readiness can be probed
+	// while the snapshot is restored. Health checks are always served, and
+	// on the metrics listener too when there is one.
+	muxes := map[string]*http.ServeMux{cfg.Health.Addr: http.NewServeMux()}
+	if cfg.Metrics.Addr != "" {
+		reg := metrics.NewRegistry()
+		broker.WithMetrics(reg)
+		if muxes[cfg.Metrics.Addr] == nil {
+			muxes[cfg.Metrics.Addr] = http.NewServeMux()
+		}
+		muxes[cfg.Metrics.Addr].Handle("GET /metrics", reg.Handler())
+	}
+	httpSrvs := make([]*http.Server, 0, len(muxes))
+	for addr, mux := range muxes {
+		checker.Register(mux)
+		srv := &http.Server{Addr: addr, Handler: protect(mux, store), TLSConfig: certs.Config()}
+		defer srv.Close()
+		httpSrvs = append(httpSrvs, srv)
+		go func() {
+			slog.Info("Serving HTTP", "address", srv.Addr, "metrics", addr == cfg.Metrics.Addr, "tls", certs != nil)
+			listen := srv.ListenAndServe
+			if certs != nil {
+				listen = func() error { return srv.ListenAndServeTLS("", "") }
+			}
+			if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP listener failed", "error", err)
+			}
+			stopped <- struct{}{}
+		}()
+	}
+	auditor, err := cfg.Auditor()
+	if err != nil {
+		return err
+	}
+	defer auditor.Close()
+	defer auditor.Watch(broker)()
+	tracer, err := cfg.Tracer()
+	if err != nil {
+		return err
+	}
+	defer tracer.Close()
+	defer tracer.Watch(broker)()
+
+	checker.SetRestoring(true)
+	if err := restore(broker, cfg.Persistence.Snapshot); err != nil {
+		return err
+	}
+	checker.SetRestoring(false)
+
+	// Listeners share the limiter, so that a client cannot get around its
+	// limits by connecting to each of them.
+	limiter := cfg.Limiter()
+	srvs := make([]servers.Server, 0, len(cfg.Listeners))
+	for _, opts := range cfg.Listeners {
+		srv, err := servers.NewServer(opts.Protocol, opts, broker,
+			servers.WithAudit(auditor), servers.WithTracer(tracer), servers.WithHealth(checker), servers.WithAuth(store),
+			servers.WithTLS(certs.Config()), servers.WithLimits(limiter))
+		if err != nil {
+			return err
+		}
+		srvs = append(srvs, srv)
+	}
+
+	for _, srv := range srvs {
+		go func() {
+			srv.Start()
+			stopped <- struct{}{}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	if certs != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for range hup {
+				if err := certs.Reload(); err != nil {
+					slog.Error("Unable to reload TLS certificates, keeping the current ones", "error", err)
+					continue
+				}
+				slog.Info("Reloaded TLS certificates")
+			}
+		}()
+	}
+
+	select {
+	case s := <-sig:
+		slog.Info("Shutting down", "signal", s.String())
+	case <-stopped:
+		err = errors.New("a listener stopped unexpectedly")
+		slog.Error("Shutting down", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Shutdown.Timeout))
+	defer cancel()
+	go func() {
+		if s, ok := <-sig; ok {
+			slog.Warn("Skipping the rest of the graceful shutdown", "signal", s.String())
+			cancel()
+		}
+	}()
+
+	checker.SetShuttingDown()
+	shutdown(ctx, cfg, broker, srvs)
+	for _, srv := range httpSrvs {
+		srv.Shutdown(ctx)
+	}
+	return err
+}
+
+// protect requires a bearer token for every route of mux but /healthz, which
+// liveness probes must reach without credentials. A nil store leaves mux
+// open.
+func protect(mux *http.ServeMux, store *auth.Store) http.Handler {
+	if store == nil {
+		return mux
+	}
+	outer := http.NewServeMux()
+	outer.Handle("GET /healthz", mux)
+	outer.Handle("/", store.Protect(mux))
+	return outer
+}
+
+// shutdown stops the servers in stages: it stops accepting connections and
+// finishes in-flight commands, delivers the messages due within the drain
+// window, says goodbye to subscribers and finally snapshots the durable
+// messages left. Stages still running when ctx is done are cut short.
+func shutdown(ctx context.Context, cfg config.Config, broker *core.Broker, srvs []servers.Server) {
+	for _, srv := range srvs {
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Warn("In-flight commands were interrupted", "error", err)
+		}
+	}
+
+	if drain := time.Duration(cfg.Shutdown.Drain); drain > 0 {
+		slog.Info("Draining messages", "window", drain)
+		if err := broker.Drain(ctx, drain); err != nil {
+			slog.Warn("Drain did not complete", "error", err)
+		}
+	}
+
+	for _, srv := range srvs {
+		if err := srv.Close(); err != nil {
+			slog.Warn("Unable to close server", "error", err)
+		}
+	}
+
+	if path := cfg.Persistence.Snapshot; path != "" {
+		snap := broker.Snapshot()
+		if err := persistence.Save(path, snap); err != nil {
+			slog.Error("Unable to save snapshot, durable messages are lost", "path", path, "error", err, "messages", len(snap.Messages))
+			return
+		}
+		slog.Info("Saved snapshot", "path", path, "messages", len(snap.Messages))
+	}
+}
+
+// restore republishes the messages of the snapshot at path, if there is one.
+// The file is kept until shutdown replaces it, so that a crash before then
+// does not lose the messages restored from it.
+func restore(broker *core.Broker, path string) error {
+	if path == "" {
+		return nil
+	}
+	snap, err := persistence.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	broker.Restore(snap)
+	slog.Info("Restored snapshot", "path", path, "messages", len(snap.Messages), "takenAt", snap.TakenAt)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/BarunKGP/timermq/internal/core"
+	"github.com/BarunKGP/timermq/internal/persistence"
+	"github.com/google/uuid"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServeHealthWithoutMetrics(t *testing.T) {
+	// Keep SIGINT from killing the test binary before serve listens for it.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT)
+	defer signal.Stop(sig)
+
+	addr := freeAddr(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- serve([]string{"-tcp", freeAddr(t), "-health", addr, "-log-output", "stdout", "-log-level", "error"})
+	}()
+
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		if resp, err = http.Get("http://" + addr + "/healthz"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected /healthz to be served without metrics, received %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /healthz to reply 200, found %d", resp.StatusCode)
+	}
+	if resp, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected no /metrics without metrics.addr, found %d", resp.StatusCode)
+		}
+	}
+
+	// serve may not listen for signals yet, so keep asking until it stops.
+	for {
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Expected a clean shutdown, received %v", err)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestRestoreKeepsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	snap := core.Snapshot{
+		TakenAt: time.Now().UTC(),
+		Messages: []core.SnapshotMessage{
+			{Id: uuid.New(), Queue: "emails", Data: []byte("welcome"), Due: time.Now().Add(time.Hour).UTC()},
+		},
+	}
+	if err := persistence.Save(path, snap); err != nil {
+		t.Fatal(err)
+	}
+
+	broker := core.NewBroker(16)
+	defer broker.Close()
+	if err := restore(broker, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := persistence.Load(path)
+	if err != nil {
+		t.Fatalf("Expected the snapshot to be kept after restoring it, received %v", err)
+	}
+	if len(got.Messages) != 1 {
+		t.Errorf("Expected the snapshot to be left unchanged, found %+v", got)
+	}
+}